@@ -0,0 +1,301 @@
+package libICP
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// MAC algorithms for authenticated data. The MAC key has the same size as the hash output.
+const (
+	MAC_HMAC_SHA256 = "1.2.840.113549.2.9"
+	MAC_HMAC_SHA384 = "1.2.840.113549.2.10"
+	MAC_HMAC_SHA512 = "1.2.840.113549.2.11"
+)
+
+// See RFC 5652 Section 9
+type authenticated_data_raw struct {
+	RawContent       asn1.RawContent
+	Version          int
+	OriginatorInfo   asn1.RawValue   `asn1:"tag:0,optional,omitempty"`
+	RecipientInfos   []asn1.RawValue `asn1:"set"`
+	MACAlgorithm     algorithm_identifier_decode
+	DigestAlgorithm  algorithm_identifier_decode `asn1:"tag:1,optional,omitempty"`
+	EncapContentInfo encapsulated_content_info
+	AuthAttrs        asn1.RawValue `asn1:"tag:2,optional,omitempty"`
+	MAC              []byte
+	UnauthAttrs      asn1.RawValue `asn1:"tag:3,optional,omitempty"`
+}
+
+// Represents a CMS authenticated data (see RFC 5652 Section 9). Its content is NOT encrypted, but only the recipients can check (with their private keys) that it was not changed.
+type AuthenticatedData struct {
+	base authenticated_data_raw
+
+	FilePath string
+	FileName string
+	// OID of the content type (usually data)
+	ContentType string
+	// OID of the MAC algorithm (ex: MAC_HMAC_SHA256)
+	MACAlgorithm string
+	// OID of the digest algorithm used for the message digest attribute. It is empty if there are no authenticated attributes.
+	DigestAlgorithm string
+	// It is nil for detached content.
+	Content    []byte
+	Recipients []Recipient
+}
+
+// Computes a MAC of the content with a new random key, which is then encrypted for each recipient. The content is attached to the result. If empty, the MAC and key transport algorithms default to MAC_HMAC_SHA256 and KEY_TRANSPORT_RSA_OAEP respectively.
+//
+// Possible errors are: ERR_NO_RECIPIENTS, ERR_UNKOWN_ALGORITHM, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE, ERR_PARSE_CERT, ERR_PARSE_RSA_PUBKEY
+func AuthenticateBytes(content []byte, recipients []*Certificate, mac_alg, key_alg string) (*AuthenticatedData, CodedError) {
+	if mac_alg == "" {
+		mac_alg = MAC_HMAC_SHA256
+	}
+	if key_alg == "" {
+		key_alg = KEY_TRANSPORT_RSA_OAEP
+	}
+	if len(recipients) == 0 {
+		return nil, NewMultiError("no recipients to authenticate to", ERR_NO_RECIPIENTS, nil)
+	}
+	mac_hash, ok := hmac_algs[mac_alg]
+	if !ok {
+		merr := NewMultiError("unknown MAC algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", mac_alg)
+		return nil, merr
+	}
+
+	ad_raw := authenticated_data_raw{}
+	ad_raw.MACAlgorithm.Algorithm = str2oid_key(mac_alg)
+	ad_raw.MACAlgorithm.Parameters = asn1.RawValue{Tag: asn1.TagNull}
+	ad_raw.DigestAlgorithm.Algorithm = idSha256
+	ad_raw.EncapContentInfo.EContentType = idData
+	ad_raw.EncapContentInfo.EContent = content
+
+	// The MAC covers the authenticated attributes, which include the content digest (see RFC 5652 Section 9.2)
+	digest, cerr := get_hasher_and_run(algorithm_identifier{Algorithm: idSha256}, content)
+	if cerr != nil {
+		return nil, cerr
+	}
+	attrs := []attribute{
+		{Type: idContentType, Values: []interface{}{idData}},
+		{Type: idMessageDigest, Values: []interface{}{digest}},
+	}
+	set_raw, err := asn1.MarshalWithParams(attrs, "set")
+	if err != nil {
+		return nil, NewMultiError("failed to marshal authenticated attributes", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	ad_raw.AuthAttrs.FullBytes = append([]byte{}, set_raw...)
+	ad_raw.AuthAttrs.FullBytes[0] = 0xA2
+
+	key, cerr := random_bytes(mac_hash().Size())
+	if cerr != nil {
+		return nil, cerr
+	}
+	mac := hmac.New(mac_hash, key)
+	mac.Write(set_raw)
+	ad_raw.MAC = mac.Sum(nil)
+
+	ad := new_authenticated_data(ad_raw)
+	if ad.Recipients, cerr = new_recipients(recipients, key_alg, key); cerr != nil {
+		return nil, cerr
+	}
+	return ad, nil
+}
+
+// Same as AuthenticateBytes but reads the content from a file.
+func AuthenticateFile(path string, recipients []*Certificate, mac_alg, key_alg string) (*AuthenticatedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read file to authenticate", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	return AuthenticateBytes(dat, recipients, mac_alg, key_alg)
+}
+
+func new_authenticated_data(ad_raw authenticated_data_raw) *AuthenticatedData {
+	ad := new(AuthenticatedData)
+	ad.base = ad_raw
+	ad.ContentType = ad_raw.EncapContentInfo.EContentType.String()
+	ad.MACAlgorithm = ad_raw.MACAlgorithm.Algorithm.String()
+	if len(ad_raw.DigestAlgorithm.Algorithm) > 0 {
+		ad.DigestAlgorithm = ad_raw.DigestAlgorithm.Algorithm.String()
+	}
+	ad.Content = ad_raw.EncapContentInfo.EContent
+	return ad
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS"), DER and BER.
+//
+// Possible errors are: ERR_PARSE_AUTHENTICATED_DATA
+func NewAuthenticatedDataFromBytes(raw []byte) (*AuthenticatedData, CodedError) {
+	ci, err := unmarshal_content_info(raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_AUTHENTICATED_DATA, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	return new_authenticated_data_from_content_info(ci)
+}
+
+func new_authenticated_data_from_content_info(ci content_info_decode) (*AuthenticatedData, CodedError) {
+	if !ci.ContentType.Equal(idCtAuthData) {
+		merr := NewMultiError("CMS content is not authenticated data", ERR_PARSE_AUTHENTICATED_DATA, nil)
+		merr.SetParam("content-type", ci.ContentType.String())
+		return nil, merr
+	}
+	ad_raw := authenticated_data_raw{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ad_raw); err != nil {
+		merr := NewMultiError("failed to parse CMS authenticated data", ERR_PARSE_AUTHENTICATED_DATA, nil, err)
+		merr.SetParam("raw-data", ci.Content.Bytes)
+		return nil, merr
+	}
+	ad := new_authenticated_data(ad_raw)
+	var cerr CodedError
+	if ad.Recipients, cerr = parse_recipient_infos(ad_raw.RecipientInfos, ERR_PARSE_AUTHENTICATED_DATA); cerr != nil {
+		return nil, cerr
+	}
+	return ad, nil
+}
+
+// Same as NewAuthenticatedDataFromBytes but reads it from a file.
+func NewAuthenticatedDataFromFile(path string) (*AuthenticatedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read authenticated data file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	ad, cerr := NewAuthenticatedDataFromBytes(dat)
+	if cerr != nil {
+		return nil, cerr
+	}
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		ad.FilePath = abs_path
+		ad.FileName = filepath.Base(abs_path)
+	}
+	return ad, nil
+}
+
+// Checks the MAC (and the content digest, if there are authenticated attributes) with the key decrypted with the private key of the given PFX, which MUST be one of the recipients. Returns the content.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_NO_PRIVATE_KEY, ERR_NOT_RECIPIENT, ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT, ERR_SECURE_RANDOM, ERR_PARSE_SIGNATURE, ERR_CONTENT_MISMATCH, ERR_BAD_DIGEST, ERR_BAD_MAC
+func (ad *AuthenticatedData) Verify(pfx PFX) ([]byte, CodedError) {
+	if ad.Content == nil {
+		return nil, NewMultiError("authenticated data has no attached content", ERR_NO_CONTENT, nil)
+	}
+	mac_hash, ok := hmac_algs[ad.MACAlgorithm]
+	if !ok {
+		merr := NewMultiError("unknown MAC algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", ad.MACAlgorithm)
+		return nil, merr
+	}
+	key, cerr := recipients_key(ad.Recipients, pfx, mac_hash().Size())
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	mac_input := ad.Content
+	if len(ad.base.AuthAttrs.FullBytes) > 0 {
+		var attrs []attribute
+		mac_input, attrs, cerr = decode_attributes(ad.base.AuthAttrs)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if cerr := ad.check_auth_attrs(attrs); cerr != nil {
+			return nil, cerr
+		}
+	}
+
+	mac := hmac.New(mac_hash, key)
+	mac.Write(mac_input)
+	if !hmac.Equal(mac.Sum(nil), ad.base.MAC) {
+		return nil, NewMultiError("MAC does not match the content", ERR_BAD_MAC, nil)
+	}
+	return ad.Content, nil
+}
+
+// The content type and message digest attributes MUST be present (see RFC 5652 Section 9.2)
+func (ad *AuthenticatedData) check_auth_attrs(attrs []attribute) CodedError {
+	var content_type asn1.ObjectIdentifier
+	var digest []byte
+	for _, attr := range attrs {
+		switch {
+		case attr.Type.Equal(idContentType):
+			attr.first_value(&content_type)
+		case attr.Type.Equal(idMessageDigest):
+			attr.first_value(&digest)
+		}
+	}
+	if !content_type.Equal(ad.base.EncapContentInfo.EContentType) {
+		merr := NewMultiError("content type attribute does not match the content type", ERR_CONTENT_MISMATCH, nil)
+		merr.SetParam("expected", ad.ContentType)
+		merr.SetParam("actual", content_type.String())
+		return merr
+	}
+	actual, cerr := get_hasher_and_run(algorithm_identifier{Algorithm: ad.base.DigestAlgorithm.Algorithm}, ad.Content)
+	if cerr != nil {
+		return cerr
+	}
+	if !bytes.Equal(actual, digest) {
+		merr := NewMultiError("message digest attribute does not match the content", ERR_BAD_DIGEST, nil)
+		merr.SetParam("expected", nice_hex(digest))
+		merr.SetParam("actual", nice_hex(actual))
+		return merr
+	}
+	return nil
+}
+
+// Returns the authenticated data wrapped in a content info (see RFC 5652 Section 3) encoded as DER.
+func (ad *AuthenticatedData) MarshalDER() ([]byte, CodedError) {
+	recipient_infos, _, cerr := marshal_recipient_infos(ad.Recipients)
+	if cerr != nil {
+		return nil, cerr
+	}
+	ad_raw := ad.base
+	ad_raw.RawContent = nil
+	ad_raw.EncapContentInfo.RawContent = nil
+	ad_raw.RecipientInfos = recipient_infos
+	dat, err := asn1.Marshal(ad_raw)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal authenticated data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return marshal_content_info(idCtAuthData, dat)
+}
+
+// Same as MarshalDER but encoded as PEM with block type "PKCS7".
+func (ad *AuthenticatedData) MarshalPEM() ([]byte, CodedError) {
+	dat, cerr := ad.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+	block := &pem.Block{Type: "PKCS7", Bytes: dat}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Saves the authenticated data as DER.
+func (ad *AuthenticatedData) SaveToFile(path string) CodedError {
+	dat, cerr := ad.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	err := ioutil.WriteFile(path, dat, 0644)
+	if err != nil {
+		merr := NewMultiError("failed to write to file", ERR_FAILED_TO_WRITE_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		ad.FilePath = abs_path
+		ad.FileName = filepath.Base(abs_path)
+	}
+	return nil
+}
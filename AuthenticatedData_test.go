@@ -0,0 +1,71 @@
+package libICP
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AuthenticateBytes_1(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	pfx := get_ciclano_pfx(t)
+	for _, mac_alg := range []string{MAC_HMAC_SHA256, MAC_HMAC_SHA384, MAC_HMAC_SHA512} {
+		for _, key_alg := range []string{KEY_TRANSPORT_RSA_PKCS1V15, KEY_TRANSPORT_RSA_OAEP} {
+			ad, cerr := AuthenticateBytes(content, []*Certificate{pfx.Cert}, mac_alg, key_alg)
+			require.Nil(t, cerr, "%s %s", mac_alg, key_alg)
+
+			// Marshal and parse it again
+			raw, cerr := ad.MarshalDER()
+			require.Nil(t, cerr)
+			ad, cerr = NewAuthenticatedDataFromBytes(raw)
+			require.Nil(t, cerr)
+			assert.Equal(t, mac_alg, ad.MACAlgorithm)
+			assert.Equal(t, DIGEST_SHA256, ad.DigestAlgorithm)
+			assert.Equal(t, idData.String(), ad.ContentType)
+			require.Equal(t, 1, len(ad.Recipients))
+			assert.Equal(t, key_alg, ad.Recipients[0].KeyTransportAlgorithm)
+
+			dat, cerr := ad.Verify(pfx)
+			require.Nil(t, cerr, "%s %s", mac_alg, key_alg)
+			assert.Equal(t, content, dat)
+		}
+	}
+}
+
+func Test_AuthenticateBytes_2(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	_, cerr := AuthenticateBytes([]byte("abc"), nil, "", "")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_RECIPIENTS, cerr.Code())
+
+	_, cerr = AuthenticateBytes([]byte("abc"), []*Certificate{pfx.Cert}, DIGEST_SHA256, "")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_AuthenticatedData_Verify_1(t *testing.T) {
+	ciclano := get_ciclano_pfx(t)
+	deltrano, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+	ad, cerr := AuthenticateBytes([]byte("The quick fox jumps over the lazy dog."), []*Certificate{ciclano.Cert}, "", "")
+	require.Nil(t, cerr)
+
+	// Not a recipient
+	_, cerr = ad.Verify(deltrano)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NOT_RECIPIENT, cerr.Code())
+
+	// Changed content
+	ad.Content = []byte("The quick fox jumps over the lazy cat.")
+	_, cerr = ad.Verify(ciclano)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_DIGEST, cerr.Code())
+
+	// Changed MAC
+	ad.Content = ad.base.EncapContentInfo.EContent
+	ad.base.MAC[0] ^= 0xFF
+	_, cerr = ad.Verify(ciclano)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_MAC, cerr.Code())
+}
@@ -23,6 +23,10 @@ type CAStore struct {
 	wg           *sync.WaitGroup
 	Debug        bool
 	CachePath    string
+	// Rules of the signature policies used when verifying signatures. Init sets it to NewPolicyStore() if it is nil.
+	Policies *PolicyStore
+	// Certificate policies accepted when verifying certificates and signers. They are not used when adding CAs. Restricting the accepted policies requires both InitialPolicySet and RequireExplicitPolicy.
+	CertPolicies CertPolicyParams
 }
 
 func NewCAStore(AutoDownload bool) *CAStore {
@@ -41,6 +45,9 @@ func (store *CAStore) Init() {
 	}
 	store.wg = new(sync.WaitGroup)
 	store.cas_lock = new(sync.RWMutex)
+	if store.Policies == nil {
+		store.Policies = NewPolicyStore()
+	}
 	// Get our root certificates
 	certs, errs := NewCertificateFromBytes([]byte(ROOT_CA_BR_ICP_V1 + ROOT_CA_BR_ICP_V2 + ROOT_CA_BR_ICP_V5))
 	if errs != nil {
@@ -112,21 +119,28 @@ func (store *CAStore) AddCAsFromDir(path string) error {
 	return nil
 }
 
-// For now, this functions verifies: validity, integrity, propper chain of certification.
+// For now, this functions verifies: validity, integrity, propper chain of certification and certificate policies (according to CAStore.CertPolicies).
 //
-// Some of the error codes this may return are: ERR_NOT_BEFORE_DATE, ERR_NOT_AFTER_DATE, ERR_BAD_SIGNATURE, ERR_ISSUER_NOT_FOUND, ERR_MAX_DEPTH_REACHED
+// Some of the error codes this may return are: ERR_NOT_BEFORE_DATE, ERR_NOT_AFTER_DATE, ERR_BAD_SIGNATURE, ERR_ISSUER_NOT_FOUND, ERR_MAX_DEPTH_REACHED, ERR_NO_VALID_POLICY, ERR_INVALID_POLICY_MAPPING
 func (store CAStore) VerifyCert(cert_to_verify *Certificate) ([]*Certificate, []CodedError, []CodedWarning) {
+	path, _, errs, warns := store.verify_cert_at(cert_to_verify, time.Now())
+	return path, errs, warns
+}
+
+// Same as VerifyCert but also returns the valid policy tree (see RFC 5280 Section 6.1), which is nil if no policy is valid for the path. Use PolicyNode.Policies to get the policies the certificate is valid for.
+func (store CAStore) VerifyCertWithPolicyTree(cert_to_verify *Certificate) ([]*Certificate, *PolicyNode, []CodedError, []CodedWarning) {
 	return store.verify_cert_at(cert_to_verify, time.Now())
 }
 
-func (store CAStore) verify_cert_at(cert_to_verify *Certificate, now time.Time) ([]*Certificate, []CodedError, []CodedWarning) {
+// The certificate policies are processed according to store.CertPolicies.
+func (store CAStore) verify_cert_at(cert_to_verify *Certificate, now time.Time) ([]*Certificate, *PolicyNode, []CodedError, []CodedWarning) {
 	ans_errs := make([]CodedError, 0)
 	ans_warns := make([]CodedWarning, 0)
 	// Get certification path
 	path, err := store.build_path(cert_to_verify, _PATH_BUILDING_MAX_DEPTH)
 	if err != nil {
 		ans_errs = append(ans_errs, err)
-		return nil, ans_errs, nil
+		return nil, nil, ans_errs, nil
 	}
 	last_ca_max_ca_i := -1
 	last_ca_subj := ""
@@ -195,13 +209,19 @@ func (store CAStore) verify_cert_at(cert_to_verify *Certificate, now time.Time)
 		}
 	}
 
+	tree, err := process_cert_policies(path, store.CertPolicies)
+	if err != nil {
+		ans_errs = append(ans_errs, err)
+	}
+	ans_errs = append(ans_errs, process_name_constraints(path)...)
+
 	if len(ans_errs) == 0 {
 		ans_errs = nil
 	}
 	if len(ans_warns) == 0 {
 		ans_warns = nil
 	}
-	return path, ans_errs, ans_warns
+	return path, tree, ans_errs, ans_warns
 }
 
 // Adds a new root CA for testing proposes. It MUST have as subject and issuer: TESTING_ROOT_CA_SUBJECT
@@ -233,13 +253,20 @@ func (store *CAStore) add_ca_at_time(cert *Certificate, now time.Time) []CodedEr
 		}
 		return []CodedError{NewMultiError("certificate is not a certificate authority", ERR_NOT_CA, nil)}
 	}
-	if _, errs, _ := store.verify_cert_at(cert, now); errs != nil {
+	if _, _, errs, _ := store.without_cert_policies().verify_cert_at(cert, now); errs != nil {
 		return errs
 	}
 	store.direct_add_ca(cert)
 	return nil
 }
 
+// Returns a copy of the store which accepts certificates issued under any policy. The certificate policies of the store are meant for signers, so they are not applied to the CAs being added nor to time stamp authorities.
+func (store *CAStore) without_cert_policies() *CAStore {
+	ans := *store
+	ans.CertPolicies = CertPolicyParams{}
+	return &ans
+}
+
 func (store *CAStore) direct_add_ca(cert *Certificate) {
 	if cert == nil {
 		return
@@ -411,3 +438,20 @@ func (store CAStore) list_CRLs() map[string]bool {
 
 	return urls_set
 }
+
+// Returns a copy of the list of CAs (without duplicates)
+func (store CAStore) list_CAs() []*Certificate {
+	seen := make(map[*Certificate]bool)
+	ans := make([]*Certificate, 0)
+
+	store.cas_lock.RLock()
+	defer store.cas_lock.RUnlock()
+	for _, ca := range store.cas {
+		if !seen[ca] {
+			seen[ca] = true
+			ans = append(ans, ca)
+		}
+	}
+
+	return ans
+}
@@ -71,14 +71,14 @@ func Test_CAStore_VerifyCertAt_1(t *testing.T) {
 
 	right_ans := []*Certificate{root}
 	some_time := time.Unix(1528997864, 0)
-	path, errs, warns := store.verify_cert_at(root, some_time)
+	path, _, errs, warns := store.verify_cert_at(root, some_time)
 	assert.Nil(t, errs)
 	assert.Equal(t, right_ans, path)
 	assert.Equal(t, 1, len(warns))
 	assert.EqualValues(t, ERR_UNKOWN_REVOCATION_STATUS, warns[0].Code())
 
 	right_ans = []*Certificate{end_cert, root}
-	path, errs, warns = store.verify_cert_at(end_cert, some_time)
+	path, _, errs, warns = store.verify_cert_at(end_cert, some_time)
 	assert.Nil(t, errs)
 	assert.Equal(t, right_ans, path)
 	assert.Equal(t, 2, len(warns))
@@ -96,7 +96,7 @@ func Test_CAStore_VerifyCertAt_2(t *testing.T) {
 
 	right_ans := []*Certificate{root}
 	some_time := time.Unix(0, 0)
-	path, errs, warns := store.verify_cert_at(root, some_time)
+	path, _, errs, warns := store.verify_cert_at(root, some_time)
 	assert.Equal(t, 1, len(warns))
 	assert.Equal(t, right_ans, path)
 	assert.EqualValues(t, ERR_UNKOWN_REVOCATION_STATUS, warns[0].Code())
@@ -104,7 +104,7 @@ func Test_CAStore_VerifyCertAt_2(t *testing.T) {
 	assert.EqualValues(t, ERR_NOT_BEFORE_DATE, errs[0].Code())
 
 	right_ans = []*Certificate{end_cert, root}
-	path, errs, warns = store.verify_cert_at(end_cert, some_time)
+	path, _, errs, warns = store.verify_cert_at(end_cert, some_time)
 	assert.Equal(t, 2, len(warns))
 	assert.Equal(t, right_ans, path)
 	assert.EqualValues(t, ERR_UNKOWN_REVOCATION_STATUS, warns[0].Code())
@@ -122,7 +122,7 @@ func Test_CAStore_VerifyCertAt_3(t *testing.T) {
 	end_cert := certs[0]
 
 	some_time := time.Unix(0, 0)
-	path, errs, warns := store.verify_cert_at(end_cert, some_time)
+	path, _, errs, warns := store.verify_cert_at(end_cert, some_time)
 	assert.Nil(t, warns)
 	assert.Nil(t, path)
 	assert.NotNil(t, errs)
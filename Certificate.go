@@ -4,6 +4,7 @@ import (
 	"encoding/pem"
 	"io/ioutil"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -26,9 +27,18 @@ type Certificate struct {
 	FingerPrintAlg              string
 	FingerPrint                 []byte
 	FingerPrintHuman            string
+	ICPBrasil                   ICPBrasilData
+	Policies                    []CertificatePolicy
 	ext_key_usage               ext_key_usage
+	ext_extended_key_usage      ext_extended_key_usage
 	ext_basic_constraints       ext_basic_constraints
 	ext_crl_distribution_points ext_crl_distribution_points
+	ext_subject_alt_name        ext_subject_alt_name
+	ext_certificate_policies    ext_certificate_policies
+	ext_policy_mappings         ext_policy_mappings
+	ext_policy_constraints      ext_policy_constraints
+	ext_inhibit_any_policy      ext_inhibit_any_policy
+	ext_name_constraints        ext_name_constraints
 	// This is the crl published by this certificate, not the crl about this certificate
 	crl certificate_list
 	// These are calculated based on the CRL made by this cert issuer
@@ -171,8 +181,122 @@ func (cert *Certificate) init() {
 	}
 }
 
-// func (cert Certificate) ValidFor(usage CERT_USAGE) CodedError {
-// }
+// Returns the issuer (exactly as encoded on the certificate) and the serial number. Used to identify a signer on CMS.
+func (cert Certificate) issuer_and_serial() (issuer_and_serial_number, CodedError) {
+	names := tbs_certificate_names_decode{}
+	_, err := asn1.Unmarshal(cert.base.TBSCertificate.RawContent, &names)
+	if err != nil {
+		merr := NewMultiError("failed to parse certificate issuer", ERR_PARSE_CERT, nil, err)
+		merr.SetParam("cert.Subject", cert.Subject)
+		return issuer_and_serial_number{}, merr
+	}
+	ans := issuer_and_serial_number{}
+	ans.Issuer = names.Issuer
+	ans.SerialNumber = names.SerialNumber
+	return ans, nil
+}
+
+type CERT_USAGE int
+
+const (
+	// CMS signatures (AD-RB, AD-RT, etc.)
+	CERT_USAGE_DOCUMENT_SIGNING CERT_USAGE = iota
+	// RFC 3161 time stamp tokens
+	CERT_USAGE_TIMESTAMPING
+	// OCSP responses signed by a delegated responder
+	CERT_USAGE_OCSP_SIGNING
+	// TLS client authentication
+	CERT_USAGE_CLIENT_AUTH
+	// S/MIME
+	CERT_USAGE_EMAIL_PROTECTION
+	CERT_USAGE_CRL_SIGNING
+)
+
+var cert_usage_map_string = map[CERT_USAGE]string{
+	CERT_USAGE_DOCUMENT_SIGNING: "CERT_USAGE_DOCUMENT_SIGNING",
+	CERT_USAGE_TIMESTAMPING:     "CERT_USAGE_TIMESTAMPING",
+	CERT_USAGE_OCSP_SIGNING:     "CERT_USAGE_OCSP_SIGNING",
+	CERT_USAGE_CLIENT_AUTH:      "CERT_USAGE_CLIENT_AUTH",
+	CERT_USAGE_EMAIL_PROTECTION: "CERT_USAGE_EMAIL_PROTECTION",
+	CERT_USAGE_CRL_SIGNING:      "CERT_USAGE_CRL_SIGNING",
+}
+
+func (usage CERT_USAGE) String() string {
+	ans, ok := cert_usage_map_string[usage]
+	if !ok {
+		ans = "CERT_USAGE_" + strconv.Itoa(int(usage))
+	}
+	return ans
+}
+
+type cert_usage_rule struct {
+	// Returns true if at least one of the acceptable key usage bits is set
+	key_usage func(ext_key_usage) bool
+	// If the extended key usage extension exists, it MUST have at least one of them (or anyExtendedKeyUsage, unless purpose_required is set). May be nil
+	purposes []asn1.ObjectIdentifier
+	// If true, the extended key usage extension MUST exist and have one of the purposes
+	purpose_required bool
+}
+
+func ku_signing(ku ext_key_usage) bool {
+	return ku.DigitalSignature || ku.NonRepudiation
+}
+
+var cert_usage_rules = map[CERT_USAGE]cert_usage_rule{
+	// ICP-Brasil end user certificates are issued for email protection and/or client authentication (see DOC-ICP-04)
+	CERT_USAGE_DOCUMENT_SIGNING: {key_usage: ku_signing, purposes: []asn1.ObjectIdentifier{idKpEmailProtection, idKpClientAuth}},
+	// See RFC 3161 Section 2.3
+	CERT_USAGE_TIMESTAMPING: {key_usage: ku_signing, purposes: []asn1.ObjectIdentifier{idKpTimeStamping}, purpose_required: true},
+	// See RFC 6960 Section 4.2.2.2
+	CERT_USAGE_OCSP_SIGNING: {key_usage: ku_signing, purposes: []asn1.ObjectIdentifier{idKpOCSPSigning}, purpose_required: true},
+	CERT_USAGE_CLIENT_AUTH: {key_usage: func(ku ext_key_usage) bool {
+		return ku.DigitalSignature || ku.KeyEncipherment || ku.KeyAgreement
+	}, purposes: []asn1.ObjectIdentifier{idKpClientAuth}},
+	CERT_USAGE_EMAIL_PROTECTION: {key_usage: func(ku ext_key_usage) bool {
+		return ku.DigitalSignature || ku.NonRepudiation || ku.KeyEncipherment || ku.KeyAgreement
+	}, purposes: []asn1.ObjectIdentifier{idKpEmailProtection}},
+	CERT_USAGE_CRL_SIGNING: {key_usage: func(ku ext_key_usage) bool {
+		return ku.CRLSign
+	}},
+}
+
+// Checks if the key usage and the extended key usage extensions allow the certificate to be used for the given purpose. (see RFC 5280 Section 4.2.1.3 and Section 4.2.1.12) Absent extensions do not restrict the usage, except for time stamping and OCSP signing, which require the matching extended key usage.
+//
+// Possible errors are: ERR_CERT_USAGE_NOT_ALLOWED
+func (cert Certificate) ValidFor(usage CERT_USAGE) CodedError {
+	fail := func(msg string) CodedError {
+		merr := NewMultiError(msg, ERR_CERT_USAGE_NOT_ALLOWED, nil)
+		merr.SetParam("usage", usage.String())
+		merr.SetParam("cert.Subject", cert.Subject)
+		return merr
+	}
+	rule, ok := cert_usage_rules[usage]
+	if !ok {
+		return fail("unknown certificate usage")
+	}
+	if cert.ext_key_usage.Exists && !rule.key_usage(cert.ext_key_usage) {
+		return fail("certificate key usage does not allow this usage")
+	}
+	if rule.purposes == nil {
+		return nil
+	}
+	eku := cert.ext_extended_key_usage
+	if !eku.Exists {
+		if rule.purpose_required {
+			return fail("certificate has no extended key usage")
+		}
+		return nil
+	}
+	for _, purpose := range rule.purposes {
+		if eku.Has(purpose) {
+			return nil
+		}
+	}
+	if !rule.purpose_required && eku.Has(idAnyExtendedKeyUsage) {
+		return nil
+	}
+	return fail("certificate extended key usage does not allow this usage")
+}
 
 func (cert Certificate) is_crl_outdated() bool {
 	now := time.Now()
@@ -194,6 +318,32 @@ func (cert Certificate) IsCA() bool {
 	return cert.ext_key_usage.Exists && cert.ext_key_usage.KeyCertSign && cert.ext_basic_constraints.Exists && cert.ext_basic_constraints.CA
 }
 
+// Returns true if the certificate policies extension has the given policy. (ex: "2.16.76.1.2.3.10")
+func (cert Certificate) HasPolicy(policy string) bool {
+	for _, item := range cert.Policies {
+		if item.ID == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// Fails unless the certificate is of one of the given ICP-Brasil types. Use it, for instance, to refuse A1 certificates, whose keys are stored in software, by allowing only CERT_TYPE_A3 and CERT_TYPE_A4.
+//
+// Possible errors are: ERR_CERT_TYPE_NOT_ALLOWED
+func (cert Certificate) CheckCertType(allowed ...string) CodedError {
+	for _, cert_type := range allowed {
+		if cert.ICPBrasil.CertType != "" && cert.ICPBrasil.CertType == cert_type {
+			return nil
+		}
+	}
+	merr := NewMultiError("certificate type not allowed", ERR_CERT_TYPE_NOT_ALLOWED, nil)
+	merr.SetParam("cert-type", cert.ICPBrasil.CertType)
+	merr.SetParam("allowed", allowed)
+	merr.SetParam("subject", cert.Subject)
+	return merr
+}
+
 // This checks ONLY the digital signature and if the issuer is a CA (via the BasicConstraints and KeyUsage extensions). It will fail if any of those two extensions are not present.
 //
 // Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_NOT_CA, ERR_PARSE_RSA_PUBKEY, ERR_BAD_SIGNATURE
@@ -224,7 +374,7 @@ func (cert Certificate) verify_signed_by(issuer Certificate) []CodedError {
 
 	// Verify signature
 	cerr := VerifySignaure(cert.base, pubkey)
-	if err == nil {
+	if cerr == nil {
 		return nil
 	}
 	return []CodedError{cerr}
@@ -272,6 +422,10 @@ func (cert *Certificate) parse_extensions() CodedError {
 				return err
 			}
 			cert.AuthorityKeyId = nice_hex(ext_key_id.KeyId)
+		case id.Equal(idCeExtKeyUsage):
+			if err := cert.ext_extended_key_usage.FromExtension(ext); err != nil {
+				return err
+			}
 		case id.Equal(idCeBasicConstraints):
 			if err := cert.ext_basic_constraints.FromExtension(ext); err != nil {
 				return err
@@ -284,6 +438,33 @@ func (cert *Certificate) parse_extensions() CodedError {
 			if err := cert.ext_crl_distribution_points.FromExtension(ext); err != nil {
 				return err
 			}
+		case id.Equal(idCeSubjectAltName):
+			if err := cert.ext_subject_alt_name.FromExtension(ext); err != nil {
+				return err
+			}
+			cert.ICPBrasil.from_other_names(cert.ext_subject_alt_name.OtherNames)
+		case id.Equal(idCeCertificatePolicies):
+			if err := cert.ext_certificate_policies.FromExtension(ext); err != nil {
+				return err
+			}
+			cert.Policies = cert.ext_certificate_policies.Policies
+			cert.ICPBrasil.CertType = icp_brasil_cert_type(cert.Policies)
+		case id.Equal(idCePolicyMappings):
+			if err := cert.ext_policy_mappings.FromExtension(ext); err != nil {
+				return err
+			}
+		case id.Equal(idCePolicyConstraints):
+			if err := cert.ext_policy_constraints.FromExtension(ext); err != nil {
+				return err
+			}
+		case id.Equal(idCeInhibitAnyPolicy):
+			if err := cert.ext_inhibit_any_policy.FromExtension(ext); err != nil {
+				return err
+			}
+		case id.Equal(idCeNameConstraints):
+			if err := cert.ext_name_constraints.FromExtension(ext); err != nil {
+				return err
+			}
 		default:
 			if ext.Critical {
 				merr := NewMultiError("unsupported critical extension", ERR_UNSUPORTED_CRITICAL_EXTENSION, nil)
@@ -313,6 +494,10 @@ func (cert Certificate) crl_has_cert(end_cert Certificate) bool {
 }
 
 func (cert *Certificate) process_CRL(new_crl certificate_list) CodedError {
+	if cerr := cert.ValidFor(CERT_USAGE_CRL_SIGNING); cerr != nil {
+		return cerr
+	}
+
 	// Verify signature
 	pubkey, err := cert.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
 	if err != nil {
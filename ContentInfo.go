@@ -0,0 +1,186 @@
+package libICP
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// The result of ParseContentInfo. Only the field matching the content type is set.
+type ContentInfo struct {
+	// OID of the content type (ex: "1.2.840.113549.1.7.2" for signed data)
+	ContentType string
+	Data        []byte
+	SignedData  *MultSignature
+	// Both enveloped data and authenticated-enveloped data
+	EnvelopedData     *Envelope
+	EncryptedData     *EncryptedData
+	DigestedData      *DigestedData
+	AuthenticatedData *AuthenticatedData
+}
+
+// Identifies the content type of a CMS object (see RFC 5652 Section 3) and parses it accordingly. Accepts PEM (with block type "PKCS7" or "CMS"), DER and BER. See also ParseCMS.
+//
+// Possible errors are: ERR_PARSE_CONTENT_INFO, ERR_UNKNOWN_CONTENT_TYPE, ERR_UNSUPPORTED_CONTENT_TYPE, ERR_PARSE_SIGNATURE, ERR_PARSE_ENVELOPED_DATA, ERR_PARSE_ENCRYPTED_DATA, ERR_PARSE_DIGESTED_DATA, ERR_PARSE_AUTHENTICATED_DATA
+func ParseContentInfo(raw []byte) (*ContentInfo, CodedError) {
+	ci, err := unmarshal_content_info(raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_CONTENT_INFO, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	ans, cerr := parse_content_info(ci)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return ans, nil
+}
+
+// PEM block types used for CMS objects by different tools.
+var cms_pem_types = []string{"PKCS7", "CMS", "PKCS #7 SIGNED DATA"}
+
+// Identifies and parses any CMS object, no matter how it was stored, so files can be routed without relying on their extensions. Accepts DER, BER (indefinite lengths), PEM (with block type "PKCS7", "CMS" or "PKCS #7 SIGNED DATA") and plain base64 encoded DER or BER.
+//
+// For known content types which are not supported (like PKCS #7 signed-and-enveloped data) and for unknown ones, the content type is still returned along with the error.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_PARSE_CONTENT_INFO, ERR_UNKNOWN_CONTENT_TYPE, ERR_UNSUPPORTED_CONTENT_TYPE, ERR_PARSE_SIGNATURE, ERR_PARSE_ENVELOPED_DATA, ERR_PARSE_ENCRYPTED_DATA, ERR_PARSE_DIGESTED_DATA, ERR_PARSE_AUTHENTICATED_DATA
+func ParseCMS(data []byte) (*ContentInfo, CodedError) {
+	raw, cerr := cms_to_binary(data)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	ci := content_info_decode{}
+	if _, err := asn1.Unmarshal(raw, &ci); err != nil {
+		// Large signed data files often use indefinite lengths, so they are streamed instead of converted to DER
+		if content_type, _ := peek_content_type(raw); content_type.Equal(idSignedData) {
+			msig, cerr := new_mult_signature_from_ber(raw)
+			if cerr != nil {
+				return nil, cerr
+			}
+			return &ContentInfo{ContentType: idSignedData.String(), SignedData: msig}, nil
+		}
+		if ci, err = unmarshal_content_info(raw); err != nil {
+			merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_CONTENT_INFO, nil, err)
+			merr.SetParam("raw-data", raw)
+			return nil, merr
+		}
+	}
+	return parse_content_info(ci)
+}
+
+// Removes the PEM or base64 encoding, if any.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_PARSE_CONTENT_INFO
+func cms_to_binary(data []byte) ([]byte, CodedError) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, NewMultiError("no CMS data", ERR_NO_CONTENT, nil)
+	}
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN ")) {
+		block, _ := pem.Decode(trimmed)
+		if block == nil {
+			return nil, NewMultiError("failed to decode PEM block", ERR_PARSE_CONTENT_INFO, nil)
+		}
+		for _, pem_type := range cms_pem_types {
+			if block.Type == pem_type {
+				return block.Bytes, nil
+			}
+		}
+		merr := NewMultiError("PEM block is not a CMS object", ERR_PARSE_CONTENT_INFO, nil)
+		merr.SetParam("pem-type", block.Type)
+		return nil, merr
+	}
+	// A content info is always a SEQUENCE
+	if data[0] == 0x30 {
+		return data, nil
+	}
+	dat, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(trimmed), nil)))
+	if err != nil || len(dat) == 0 || dat[0] != 0x30 {
+		merr := NewMultiError("data is not a CMS object", ERR_PARSE_CONTENT_INFO, nil)
+		if err != nil {
+			merr.AppendError(err)
+		}
+		return nil, merr
+	}
+	return dat, nil
+}
+
+// For unknown and unsupported content types, the content info is returned with only its content type set.
+func parse_content_info(ci content_info_decode) (*ContentInfo, CodedError) {
+	ans := new(ContentInfo)
+	ans.ContentType = ci.ContentType.String()
+	var err error
+	var cerr CodedError
+	switch {
+	case ci.ContentType.Equal(idData):
+		octets := asn1.RawValue{}
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &octets)
+		if err == nil {
+			ans.Data, err = octet_string_bytes(octets)
+		}
+		if err != nil || octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString {
+			merr := NewMultiError("failed to parse CMS data", ERR_PARSE_CONTENT_INFO, nil, err)
+			merr.SetParam("raw-data", ci.Content.Bytes)
+			return nil, merr
+		}
+	case ci.ContentType.Equal(idSignedData):
+		ans.SignedData, cerr = new_mult_signature_from_signed_data(ci.Content.Bytes)
+	case ci.ContentType.Equal(idEnvelopedData), ci.ContentType.Equal(idCtAuthEnvelopedData):
+		ans.EnvelopedData, cerr = new_envelope_from_content_info(ci)
+	case ci.ContentType.Equal(idEncryptedData):
+		ans.EncryptedData, cerr = new_encrypted_data_from_content_info(ci)
+	case ci.ContentType.Equal(idDigestData):
+		ans.DigestedData, cerr = new_digested_data_from_content_info(ci)
+	case ci.ContentType.Equal(idCtAuthData):
+		ans.AuthenticatedData, cerr = new_authenticated_data_from_content_info(ci)
+	case ci.ContentType.Equal(idSignedAndEnvelopedData):
+		// Removed from CMS (see RFC 5652 Section 1.1.1)
+		merr := NewMultiError("PKCS #7 signed-and-enveloped data is not supported", ERR_UNSUPPORTED_CONTENT_TYPE, nil)
+		merr.SetParam("content-type", ans.ContentType)
+		return ans, merr
+	default:
+		merr := NewMultiError("unknown CMS content type", ERR_UNKNOWN_CONTENT_TYPE, nil)
+		merr.SetParam("content-type", ans.ContentType)
+		return ans, merr
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return ans, nil
+}
+
+// Parses a CMS content info encoded as PEM (with block type "PKCS7" or "CMS"), DER or BER. The inner content is always DER.
+func unmarshal_content_info(raw []byte) (content_info_decode, error) {
+	block, _ := pem.Decode(raw)
+	if block != nil && (block.Type == "PKCS7" || block.Type == "CMS") {
+		raw = block.Bytes
+	}
+
+	ci := content_info_decode{}
+	_, err := asn1.Unmarshal(raw, &ci)
+	if err == nil {
+		return ci, nil
+	}
+	// Maybe it uses indefinite lengths (BER)
+	der, _, ber_err := new_ber_reader(bytes.NewReader(raw)).read_element_der()
+	if ber_err != nil {
+		return ci, err
+	}
+	_, err = asn1.Unmarshal(der, &ci)
+	return ci, err
+}
+
+// Wraps a DER encoded content in a content info.
+func marshal_content_info(content_type asn1.ObjectIdentifier, content []byte) ([]byte, CodedError) {
+	ci := content_info_decode{}
+	ci.ContentType = content_type
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+	dat, err := asn1.Marshal(ci)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal content info", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return dat, nil
+}
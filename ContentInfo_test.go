@@ -0,0 +1,154 @@
+package libICP
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+
+	"github.com/OpenICP-BR/asn1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseContentInfo_1(t *testing.T) {
+	for _, name := range []string{"data.der", "data_ber.der"} {
+		raw, err := ioutil.ReadFile("data/test-cms/" + name)
+		require.Nil(t, err)
+		ci, cerr := ParseContentInfo(raw)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, idData.String(), ci.ContentType)
+		assert.Equal(t, []byte("hello digest\n"), ci.Data)
+	}
+}
+
+func Test_ParseContentInfo_2(t *testing.T) {
+	tests := map[string]string{
+		"test-sigs/ciclano_attached.p7s":             idSignedData.String(),
+		"test-envelopes/ciclano_aes256_cbc_oaep.p7m": idEnvelopedData.String(),
+		"test-envelopes/ciclano_aes128_gcm_ber.p7m":  idCtAuthEnvelopedData.String(),
+		"test-envelopes/pkcs12_pbes2_aes256.p7m":     idEncryptedData.String(),
+		"test-cms/digested_sha256.der":               idDigestData.String(),
+		"test-cms/digested_sha1_ber.pem":             idDigestData.String(),
+	}
+	for name, content_type := range tests {
+		raw, err := ioutil.ReadFile("data/" + name)
+		require.Nil(t, err)
+		ci, cerr := ParseContentInfo(raw)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, content_type, ci.ContentType, name)
+		assert.Nil(t, ci.Data)
+		assert.Equal(t, content_type == idSignedData.String(), ci.SignedData != nil, name)
+		assert.Equal(t, content_type == idEnvelopedData.String() || content_type == idCtAuthEnvelopedData.String(), ci.EnvelopedData != nil, name)
+		assert.Equal(t, content_type == idEncryptedData.String(), ci.EncryptedData != nil, name)
+		assert.Equal(t, content_type == idDigestData.String(), ci.DigestedData != nil, name)
+		assert.Nil(t, ci.AuthenticatedData)
+	}
+}
+
+func Test_ParseContentInfo_3(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	ad, cerr := AuthenticateBytes([]byte("abc"), []*Certificate{pfx.Cert}, "", "")
+	require.Nil(t, cerr)
+	raw, cerr := ad.MarshalPEM()
+	require.Nil(t, cerr)
+	ci, cerr := ParseContentInfo(raw)
+	require.Nil(t, cerr)
+	assert.Equal(t, idCtAuthData.String(), ci.ContentType)
+	require.NotNil(t, ci.AuthenticatedData)
+	dat, cerr := ci.AuthenticatedData.Verify(pfx)
+	require.Nil(t, cerr)
+	assert.Equal(t, []byte("abc"), dat)
+}
+
+func Test_ParseContentInfo_4(t *testing.T) {
+	_, cerr := ParseContentInfo([]byte("not a CMS"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_CONTENT_INFO, cerr.Code())
+
+	raw, cerr := marshal_content_info(asn1.ObjectIdentifier{1, 2, 3, 4}, []byte{0x30, 0x00})
+	require.Nil(t, cerr)
+	_, cerr = ParseContentInfo(raw)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKNOWN_CONTENT_TYPE, cerr.Code())
+}
+
+func Test_ParseCMS_1(t *testing.T) {
+	der, err := ioutil.ReadFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, err)
+	ber, err := ioutil.ReadFile("data/test-sigs/ciclano_attached_ber.p7s")
+	require.Nil(t, err)
+	tests := map[string][]byte{
+		"DER":           der,
+		"BER":           ber,
+		"PEM":           pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der}),
+		"PEM (OpenSSL)": pem.EncodeToMemory(&pem.Block{Type: "PKCS #7 SIGNED DATA", Bytes: der}),
+		"base64":        []byte(base64.StdEncoding.EncodeToString(ber) + "\n"),
+	}
+	for name, raw := range tests {
+		ci, cerr := ParseCMS(raw)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, idSignedData.String(), ci.ContentType, name)
+		require.NotNil(t, ci.SignedData, name)
+		require.Equal(t, 1, len(ci.SignedData.Signatures), name)
+		assert.True(t, ci.SignedData.base.EncapContentInfo.IsHashable(), name)
+	}
+}
+
+func Test_ParseCMS_2(t *testing.T) {
+	tests := map[string]string{
+		"test-cms/data_ber.der":                     idData.String(),
+		"test-cms/digested_sha1_ber.pem":            idDigestData.String(),
+		"test-envelopes/ciclano_aes128_gcm_ber.p7m": idCtAuthEnvelopedData.String(),
+		"test-envelopes/pkcs12_pbe_3des.p7m":        idEncryptedData.String(),
+	}
+	for name, content_type := range tests {
+		raw, err := ioutil.ReadFile("data/" + name)
+		require.Nil(t, err)
+		ci, cerr := ParseCMS(raw)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, content_type, ci.ContentType, name)
+	}
+}
+
+func Test_ParseCMS_3(t *testing.T) {
+	// Known but not supported
+	raw, cerr := marshal_content_info(idSignedAndEnvelopedData, []byte{0x30, 0x00})
+	require.Nil(t, cerr)
+	ci, cerr := ParseCMS(raw)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNSUPPORTED_CONTENT_TYPE, cerr.Code())
+	require.NotNil(t, ci)
+	assert.Equal(t, idSignedAndEnvelopedData.String(), ci.ContentType)
+
+	// Unknown
+	raw, cerr = marshal_content_info(asn1.ObjectIdentifier{1, 2, 3, 4}, []byte{0x30, 0x00})
+	require.Nil(t, cerr)
+	ci, cerr = ParseCMS(raw)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKNOWN_CONTENT_TYPE, cerr.Code())
+	require.NotNil(t, ci)
+	assert.Equal(t, "1.2.3.4", ci.ContentType)
+}
+
+func Test_ParseCMS_4(t *testing.T) {
+	_, cerr := ParseCMS([]byte(" \n"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_CONTENT, cerr.Code())
+
+	cert, err := ioutil.ReadFile("data/test-chain/intermediate/fakebank/certs/ciclano.crt.pem")
+	require.Nil(t, err)
+	for _, raw := range [][]byte{cert, []byte("not a CMS"), {0x30, 0x03, 0x02, 0x01, 0x00}} {
+		_, cerr = ParseCMS(raw)
+		require.NotNil(t, cerr)
+		assert.EqualValues(t, ERR_PARSE_CONTENT_INFO, cerr.Code())
+	}
+}
+
+func Test_ParseCMS_5(t *testing.T) {
+	// Malformed BER signed data (the digest algorithms are missing)
+	raw := []byte{0x30, 0x80, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x02, 0xa0, 0x80, 0x30, 0x80, 0x02, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	_, cerr := ParseCMS(raw)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_SIGNATURE, cerr.Code())
+}
@@ -0,0 +1,205 @@
+package libICP
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// Digest algorithms
+const (
+	DIGEST_SHA1   = "1.3.14.3.2.26"
+	DIGEST_SHA256 = "2.16.840.1.101.3.4.2.1"
+	DIGEST_SHA384 = "2.16.840.1.101.3.4.2.2"
+	DIGEST_SHA512 = "2.16.840.1.101.3.4.2.3"
+)
+
+// See RFC 5652 Section 7
+type digested_data_raw struct {
+	RawContent       asn1.RawContent
+	Version          int
+	DigestAlgorithm  algorithm_identifier_decode
+	EncapContentInfo encapsulated_content_info
+	Digest           []byte
+}
+
+// Represents a CMS digested data (see RFC 5652 Section 7), which only protects the integrity of its content against accidental changes, as anyone can compute a new digest.
+type DigestedData struct {
+	base digested_data_raw
+
+	FilePath string
+	FileName string
+	// OID of the content type (usually data)
+	ContentType string
+	// OID of the digest algorithm (ex: DIGEST_SHA256)
+	DigestAlgorithm string
+	// It is nil for detached content.
+	Content []byte
+	Digest  []byte
+}
+
+// Digests the content, which is attached to the result. If empty, the digest algorithm defaults to DIGEST_SHA256.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM
+func DigestBytes(content []byte, digest_alg string) (*DigestedData, CodedError) {
+	if digest_alg == "" {
+		digest_alg = DIGEST_SHA256
+	}
+	dd_raw := digested_data_raw{}
+	dd_raw.DigestAlgorithm.Algorithm = str2oid_key(digest_alg)
+	dd_raw.EncapContentInfo.EContentType = idData
+	dd_raw.EncapContentInfo.EContent = content
+	digest, cerr := get_hasher_and_run(algorithm_identifier{Algorithm: dd_raw.DigestAlgorithm.Algorithm}, content)
+	if cerr != nil {
+		return nil, cerr
+	}
+	dd_raw.Digest = digest
+	return new_digested_data(dd_raw), nil
+}
+
+// Same as DigestBytes but reads the content from a file.
+func DigestFile(path string, digest_alg string) (*DigestedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read file to digest", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	return DigestBytes(dat, digest_alg)
+}
+
+func new_digested_data(dd_raw digested_data_raw) *DigestedData {
+	dd := new(DigestedData)
+	dd.base = dd_raw
+	dd.ContentType = dd_raw.EncapContentInfo.EContentType.String()
+	dd.DigestAlgorithm = dd_raw.DigestAlgorithm.Algorithm.String()
+	dd.Content = dd_raw.EncapContentInfo.EContent
+	dd.Digest = dd_raw.Digest
+	return dd
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS"), DER and BER.
+//
+// Possible errors are: ERR_PARSE_DIGESTED_DATA
+func NewDigestedDataFromBytes(raw []byte) (*DigestedData, CodedError) {
+	ci, err := unmarshal_content_info(raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_DIGESTED_DATA, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	return new_digested_data_from_content_info(ci)
+}
+
+func new_digested_data_from_content_info(ci content_info_decode) (*DigestedData, CodedError) {
+	if !ci.ContentType.Equal(idDigestData) {
+		merr := NewMultiError("CMS content is not digested data", ERR_PARSE_DIGESTED_DATA, nil)
+		merr.SetParam("content-type", ci.ContentType.String())
+		return nil, merr
+	}
+	dd_raw := digested_data_raw{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &dd_raw); err != nil {
+		merr := NewMultiError("failed to parse CMS digested data", ERR_PARSE_DIGESTED_DATA, nil, err)
+		merr.SetParam("raw-data", ci.Content.Bytes)
+		return nil, merr
+	}
+	return new_digested_data(dd_raw), nil
+}
+
+// Same as NewDigestedDataFromBytes but reads it from a file.
+func NewDigestedDataFromFile(path string) (*DigestedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read digested data file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	dd, cerr := NewDigestedDataFromBytes(dat)
+	if cerr != nil {
+		return nil, cerr
+	}
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		dd.FilePath = abs_path
+		dd.FileName = filepath.Base(abs_path)
+	}
+	return dd, nil
+}
+
+// Checks if the digest matches the attached content.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_UNKOWN_ALGORITHM, ERR_BAD_DIGEST
+func (dd *DigestedData) Verify() CodedError {
+	if dd.Content == nil {
+		return NewMultiError("digested data has no attached content", ERR_NO_CONTENT, nil)
+	}
+	return dd.VerifyDetached(dd.Content)
+}
+
+// Checks if the digest matches the given content.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_BAD_DIGEST
+func (dd *DigestedData) VerifyDetached(content []byte) CodedError {
+	digest, cerr := get_hasher_and_run(algorithm_identifier{Algorithm: dd.base.DigestAlgorithm.Algorithm}, content)
+	if cerr != nil {
+		return cerr
+	}
+	if !bytes.Equal(digest, dd.Digest) {
+		merr := NewMultiError("digest does not match the content", ERR_BAD_DIGEST, nil)
+		merr.SetParam("expected", nice_hex(dd.Digest))
+		merr.SetParam("actual", nice_hex(digest))
+		return merr
+	}
+	return nil
+}
+
+// Returns the digested data wrapped in a content info (see RFC 5652 Section 3) encoded as DER.
+func (dd *DigestedData) MarshalDER() ([]byte, CodedError) {
+	dd_raw := dd.base
+	dd_raw.RawContent = nil
+	dd_raw.EncapContentInfo.RawContent = nil
+	dd_raw.Version = 0
+	if !dd_raw.EncapContentInfo.EContentType.Equal(idData) {
+		dd_raw.Version = 2
+	}
+	dat, err := asn1.Marshal(dd_raw)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal digested data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return marshal_content_info(idDigestData, dat)
+}
+
+// Same as MarshalDER but encoded as PEM with block type "PKCS7".
+func (dd *DigestedData) MarshalPEM() ([]byte, CodedError) {
+	dat, cerr := dd.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+	block := &pem.Block{Type: "PKCS7", Bytes: dat}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Saves the digested data as DER.
+func (dd *DigestedData) SaveToFile(path string) CodedError {
+	dat, cerr := dd.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	err := ioutil.WriteFile(path, dat, 0644)
+	if err != nil {
+		merr := NewMultiError("failed to write to file", ERR_FAILED_TO_WRITE_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		dd.FilePath = abs_path
+		dd.FileName = filepath.Base(abs_path)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package libICP
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DigestBytes_1(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	for _, digest_alg := range []string{DIGEST_SHA1, DIGEST_SHA256, DIGEST_SHA384, DIGEST_SHA512, ""} {
+		dd, cerr := DigestBytes(content, digest_alg)
+		require.Nil(t, cerr, digest_alg)
+
+		// Marshal and parse it again
+		raw, cerr := dd.MarshalPEM()
+		require.Nil(t, cerr)
+		dd, cerr = NewDigestedDataFromBytes(raw)
+		require.Nil(t, cerr)
+		if digest_alg == "" {
+			assert.Equal(t, DIGEST_SHA256, dd.DigestAlgorithm)
+		} else {
+			assert.Equal(t, digest_alg, dd.DigestAlgorithm)
+		}
+		assert.Equal(t, idData.String(), dd.ContentType)
+		assert.Equal(t, content, dd.Content)
+		assert.Nil(t, dd.Verify())
+	}
+}
+
+func Test_DigestBytes_2(t *testing.T) {
+	_, cerr := DigestBytes([]byte("abc"), "1.2.3.4")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_DigestedData_Verify_1(t *testing.T) {
+	// Made with OpenSSL
+	for name, digest_alg := range map[string]string{"digested_sha256.der": DIGEST_SHA256, "digested_sha1_ber.pem": DIGEST_SHA1} {
+		dd, cerr := NewDigestedDataFromFile("data/test-cms/" + name)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, name, dd.FileName)
+		assert.Equal(t, digest_alg, dd.DigestAlgorithm)
+		assert.Equal(t, []byte("hello digest\n"), dd.Content)
+		assert.Nil(t, dd.Verify(), name)
+	}
+}
+
+func Test_DigestedData_Verify_2(t *testing.T) {
+	dd, cerr := NewDigestedDataFromFile("data/test-cms/digested_sha256.der")
+	require.Nil(t, cerr)
+	cerr = dd.VerifyDetached([]byte("hello digest!\n"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_DIGEST, cerr.Code())
+
+	dd.Content = nil
+	cerr = dd.Verify()
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_CONTENT, cerr.Code())
+}
+
+func Test_NewDigestedDataFromBytes_1(t *testing.T) {
+	_, cerr := NewDigestedDataFromFile("data/test-cms/data.der")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_DIGESTED_DATA, cerr.Code())
+}
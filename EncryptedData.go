@@ -0,0 +1,158 @@
+package libICP
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// Represents a CMS encrypted data (see RFC 5652 Section 8) whose content is protected by a password. The key is derived from the password with PBES2 (see RFC 8018), the same scheme used by PKCS#12 files.
+type EncryptedData struct {
+	content encrypted_content_info
+
+	FilePath string
+	FileName string
+	// OID of the encrypted content type (usually data)
+	ContentType string
+	// OID of the content encryption algorithm (ex: ENC_AES256_CBC). For legacy PKCS#12 encryption, this is the OID of the PBE algorithm itself.
+	ContentAlgorithm string
+}
+
+// Encrypts the content with a key derived from the password. If empty, the content algorithm defaults to ENC_AES256_CBC. AES-GCM is not accepted.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE
+func EncryptBytesWithPassword(content []byte, password, content_alg string) (*EncryptedData, CodedError) {
+	if content_alg == "" {
+		content_alg = ENC_AES256_CBC
+	}
+	eci, cerr := encrypt_content_with_password(content_alg, password, content)
+	if cerr != nil {
+		return nil, cerr
+	}
+	eci.ContentType = idData
+	return new_encrypted_data(eci), nil
+}
+
+// Same as EncryptBytesWithPassword but reads the content from a file.
+func EncryptFileWithPassword(path string, password, content_alg string) (*EncryptedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read file to encrypt", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	return EncryptBytesWithPassword(dat, password, content_alg)
+}
+
+func new_encrypted_data(eci encrypted_content_info) *EncryptedData {
+	ed := new(EncryptedData)
+	ed.content = eci
+	ed.ContentType = eci.ContentType.String()
+	ed.ContentAlgorithm = eci.ContentEncryptionAlgorithm.Algorithm.String()
+	if eci.ContentEncryptionAlgorithm.Algorithm.Equal(idPBES2) {
+		params := pbes2_params{}
+		if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err == nil {
+			ed.ContentAlgorithm = params.EncryptionScheme.Algorithm.String()
+		}
+	}
+	return ed
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS"), DER and BER.
+//
+// Possible errors are: ERR_PARSE_ENCRYPTED_DATA
+func NewEncryptedDataFromBytes(raw []byte) (*EncryptedData, CodedError) {
+	ci, err := unmarshal_content_info(raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_ENCRYPTED_DATA, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	return new_encrypted_data_from_content_info(ci)
+}
+
+func new_encrypted_data_from_content_info(ci content_info_decode) (*EncryptedData, CodedError) {
+	if !ci.ContentType.Equal(idEncryptedData) {
+		merr := NewMultiError("CMS content is not encrypted data", ERR_PARSE_ENCRYPTED_DATA, nil)
+		merr.SetParam("content-type", ci.ContentType.String())
+		return nil, merr
+	}
+	ed_raw := encrypted_data_raw{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed_raw); err != nil {
+		merr := NewMultiError("failed to parse CMS encrypted data", ERR_PARSE_ENCRYPTED_DATA, nil, err)
+		merr.SetParam("raw-data", ci.Content.Bytes)
+		return nil, merr
+	}
+	return new_encrypted_data(ed_raw.EncryptedContentInfo), nil
+}
+
+// Same as NewEncryptedDataFromBytes but reads it from a file.
+func NewEncryptedDataFromFile(path string) (*EncryptedData, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read encrypted data file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	ed, cerr := NewEncryptedDataFromBytes(dat)
+	if cerr != nil {
+		return nil, cerr
+	}
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		ed.FilePath = abs_path
+		ed.FileName = filepath.Base(abs_path)
+	}
+	return ed, nil
+}
+
+// Returns the content decrypted with the given password. A wrong password is usually (but not always, as there is no integrity check) detected as ERR_FAILED_TO_DECRYPT.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT
+func (ed *EncryptedData) Decrypt(password string) ([]byte, CodedError) {
+	return decrypt_content_with_password(ed.content, password)
+}
+
+// Returns the encrypted data wrapped in a content info (see RFC 5652 Section 3) encoded as DER.
+func (ed *EncryptedData) MarshalDER() ([]byte, CodedError) {
+	ed_raw, err := asn1.Marshal(encrypted_data_raw{EncryptedContentInfo: ed.content})
+	if err != nil {
+		return nil, NewMultiError("failed to marshal encrypted data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+
+	return marshal_content_info(idEncryptedData, ed_raw)
+}
+
+// Same as MarshalDER but encoded as PEM with block type "PKCS7".
+func (ed *EncryptedData) MarshalPEM() ([]byte, CodedError) {
+	dat, cerr := ed.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+	block := &pem.Block{Type: "PKCS7", Bytes: dat}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Saves the encrypted data as DER.
+func (ed *EncryptedData) SaveToFile(path string) CodedError {
+	dat, cerr := ed.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	err := ioutil.WriteFile(path, dat, 0644)
+	if err != nil {
+		merr := NewMultiError("failed to write to file", ERR_FAILED_TO_WRITE_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		ed.FilePath = abs_path
+		ed.FileName = filepath.Base(abs_path)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package libICP
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/OpenICP-BR/asn1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_pbkdf2_1(t *testing.T) {
+	// Test vectors from RFC 6070
+	assert.Equal(t, "0C60C80F961F0E71F3A9B524AF6012062FE037A6", to_hex(pbkdf2(sha1.New, []byte("password"), []byte("salt"), 1, 20)))
+	assert.Equal(t, "EA6C014DC72D6F8CCD1ED92ACE1D41F0D8DE8957", to_hex(pbkdf2(sha1.New, []byte("password"), []byte("salt"), 2, 20)))
+	assert.Equal(t, "4B007901B765489ABEAD49D926F721D065A429C1", to_hex(pbkdf2(sha1.New, []byte("password"), []byte("salt"), 4096, 20)))
+	assert.Equal(t, "3D2EEC4FE41C849B80C8D83662C0E44A8B291A964CF2F07038", to_hex(pbkdf2(sha1.New, []byte("passwordPASSWORDpassword"), []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"), 4096, 25)))
+}
+
+func Test_pbkdf2_2(t *testing.T) {
+	assert.Equal(t, "120FB6CFFCF8B32C43E7225256C4F837A86548C92CCC35480805987CB70BE17B", to_hex(pbkdf2(sha256.New, []byte("password"), []byte("salt"), 1, 32)))
+}
+
+func Test_EncryptBytesWithPassword_1(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	for _, content_alg := range []string{ENC_AES128_CBC, ENC_AES192_CBC, ENC_AES256_CBC, ""} {
+		ed, cerr := EncryptBytesWithPassword(content, "secret", content_alg)
+		require.Nil(t, cerr, content_alg)
+
+		// Marshal and parse it again
+		raw, cerr := ed.MarshalPEM()
+		require.Nil(t, cerr)
+		ed, cerr = NewEncryptedDataFromBytes(raw)
+		require.Nil(t, cerr)
+		if content_alg == "" {
+			assert.Equal(t, ENC_AES256_CBC, ed.ContentAlgorithm)
+		} else {
+			assert.Equal(t, content_alg, ed.ContentAlgorithm)
+		}
+		assert.Equal(t, idData.String(), ed.ContentType)
+
+		dat, cerr := ed.Decrypt("secret")
+		require.Nil(t, cerr)
+		assert.Equal(t, content, dat)
+	}
+}
+
+func Test_EncryptBytesWithPassword_2(t *testing.T) {
+	_, cerr := EncryptBytesWithPassword([]byte("abc"), "secret", ENC_AES256_GCM)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_EncryptedData_Decrypt_1(t *testing.T) {
+	// The encrypted certificate bags of PFX files created by OpenSSL
+	cert := get_test_cert(t, "ciclano")
+	tests := map[string]string{
+		"pkcs12_pbes2_aes256.p7m": ENC_AES256_CBC,
+		"pkcs12_pbe_3des.p7m":     idPbeWithSHAAnd3KeyTripleDES_CBC.String(),
+	}
+	for name, content_alg := range tests {
+		ed, cerr := NewEncryptedDataFromFile("data/test-envelopes/" + name)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, name, ed.FileName)
+		assert.Equal(t, content_alg, ed.ContentAlgorithm)
+		dat, cerr := ed.Decrypt("secret")
+		require.Nil(t, cerr, name)
+		assert.True(t, bytes.Contains(dat, cert.base.RawContent), name)
+
+		_, cerr = ed.Decrypt("wrong")
+		require.NotNil(t, cerr, name)
+		assert.EqualValues(t, ERR_FAILED_TO_DECRYPT, cerr.Code())
+	}
+}
+
+func Test_NewEncryptedDataFromBytes_1(t *testing.T) {
+	_, cerr := NewEncryptedDataFromFile("data/test-envelopes/ciclano_aes256_cbc_oaep.p7m")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_ENCRYPTED_DATA, cerr.Code())
+
+	_, cerr = NewEncryptedDataFromBytes([]byte("not a CMS"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_ENCRYPTED_DATA, cerr.Code())
+}
+
+func Test_EncryptedData_Decrypt_2(t *testing.T) {
+	// Forged iteration counts must be rejected before deriving the key
+	ed, cerr := EncryptBytesWithPassword([]byte("The quick fox jumps over the lazy dog."), "secret", ENC_AES256_CBC)
+	require.Nil(t, cerr)
+	params := pbes2_params{}
+	_, err := asn1.Unmarshal(ed.content.ContentEncryptionAlgorithm.Parameters.FullBytes, &params)
+	require.Nil(t, err)
+	kdf_params := pbkdf2_params{}
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf_params)
+	require.Nil(t, err)
+	// Otherwise the original encoding would be marshaled again
+	params.KeyDerivationFunc.RawContent = nil
+
+	for _, iterations := range []int{0, -1, pbkdf2_max_iterations + 1} {
+		kdf_params.IterationCount = iterations
+		params.KeyDerivationFunc.Parameters, cerr = marshal_alg_params(kdf_params)
+		require.Nil(t, cerr)
+		ed.content.ContentEncryptionAlgorithm.Parameters, cerr = marshal_alg_params(params)
+		require.Nil(t, cerr)
+		_, cerr = ed.Decrypt("secret")
+		require.NotNil(t, cerr, iterations)
+		assert.EqualValues(t, ERR_FAILED_TO_DECRYPT, cerr.Code())
+		assert.Contains(t, cerr.Error(), "iteration count")
+	}
+}
@@ -0,0 +1,401 @@
+package libICP
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// Content encryption algorithms. Content encrypted with AES-GCM is stored as authenticated-enveloped data. (see RFC 5083)
+const (
+	ENC_AES128_CBC = "2.16.840.1.101.3.4.1.2"
+	ENC_AES192_CBC = "2.16.840.1.101.3.4.1.22"
+	ENC_AES256_CBC = "2.16.840.1.101.3.4.1.42"
+	ENC_AES128_GCM = "2.16.840.1.101.3.4.1.6"
+	ENC_AES192_GCM = "2.16.840.1.101.3.4.1.26"
+	ENC_AES256_GCM = "2.16.840.1.101.3.4.1.46"
+)
+
+// Algorithms used to encrypt the content key for each recipient.
+const (
+	KEY_TRANSPORT_RSA_PKCS1V15 = "1.2.840.113549.1.1.1"
+	KEY_TRANSPORT_RSA_OAEP     = "1.2.840.113549.1.1.7"
+)
+
+type content_enc_alg struct {
+	key_size int
+	gcm      bool
+}
+
+var content_enc_algs = map[string]content_enc_alg{
+	ENC_AES128_CBC: {16, false},
+	ENC_AES192_CBC: {24, false},
+	ENC_AES256_CBC: {32, false},
+	ENC_AES128_GCM: {16, true},
+	ENC_AES192_GCM: {24, true},
+	ENC_AES256_GCM: {32, true},
+}
+
+// Represents a CMS enveloped data (see RFC 5652 Section 6), whose content can only be read by its recipients.
+type Envelope struct {
+	content encrypted_content_info
+	// Only for authenticated-enveloped data
+	authenticated bool
+	mac           []byte
+	auth_attrs    asn1.RawValue
+
+	FilePath string
+	FileName string
+	// OID of the encrypted content type (usually data)
+	ContentType string
+	// OID of the content encryption algorithm (ex: ENC_AES256_CBC)
+	ContentAlgorithm string
+	Recipients       []Recipient
+}
+
+// Identifies one of the certificates an envelope can be decrypted with.
+type Recipient struct {
+	base key_trans_recipient_info
+	// Only one of them is set.
+	SerialNumber *big.Int
+	SubjectKeyId string
+	// OID of the algorithm used to encrypt the content key (ex: KEY_TRANSPORT_RSA_OAEP)
+	KeyTransportAlgorithm string
+}
+
+func new_recipient(ktri key_trans_recipient_info) Recipient {
+	recipient := Recipient{base: ktri}
+	if len(ktri.Rid_V2) > 0 {
+		recipient.SubjectKeyId = nice_hex(ktri.Rid_V2)
+	} else {
+		recipient.SerialNumber = ktri.Rid_V0.SerialNumber
+	}
+	recipient.KeyTransportAlgorithm = ktri.KeyEncryptionAlgorithm.Algorithm.String()
+	return recipient
+}
+
+// Returns true if this recipient refers to the given certificate.
+func (recipient Recipient) Is(cert Certificate) bool {
+	return recipient.base.is_for(cert)
+}
+
+// Encrypts the content so it can only be read by the holders of the private keys of the given certificates. If empty, the content and key transport algorithms default to ENC_AES256_CBC and KEY_TRANSPORT_RSA_OAEP respectively.
+//
+// Possible errors are: ERR_NO_RECIPIENTS, ERR_UNKOWN_ALGORITHM, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE, ERR_PARSE_CERT, ERR_PARSE_RSA_PUBKEY
+func EncryptBytes(content []byte, recipients []*Certificate, content_alg, key_alg string) (*Envelope, CodedError) {
+	return new_envelope(content, idData, recipients, content_alg, key_alg)
+}
+
+// Same as EncryptBytes but reads the content from a file.
+func EncryptFile(path string, recipients []*Certificate, content_alg, key_alg string) (*Envelope, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read file to encrypt", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	return EncryptBytes(dat, recipients, content_alg, key_alg)
+}
+
+func new_envelope(content []byte, content_type asn1.ObjectIdentifier, recipients []*Certificate, content_alg, key_alg string) (*Envelope, CodedError) {
+	if content_alg == "" {
+		content_alg = ENC_AES256_CBC
+	}
+	if key_alg == "" {
+		key_alg = KEY_TRANSPORT_RSA_OAEP
+	}
+	if len(recipients) == 0 {
+		return nil, NewMultiError("no recipients to encrypt to", ERR_NO_RECIPIENTS, nil)
+	}
+
+	env := new(Envelope)
+	var key []byte
+	var cerr CodedError
+	env.content, key, env.mac, cerr = encrypt_content(content_alg, content)
+	if cerr != nil {
+		return nil, cerr
+	}
+	env.content.ContentType = content_type
+	env.authenticated = content_enc_algs[content_alg].gcm
+	env.ContentType = content_type.String()
+	env.ContentAlgorithm = content_alg
+
+	if env.Recipients, cerr = new_recipients(recipients, key_alg, key); cerr != nil {
+		return nil, cerr
+	}
+	return env, nil
+}
+
+func new_recipients(certs []*Certificate, key_alg string, key []byte) ([]Recipient, CodedError) {
+	recipients := make([]Recipient, len(certs))
+	for i, cert := range certs {
+		ktri, cerr := new_key_trans_recipient_info(cert, key_alg, key)
+		if cerr != nil {
+			return nil, cerr
+		}
+		recipients[i] = new_recipient(ktri)
+	}
+	return recipients, nil
+}
+
+// Other kinds of recipient infos are tagged, so they are skipped (and not kept when marshaling)
+func parse_recipient_infos(recipient_infos []asn1.RawValue, code ErrorCode) ([]Recipient, CodedError) {
+	recipients := make([]Recipient, 0)
+	for _, ri := range recipient_infos {
+		if ri.Class != asn1.ClassUniversal {
+			continue
+		}
+		ktri := key_trans_recipient_info{}
+		if _, err := asn1.Unmarshal(ri.FullBytes, &ktri); err != nil {
+			merr := NewMultiError("failed to parse CMS recipient info", code, nil, err)
+			merr.SetParam("raw-data", ri.FullBytes)
+			return nil, merr
+		}
+		recipients = append(recipients, new_recipient(ktri))
+	}
+	return recipients, nil
+}
+
+// Also returns the lowest version the enveloping structure may have.
+func marshal_recipient_infos(recipients []Recipient) ([]asn1.RawValue, int, CodedError) {
+	recipient_infos := make([]asn1.RawValue, len(recipients))
+	version := 0
+	for i, recipient := range recipients {
+		raw, err := asn1.Marshal(recipient.base)
+		if err != nil {
+			return nil, 0, NewMultiError("failed to marshal recipient info", ERR_FAILED_TO_ENCODE, nil, err)
+		}
+		recipient_infos[i] = asn1.RawValue{FullBytes: raw}
+		if recipient.base.Version != 0 {
+			version = 2
+		}
+	}
+	return recipient_infos, version, nil
+}
+
+// Decrypts the content key (or MAC key) with the private key of the given PFX.
+//
+// Possible errors are: ERR_NO_PRIVATE_KEY, ERR_NOT_RECIPIENT, ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT, ERR_SECURE_RANDOM
+func recipients_key(recipients []Recipient, pfx PFX, key_size int) ([]byte, CodedError) {
+	if !pfx.HasKey() {
+		return nil, NewMultiError("PFX has no private key", ERR_NO_PRIVATE_KEY, nil)
+	}
+	for _, recipient := range recipients {
+		if recipient.Is(*pfx.Cert) {
+			return recipient.base.decrypt_key(pfx.rsa_key, key_size)
+		}
+	}
+	merr := NewMultiError("certificate is not one of the recipients", ERR_NOT_RECIPIENT, nil)
+	merr.SetParam("cert.Subject", pfx.Cert.Subject)
+	return nil, merr
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS"), DER and BER. Both enveloped data and authenticated-enveloped data are accepted.
+//
+// Possible errors are: ERR_PARSE_ENVELOPED_DATA
+func NewEnvelopeFromBytes(raw []byte) (*Envelope, CodedError) {
+	ci, err := unmarshal_content_info(raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_ENVELOPED_DATA, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	return new_envelope_from_content_info(ci)
+}
+
+func new_envelope_from_content_info(ci content_info_decode) (*Envelope, CodedError) {
+	env := new(Envelope)
+	var recipient_infos []asn1.RawValue
+	var err error
+	switch {
+	case ci.ContentType.Equal(idEnvelopedData):
+		ed := enveloped_data_raw{}
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &ed)
+		recipient_infos = ed.RecipientInfos
+		env.content = ed.EncryptedContentInfo
+	case ci.ContentType.Equal(idCtAuthEnvelopedData):
+		ed := auth_enveloped_data_raw{}
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &ed)
+		recipient_infos = ed.RecipientInfos
+		env.content = ed.AuthEncryptedContentInfo
+		env.authenticated = true
+		env.mac = ed.MAC
+		env.auth_attrs = ed.AuthAttrs
+	default:
+		merr := NewMultiError("CMS content is not enveloped data", ERR_PARSE_ENVELOPED_DATA, nil)
+		merr.SetParam("content-type", ci.ContentType.String())
+		return nil, merr
+	}
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS enveloped data", ERR_PARSE_ENVELOPED_DATA, nil, err)
+		merr.SetParam("raw-data", ci.Content.Bytes)
+		return nil, merr
+	}
+	env.ContentType = env.content.ContentType.String()
+	env.ContentAlgorithm = env.content.ContentEncryptionAlgorithm.Algorithm.String()
+
+	var cerr CodedError
+	if env.Recipients, cerr = parse_recipient_infos(recipient_infos, ERR_PARSE_ENVELOPED_DATA); cerr != nil {
+		return nil, cerr
+	}
+	return env, nil
+}
+
+// Same as NewEnvelopeFromBytes but reads it from a file.
+func NewEnvelopeFromFile(path string) (*Envelope, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read enveloped data file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	env, cerr := NewEnvelopeFromBytes(dat)
+	if cerr != nil {
+		return nil, cerr
+	}
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		env.FilePath = abs_path
+		env.FileName = filepath.Base(abs_path)
+	}
+	return env, nil
+}
+
+// Returns the content decrypted with the private key of the given PFX, which MUST be one of the recipients.
+//
+// Possible errors are: ERR_NO_PRIVATE_KEY, ERR_NOT_RECIPIENT, ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT, ERR_SECURE_RANDOM
+func (env *Envelope) Decrypt(pfx PFX) ([]byte, CodedError) {
+	if !pfx.HasKey() {
+		return nil, NewMultiError("PFX has no private key", ERR_NO_PRIVATE_KEY, nil)
+	}
+	alg, ok := content_enc_algs[env.ContentAlgorithm]
+	if !ok {
+		merr := NewMultiError("unknown content encryption algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", env.ContentAlgorithm)
+		return nil, merr
+	}
+	// Otherwise the content of an authenticated-enveloped data would not be authenticated at all (see RFC 5083 Section 2.1)
+	if env.authenticated && !alg.gcm {
+		merr := NewMultiError("authenticated-enveloped data with a content encryption algorithm which does not authenticate", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", env.ContentAlgorithm)
+		return nil, merr
+	}
+	key, cerr := recipients_key(env.Recipients, pfx, alg.key_size)
+	if cerr != nil {
+		return nil, cerr
+	}
+	// The authenticated attributes are covered by the MAC with their SET OF tag (see RFC 5083 Section 2.2)
+	var aad []byte
+	if len(env.auth_attrs.FullBytes) > 0 {
+		aad = append([]byte{}, env.auth_attrs.FullBytes...)
+		aad[0] = 0x31
+	}
+	return decrypt_content(env.content, key, env.mac, aad)
+}
+
+// Signs the content (attached and following the policy, if it is not nil) and then encrypts the signed data for the given recipients, so the content is both authentic and confidential. See SignBytesWithPolicy and EncryptBytes.
+//
+// Possible errors are: the ones from SignBytesWithPolicy and EncryptBytes
+func (pfx PFX) SignAndEncrypt(content []byte, policy *SignaturePolicy, recipients []*Certificate, content_alg, key_alg string) (*Envelope, CodedError) {
+	msig, cerr := pfx.SignBytesWithPolicy(content, true, policy)
+	if cerr != nil {
+		return nil, cerr
+	}
+	// The encrypted content is the signed data itself, not its content info (see RFC 5652 Section 6.1)
+	sd_raw, cerr := msig.marshal_signed_data()
+	if cerr != nil {
+		return nil, cerr
+	}
+	return new_envelope(sd_raw, idSignedData, recipients, content_alg, key_alg)
+}
+
+// Reverses SignAndEncrypt: decrypts the envelope with the PFX and verifies the signed data inside it (see MultSignature.CheckAll). Returns the signed content and the signatures, whose Status fields hold the verification results. Envelopes whose content is a whole signature file (content type data), as made by other tools, are also accepted.
+//
+// Possible errors are: the ones from Decrypt, ERR_PARSE_SIGNATURE, ERR_NO_CONTENT
+func (env *Envelope) DecryptAndVerify(pfx PFX, store *CAStore) ([]byte, *MultSignature, CodedError) {
+	return env.decrypt_and_verify_at(pfx, store, time.Now())
+}
+
+func (env *Envelope) decrypt_and_verify_at(pfx PFX, store *CAStore, now time.Time) ([]byte, *MultSignature, CodedError) {
+	plain, cerr := env.Decrypt(pfx)
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	var msig *MultSignature
+	switch env.ContentType {
+	case idSignedData.String():
+		msig, cerr = new_mult_signature_from_signed_data(plain)
+	case idData.String():
+		msig, cerr = NewMultSignatureFromBytes(plain)
+	default:
+		merr := NewMultiError("enveloped content is not signed data", ERR_PARSE_SIGNATURE, nil)
+		merr.SetParam("content-type", env.ContentType)
+		return nil, nil, merr
+	}
+	if cerr != nil {
+		return nil, nil, cerr
+	}
+	if cerr := msig.check_all_at(store, now); cerr != nil {
+		return nil, nil, cerr
+	}
+	return msig.base.EncapContentInfo.EContent, msig, nil
+}
+
+// Returns the enveloped data (or authenticated-enveloped data) wrapped in a content info (see RFC 5652 Section 3) encoded as DER.
+func (env *Envelope) MarshalDER() ([]byte, CodedError) {
+	recipient_infos, version, cerr := marshal_recipient_infos(env.Recipients)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	var ed interface{}
+	content_type := idEnvelopedData
+	if env.authenticated {
+		content_type = idCtAuthEnvelopedData
+		ed = auth_enveloped_data_raw{RecipientInfos: recipient_infos, AuthEncryptedContentInfo: env.content, AuthAttrs: env.auth_attrs, MAC: env.mac}
+	} else {
+		ed = enveloped_data_raw{Version: version, RecipientInfos: recipient_infos, EncryptedContentInfo: env.content}
+	}
+	ed_raw, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal enveloped data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+
+	return marshal_content_info(content_type, ed_raw)
+}
+
+// Same as MarshalDER but encoded as PEM with block type "PKCS7".
+func (env *Envelope) MarshalPEM() ([]byte, CodedError) {
+	dat, cerr := env.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+	block := &pem.Block{Type: "PKCS7", Bytes: dat}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Saves the enveloped data as DER.
+func (env *Envelope) SaveToFile(path string) CodedError {
+	dat, cerr := env.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	err := ioutil.WriteFile(path, dat, 0644)
+	if err != nil {
+		merr := NewMultiError("failed to write to file", ERR_FAILED_TO_WRITE_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		env.FilePath = abs_path
+		env.FileName = filepath.Base(abs_path)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package libICP
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get_test_cert(t *testing.T, name string) *Certificate {
+	certs, errs := NewCertificateFromFile("data/test-chain/intermediate/fakebank/certs/" + name + ".crt.pem")
+	require.Nil(t, errs)
+	return certs[0]
+}
+
+func Test_EncryptBytes_1(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	pfx := get_ciclano_pfx(t)
+	for content_alg := range content_enc_algs {
+		for _, key_alg := range []string{KEY_TRANSPORT_RSA_PKCS1V15, KEY_TRANSPORT_RSA_OAEP} {
+			env, cerr := EncryptBytes(content, []*Certificate{pfx.Cert}, content_alg, key_alg)
+			require.Nil(t, cerr, "%s %s", content_alg, key_alg)
+
+			// Marshal and parse it again
+			raw, cerr := env.MarshalDER()
+			require.Nil(t, cerr)
+			env, cerr = NewEnvelopeFromBytes(raw)
+			require.Nil(t, cerr)
+			assert.Equal(t, content_alg, env.ContentAlgorithm)
+			assert.Equal(t, idData.String(), env.ContentType)
+			require.Equal(t, 1, len(env.Recipients))
+			assert.Equal(t, key_alg, env.Recipients[0].KeyTransportAlgorithm)
+			assert.True(t, env.Recipients[0].Is(*pfx.Cert))
+
+			ans, cerr := env.Decrypt(pfx)
+			require.Nil(t, cerr, "%s %s", content_alg, key_alg)
+			assert.Equal(t, content, ans)
+		}
+	}
+}
+
+func Test_EncryptBytes_2(t *testing.T) {
+	// Multiple recipients
+	content := []byte("The quick fox jumps over the lazy dog.")
+	ciclano := get_ciclano_pfx(t)
+	deltrano, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+	env, cerr := EncryptBytes(content, []*Certificate{ciclano.Cert, deltrano.Cert}, "", "")
+	require.Nil(t, cerr)
+	assert.Equal(t, ENC_AES256_CBC, env.ContentAlgorithm)
+	pem, cerr := env.MarshalPEM()
+	require.Nil(t, cerr)
+	env, cerr = NewEnvelopeFromBytes(pem)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(env.Recipients))
+
+	for _, pfx := range []PFX{ciclano, deltrano} {
+		ans, cerr := env.Decrypt(pfx)
+		require.Nil(t, cerr)
+		assert.Equal(t, content, ans)
+	}
+
+	// Not a recipient
+	env, cerr = EncryptBytes(content, []*Certificate{deltrano.Cert}, "", "")
+	require.Nil(t, cerr)
+	_, cerr = env.Decrypt(ciclano)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NOT_RECIPIENT, cerr.Code())
+
+	// No private key
+	_, cerr = env.Decrypt(PFX{Cert: deltrano.Cert})
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_PRIVATE_KEY, cerr.Code())
+}
+
+func Test_EncryptBytes_3(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	_, cerr := EncryptBytes(content, nil, "", "")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_RECIPIENTS, cerr.Code())
+	_, cerr = EncryptBytes(content, []*Certificate{get_test_cert(t, "ciclano")}, idSha256.String(), "")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+	_, cerr = EncryptBytes(content, []*Certificate{get_test_cert(t, "ciclano")}, "", idSha256.String())
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_Envelope_Decrypt_1(t *testing.T) {
+	// Made with OpenSSL
+	pfx := get_ciclano_pfx(t)
+	files := map[string]string{
+		"ciclano_aes256_cbc_oaep.p7m":  ENC_AES256_CBC,
+		"ciclano_aes128_cbc_pkcs1.p7m": ENC_AES128_CBC,
+		"ciclano_aes128_gcm_ber.p7m":   ENC_AES128_GCM,
+	}
+	for name, alg := range files {
+		env, cerr := NewEnvelopeFromFile("data/test-envelopes/" + name)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, alg, env.ContentAlgorithm)
+		ans, cerr := env.Decrypt(pfx)
+		require.Nil(t, cerr, name)
+		assert.Equal(t, "The quick fox jumps over the lazy dog.", string(ans))
+	}
+}
+
+func Test_Envelope_Decrypt_2(t *testing.T) {
+	// Changed content
+	pfx := get_ciclano_pfx(t)
+	for _, alg := range []string{ENC_AES128_GCM, ENC_AES128_CBC} {
+		env, cerr := EncryptBytes([]byte("The quick fox jumps over the lazy dog."), []*Certificate{pfx.Cert}, alg, "")
+		require.Nil(t, cerr)
+		env.content.EncryptedContent.Bytes[len(env.content.EncryptedContent.Bytes)-1] ^= 0xFF
+		_, cerr = env.Decrypt(pfx)
+		require.NotNil(t, cerr, alg)
+		assert.EqualValues(t, ERR_FAILED_TO_DECRYPT, cerr.Code())
+	}
+}
+
+func Test_Envelope_Decrypt_3(t *testing.T) {
+	// Authenticated-enveloped data with AES-CBC
+	pfx := get_ciclano_pfx(t)
+	env, cerr := EncryptBytes([]byte("The quick fox jumps over the lazy dog."), []*Certificate{pfx.Cert}, ENC_AES128_CBC, "")
+	require.Nil(t, cerr)
+	env.authenticated = true
+	raw, cerr := env.MarshalDER()
+	require.Nil(t, cerr)
+	env, cerr = NewEnvelopeFromBytes(raw)
+	require.Nil(t, cerr)
+	_, cerr = env.Decrypt(pfx)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_NewEnvelopeFromBytes_1(t *testing.T) {
+	_, cerr := NewEnvelopeFromBytes([]byte("not a CMS"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_ENVELOPED_DATA, cerr.Code())
+
+	_, cerr = NewEnvelopeFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_ENVELOPED_DATA, cerr.Code())
+}
+
+func Test_PFX_SignAndEncrypt_1(t *testing.T) {
+	content := []byte("The quick fox jumps over the lazy dog.")
+	ciclano := get_ciclano_pfx(t)
+	deltrano, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+	env, cerr := ciclano.SignAndEncrypt(content, get_test_policy(t), []*Certificate{deltrano.Cert}, ENC_AES256_GCM, "")
+	require.Nil(t, cerr)
+	assert.Equal(t, idSignedData.String(), env.ContentType)
+
+	// Marshal and parse it again
+	raw, cerr := env.MarshalDER()
+	require.Nil(t, cerr)
+	env, cerr = NewEnvelopeFromBytes(raw)
+	require.Nil(t, cerr)
+
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	ans, msig, cerr := env.decrypt_and_verify_at(deltrano, get_test_store(t, true), now)
+	require.Nil(t, cerr)
+	assert.Equal(t, content, ans)
+	require.Equal(t, 1, len(msig.Signatures))
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	assert.True(t, status.IsSignerCertValid(), "%v", status.SignerCertError)
+	assert.True(t, status.IsPolicyCompliant(), "%v", status.PolicyErrors)
+	assert.Equal(t, ciclano.Cert.Subject, msig.Signatures[0].Signer.Subject)
+
+	// Only the recipient can read it
+	_, _, cerr = env.decrypt_and_verify_at(ciclano, get_test_store(t, true), now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NOT_RECIPIENT, cerr.Code())
+}
+
+func Test_Envelope_DecryptAndVerify_1(t *testing.T) {
+	// A whole signature file encrypted as data
+	raw, err := ioutil.ReadFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, err)
+	pfx := get_ciclano_pfx(t)
+	env, cerr := EncryptBytes(raw, []*Certificate{pfx.Cert}, "", "")
+	require.Nil(t, cerr)
+	ans, msig, cerr := env.decrypt_and_verify_at(pfx, get_test_store(t, true), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.Equal(t, msig.ContentAttached, ans)
+	assert.True(t, msig.Signatures[0].Status.Integrity)
+
+	// Not a signature
+	env, cerr = EncryptBytes([]byte("The quick fox jumps over the lazy dog."), []*Certificate{pfx.Cert}, "", "")
+	require.Nil(t, cerr)
+	_, _, cerr = env.DecryptAndVerify(pfx, get_test_store(t, true))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_SIGNATURE, cerr.Code())
+}
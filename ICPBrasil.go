@@ -0,0 +1,173 @@
+package libICP
+
+import (
+	"strings"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// ICP-Brasil certificate types (see DOC-ICP-04 Section 1.1). A certificates are used for signatures, S certificates for encryption (sigilo) and T certificates for time stamping. The number is the security level: level 1 keys are generated and stored in software, while level 3 and 4 keys are generated and stored in hardware (smartcards, tokens or HSMs).
+const (
+	CERT_TYPE_A1 = "A1"
+	CERT_TYPE_A2 = "A2"
+	CERT_TYPE_A3 = "A3"
+	CERT_TYPE_A4 = "A4"
+	CERT_TYPE_S1 = "S1"
+	CERT_TYPE_S2 = "S2"
+	CERT_TYPE_S3 = "S3"
+	CERT_TYPE_S4 = "S4"
+	CERT_TYPE_T3 = "T3"
+	CERT_TYPE_T4 = "T4"
+)
+
+// Maps the arc after 2.16.76.1.2 in the OID of the certificate policies to their types.
+var icp_brasil_cert_types = map[int]string{
+	1:   CERT_TYPE_A1,
+	2:   CERT_TYPE_A2,
+	3:   CERT_TYPE_A3,
+	4:   CERT_TYPE_A4,
+	101: CERT_TYPE_S1,
+	102: CERT_TYPE_S2,
+	103: CERT_TYPE_S3,
+	104: CERT_TYPE_S4,
+	303: CERT_TYPE_T3,
+	304: CERT_TYPE_T4,
+}
+
+// Personal data of the holder of an ICP-Brasil certificate or of the person responsible for a company certificate. Fields filled with zeros in the certificate are left empty.
+type ICPBrasilPerson struct {
+	// It is zero if not informed.
+	BirthDate time.Time
+	// Cadastro de Pessoas Físicas
+	CPF string
+	// Número de Identificação Social (NIS/PIS/PASEP)
+	NIS string
+	// Registro Geral (identity card) number, as written in the certificate
+	RG string
+	// Issuing agency and state of the RG (ex: "SSP SP")
+	RGIssuer string
+}
+
+// Holder data stored by ICP-Brasil as other names in the subject alternative name extension (see DOC-ICP-04 Section 7.1.2.3). For company certificates (e-CNPJ), the embedded person is usually empty and Responsible holds the data of the person responsible for the certificate.
+type ICPBrasilData struct {
+	// Certificate type (ex: CERT_TYPE_A3) taken from the certificate policies extension. It is empty for certificates outside ICP-Brasil and usually for CAs.
+	CertType string
+
+	ICPBrasilPerson
+	// Título de eleitor
+	VoterID      string
+	VoterZone    string
+	VoterSection string
+	// City and state where the voter is registered
+	VoterCity string
+	// Cadastro Específico do INSS of the holder
+	CEI string
+
+	CNPJ        string
+	CompanyName string
+	// Cadastro Específico do INSS of the company
+	CompanyCEI      string
+	ResponsibleName string
+	Responsible     ICPBrasilPerson
+
+	// Registration number in the Ordem dos Advogados do Brasil
+	OAB      string
+	OABState string
+}
+
+// Fills the fields from the other names found in the subject alternative name extension. Unknown other names are ignored and short values are accepted, as some certification authorities omit trailing empty fields.
+func (ans *ICPBrasilData) from_other_names(names []another_name) {
+	for _, name := range names {
+		value := other_name_string(name.Value)
+		id := name.TypeId
+		switch {
+		case id.Equal(idIcpBrasilPersonData):
+			ans.ICPBrasilPerson = parse_icp_brasil_person(value)
+		case id.Equal(idIcpBrasilResponsibleData):
+			ans.Responsible = parse_icp_brasil_person(value)
+		case id.Equal(idIcpBrasilResponsibleName):
+			ans.ResponsibleName = strings.TrimSpace(value)
+		case id.Equal(idIcpBrasilCNPJ):
+			ans.CNPJ = icp_brasil_field(value, 0, 14)
+		case id.Equal(idIcpBrasilVoterID):
+			ans.VoterID = icp_brasil_field(value, 0, 12)
+			ans.VoterZone = icp_brasil_field(value, 12, 3)
+			ans.VoterSection = icp_brasil_field(value, 15, 4)
+			ans.VoterCity = icp_brasil_field(value, 19, 22)
+		case id.Equal(idIcpBrasilPersonCEI):
+			ans.CEI = icp_brasil_field(value, 0, 12)
+		case id.Equal(idIcpBrasilCompanyCEI):
+			ans.CompanyCEI = icp_brasil_field(value, 0, 12)
+		case id.Equal(idIcpBrasilCompanyName):
+			ans.CompanyName = strings.TrimSpace(value)
+		case id.Equal(idIcpBrasilOAB):
+			ans.OAB = icp_brasil_field(value, 0, 7)
+			ans.OABState = icp_brasil_field(value, 7, 2)
+		}
+	}
+}
+
+// Returns the type of the first ICP-Brasil certificate policy (2.16.76.1.2.<type>.<n>) or an empty string if there is none. End entity certificates have a single policy, while CAs may list one for each type they issue.
+func icp_brasil_cert_type(policies []CertificatePolicy) string {
+	prefix := idIcpBrasilCertPolicies
+	for _, policy := range policies {
+		oid := str2oid_key(policy.ID)
+		if len(oid) <= len(prefix) || !oid[:len(prefix)].Equal(prefix) {
+			continue
+		}
+		if cert_type, ok := icp_brasil_cert_types[oid[len(prefix)]]; ok {
+			return cert_type
+		}
+	}
+	return ""
+}
+
+// The layout is: birth date (ddmmaaaa), CPF (11), NIS (11), RG (15) and RG issuer with state (10).
+func parse_icp_brasil_person(value string) ICPBrasilPerson {
+	ans := ICPBrasilPerson{}
+	if date := icp_brasil_field(value, 0, 8); date != "" {
+		ans.BirthDate, _ = time.Parse("02012006", date)
+	}
+	ans.CPF = icp_brasil_field(value, 8, 11)
+	ans.NIS = icp_brasil_field(value, 19, 11)
+	ans.RG = icp_brasil_field(value, 30, 15)
+	ans.RGIssuer = icp_brasil_field(value, 45, 10)
+	return ans
+}
+
+// Returns the trimmed field at the given position or an empty string if it is missing or filled with zeros.
+func icp_brasil_field(value string, start, size int) string {
+	if start >= len(value) {
+		return ""
+	}
+	end := start + size
+	if end > len(value) {
+		end = len(value)
+	}
+	field := strings.TrimSpace(value[start:end])
+	if strings.Trim(field, "0") == "" {
+		return ""
+	}
+	return field
+}
+
+// ICP-Brasil uses OCTET STRING for most values, but some certification authorities use PrintableString or UTF8String.
+func other_name_string(value asn1.RawValue) string {
+	// The asn1 package keeps the explicit tag in raw values
+	if value.Class == asn1.ClassContextSpecific && value.Tag == 0 && value.IsCompound {
+		inner := asn1.RawValue{}
+		if _, err := asn1.Unmarshal(value.Bytes, &inner); err != nil {
+			return ""
+		}
+		value = inner
+	}
+	if value.Class != asn1.ClassUniversal || value.IsCompound {
+		return ""
+	}
+	switch value.Tag {
+	case asn1.TagOctetString, asn1.TagPrintableString, asn1.TagUTF8String, asn1.TagIA5String:
+		return string(value.Bytes)
+	}
+	return ""
+}
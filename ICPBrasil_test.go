@@ -0,0 +1,125 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Certificate_ICPBrasil_1(t *testing.T) {
+	certs, errs := NewCertificateFromFile("./data/test-icp/pf.crt.pem")
+	require.Nil(t, errs)
+	cert := certs[0]
+
+	assert.Equal(t, []string{"fulano@example.com"}, cert.ext_subject_alt_name.Emails)
+	icp := cert.ICPBrasil
+	assert.Equal(t, time.Date(1985, 8, 15, 0, 0, 0, 0, time.UTC), icp.BirthDate)
+	assert.Equal(t, "12345678909", icp.CPF)
+	assert.Equal(t, "", icp.NIS)
+	assert.Equal(t, "000000123456789", icp.RG)
+	assert.Equal(t, "SSP SP", icp.RGIssuer)
+	assert.Equal(t, "123456789012", icp.VoterID)
+	assert.Equal(t, "001", icp.VoterZone)
+	assert.Equal(t, "0123", icp.VoterSection)
+	assert.Equal(t, "SAO PAULO SP", icp.VoterCity)
+	assert.Equal(t, "", icp.CEI)
+	assert.Equal(t, "0123456", icp.OAB)
+	assert.Equal(t, "SP", icp.OABState)
+	assert.Equal(t, "", icp.CNPJ)
+	assert.Equal(t, ICPBrasilPerson{}, icp.Responsible)
+}
+
+func Test_Certificate_ICPBrasil_2(t *testing.T) {
+	certs, errs := NewCertificateFromFile("./data/test-icp/pj.crt.pem")
+	require.Nil(t, errs)
+	icp := certs[0].ICPBrasil
+
+	assert.Equal(t, ICPBrasilPerson{}, icp.ICPBrasilPerson)
+	assert.Equal(t, "11222333000181", icp.CNPJ)
+	assert.Equal(t, "EMPRESA FICTICIA LTDA", icp.CompanyName)
+	assert.Equal(t, "123456789012", icp.CompanyCEI)
+	assert.Equal(t, "BELTRANO DA SILVA", icp.ResponsibleName)
+	assert.Equal(t, time.Date(1970, 2, 1, 0, 0, 0, 0, time.UTC), icp.Responsible.BirthDate)
+	assert.Equal(t, "98765432100", icp.Responsible.CPF)
+	assert.Equal(t, "", icp.Responsible.NIS)
+	assert.Equal(t, "", icp.Responsible.RG)
+	assert.Equal(t, "", icp.Responsible.RGIssuer)
+}
+
+func Test_Certificate_ICPBrasil_3(t *testing.T) {
+	certs, errs := NewCertificateFromFile("./data/test-chain/intermediate/fakebank/certs/ciclano.crt.pem")
+	require.Nil(t, errs)
+	assert.Equal(t, ICPBrasilData{}, certs[0].ICPBrasil)
+}
+
+func Test_ICPBrasilField_1(t *testing.T) {
+	assert.Equal(t, "123", icp_brasil_field("00123", 2, 3))
+	assert.Equal(t, "23", icp_brasil_field("00123", 3, 3))
+	assert.Equal(t, "", icp_brasil_field("00123", 5, 3))
+	assert.Equal(t, "", icp_brasil_field("00000", 0, 5))
+	assert.Equal(t, "AB", icp_brasil_field("AB   ", 0, 5))
+}
+
+func Test_ParseICPBrasilPerson_1(t *testing.T) {
+	person := parse_icp_brasil_person("99999999123")
+	assert.True(t, person.BirthDate.IsZero())
+	assert.Equal(t, "123", person.CPF)
+}
+
+func Test_Certificate_Policies_1(t *testing.T) {
+	certs, errs := NewCertificateFromFile("./data/test-icp/a1.crt.pem")
+	require.Nil(t, errs)
+	cert := certs[0]
+
+	require.Len(t, cert.Policies, 2)
+	assert.Equal(t, "2.16.76.1.2.1.51", cert.Policies[0].ID)
+	assert.Equal(t, []string{"http://example.com/dpc.pdf"}, cert.Policies[0].CPSURIs)
+	require.Len(t, cert.Policies[0].UserNotices, 1)
+	assert.Equal(t, "Fake Bank", cert.Policies[0].UserNotices[0].Organization)
+	assert.Equal(t, []int{1, 2}, cert.Policies[0].UserNotices[0].NoticeNumbers)
+	assert.Equal(t, "Politica de certificado", cert.Policies[0].UserNotices[0].ExplicitText)
+	assert.Equal(t, CertificatePolicy{ID: "1.2.3.4"}, cert.Policies[1])
+	assert.True(t, cert.HasPolicy("2.16.76.1.2.1.51"))
+	assert.False(t, cert.HasPolicy("2.16.76.1.2.3.51"))
+	assert.Equal(t, CERT_TYPE_A1, cert.ICPBrasil.CertType)
+}
+
+func Test_Certificate_CheckCertType_1(t *testing.T) {
+	certs, errs := NewCertificateFromFile("./data/test-icp/a1.crt.pem")
+	require.Nil(t, errs)
+	cert := certs[0]
+
+	assert.Nil(t, cert.CheckCertType(CERT_TYPE_A1, CERT_TYPE_A3))
+	err := cert.CheckCertType(CERT_TYPE_A3, CERT_TYPE_A4)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_CERT_TYPE_NOT_ALLOWED, err.Code())
+
+	// Certificates without ICP-Brasil policies are never allowed
+	certs, errs = NewCertificateFromFile("./data/test-icp/pf.crt.pem")
+	require.Nil(t, errs)
+	assert.Equal(t, "", certs[0].ICPBrasil.CertType)
+	err = certs[0].CheckCertType(CERT_TYPE_A1, "")
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_CERT_TYPE_NOT_ALLOWED, err.Code())
+}
+
+func Test_ICPBrasilCertType_1(t *testing.T) {
+	policy := func(ids ...string) []CertificatePolicy {
+		ans := []CertificatePolicy{}
+		for _, id := range ids {
+			ans = append(ans, CertificatePolicy{ID: id})
+		}
+		return ans
+	}
+	assert.Equal(t, "", icp_brasil_cert_type(nil))
+	assert.Equal(t, "", icp_brasil_cert_type(policy("2.16.76.1.2", "2.16.76.1.1.0", "2.16.76.1.2.99.1")))
+	assert.Equal(t, CERT_TYPE_A2, icp_brasil_cert_type(policy("2.5.29.32.0", "2.16.76.1.2.2.1")))
+	assert.Equal(t, CERT_TYPE_A3, icp_brasil_cert_type(policy("2.16.76.1.2.3.10")))
+	assert.Equal(t, CERT_TYPE_A4, icp_brasil_cert_type(policy("2.16.76.1.2.4.2")))
+	assert.Equal(t, CERT_TYPE_S1, icp_brasil_cert_type(policy("2.16.76.1.2.101.1")))
+	assert.Equal(t, CERT_TYPE_S4, icp_brasil_cert_type(policy("2.16.76.1.2.104.1")))
+	assert.Equal(t, CERT_TYPE_T3, icp_brasil_cert_type(policy("2.16.76.1.2.303.1")))
+	assert.Equal(t, CERT_TYPE_T4, icp_brasil_cert_type(policy("2.16.76.1.2.304.1", "2.16.76.1.2.1.1")))
+}
@@ -62,6 +62,47 @@ func NewPFXFromDER(dat []byte, password string) (PFX, CodedError) {
 	return pfx, nil
 }
 
+// Signs a file (SHA256 with RSA) with the content type, message digest and signing time signed attributes. If attached is false, the signature will be detached (i.e. it will not include the file content).
+func (pfx PFX) SignFile(path string, attached bool) (*MultSignature, CodedError) {
+	return pfx.SignFileWithPolicy(path, attached, nil)
+}
+
+// Same as SignFile but following a signature policy (ex: AD-RB), which adds the signature policy identifier and signing certificate V2 signed attributes. See NewSignaturePolicy.
+func (pfx PFX) SignFileWithPolicy(path string, attached bool, policy *SignaturePolicy) (*MultSignature, CodedError) {
+	var dat []byte
+	if attached {
+		var err error
+		dat, err = ioutil.ReadFile(path)
+		if err != nil {
+			merr := NewMultiError("failed to read file to sign", ERR_READ_FILE, nil, err)
+			merr.SetParam("path", path)
+			return nil, merr
+		}
+	}
+	msig := new_mult_signature(dat, attached)
+	if cerr := msig.SetContentFile(path); cerr != nil {
+		return nil, cerr
+	}
+	if cerr := msig.add_signature_at(pfx, policy, time.Now()); cerr != nil {
+		return nil, cerr
+	}
+	return msig, nil
+}
+
+// Same as SignFile but for content that is only available in memory.
+func (pfx PFX) SignBytes(content []byte, attached bool) (*MultSignature, CodedError) {
+	return pfx.SignBytesWithPolicy(content, attached, nil)
+}
+
+// Same as SignFileWithPolicy but for content that is only available in memory.
+func (pfx PFX) SignBytesWithPolicy(content []byte, attached bool, policy *SignaturePolicy) (*MultSignature, CodedError) {
+	msig := new_mult_signature(content, attached)
+	if cerr := msig.add_signature_at(pfx, policy, time.Now()); cerr != nil {
+		return nil, cerr
+	}
+	return msig, nil
+}
+
 // Generates a new root CA with subject and issuer TESTING_ROOT_CA_SUBJECT
 //
 // BUG: Subject Public Key Info leads to PKEY_SET_TYPE:unsupported algorithm and X509_PUBKEY_get:unsupported algorithm on openssl
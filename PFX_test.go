@@ -18,3 +18,74 @@ func Test_NewRootCA(t *testing.T) {
 	assert.Nil(t, cerr)
 	os.Remove("my_cert.der")
 }
+
+func get_ciclano_pfx(t *testing.T) PFX {
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/ciclano.p12", "ciclano")
+	require.Nil(t, cerr)
+	return pfx
+}
+
+func Test_PFX_SignFile_1(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignFile("data/hash_test.txt", true)
+	require.Nil(t, cerr)
+
+	assert.Equal(t, []byte("The quick fox jumps over the lazy dog."), msig.ContentAttached)
+	assert.Equal(t, "hash_test.txt", msig.ContentFileName)
+	assert.Equal(t, 1, msig.base.Version)
+	assert.Equal(t, 1, len(msig.base.DigestAlgorithms))
+	assert.Equal(t, 1, len(msig.base.Certificates))
+	require.Equal(t, 1, len(msig.Signatures))
+	sig := msig.Signatures[0]
+	assert.Equal(t, 1, sig.base.Version)
+	assert.Equal(t, 3, len(sig.base.SignedAttrs))
+	assert.Equal(t, pfx.Cert.Subject, sig.Signer.Subject)
+	assert.False(t, sig.SigningTime.IsZero())
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig.Signatures[0].Status.Integrity)
+	assert.Nil(t, msig.Signatures[0].Status.SignerCertError)
+}
+
+func Test_PFX_SignFile_2(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignFile("data/hash_test.txt", false)
+	require.Nil(t, cerr)
+
+	assert.Nil(t, msig.ContentAttached)
+	assert.True(t, msig.base.EncapContentInfo.IsDetached())
+	require.Equal(t, 1, len(msig.Signatures))
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig.Signatures[0].Status.Integrity)
+}
+
+func Test_PFX_SignFile_3(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	_, cerr := pfx.SignFile("data/non_existent_file.txt", true)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_READ_FILE, cerr.Code())
+}
+
+func Test_PFX_SignBytes_1(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), false)
+	require.Nil(t, cerr)
+	assert.True(t, msig.base.EncapContentInfo.IsDetached())
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig.Signatures[0].Status.Integrity)
+}
+
+func Test_PFX_SignBytes_2(t *testing.T) {
+	pfx := PFX{}
+	_, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_PRIVATE_KEY, cerr.Code())
+}
@@ -1,10 +1,21 @@
 package libICP
 
-import "time"
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
 
 // Represents a .p7s file containing one or more signatures and, sometimes, the content being signed.
 type MultSignature struct {
-	base signed_data_raw
+	base  signed_data_raw
+	certs []*Certificate
 
 	FilePath        string
 	FileName        string
@@ -16,33 +27,783 @@ type MultSignature struct {
 
 type Signature struct {
 	base signer_info_raw
+	// Certificate and revocation values (AD-RC)
+	values_certs []*Certificate
+	values_crls  []certificate_list
 
 	Signer      Certificate
 	SigningTime time.Time
 	// Format: "[ISO 3166-1 numeric]:[Text]" Ex: "076:Brasília-DF"
 	SignerLocation string
 	// Possible values: proofOfOrigin, proofOfReceipt, proofOfDelivery, proofOfSender, proofOfApproval, proofOfCreation (or the OID for unknown commitment types)
-	Commitment   string
+	Commitment string
+	// OID of the signature policy (ex: POLICY_AD_RB_V2_3) or empty if there is none
+	PolicyID     string
 	CounterSigns []Signature
-	Status       SignatureCheck
+	// Signature time stamps (AD-RT)
+	TimeStamps []TimeStamp
+	// CAdES-C time stamps over the signature, its time stamps and the validation references (AD-RV)
+	RefsTimeStamps []TimeStamp
+	// Archive time stamps (AD-RA)
+	ArchiveTimeStamps []TimeStamp
+	Status            SignatureCheck
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS") and DER. If the signature is detached, the content file is guessed by removing the ".p7s" or ".sig" extension. Ex: "contract.txt.p7s" -> "contract.txt"
+func NewMultSignatureFromFile(path string) (*MultSignature, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read signature file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	msig, cerr := NewMultSignatureFromBytes(dat)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err != nil {
+		merr := NewMultiError("failed to get absolute path", ERR_FAILED_ABS_PATH, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	msig.FilePath = abs_path
+	msig.FileName = filepath.Base(abs_path)
+
+	// Try to find the content file
+	if msig.base.EncapContentInfo.IsDetached() {
+		ext := strings.ToLower(filepath.Ext(abs_path))
+		if ext == ".p7s" || ext == ".sig" {
+			content_path := strings.TrimSuffix(abs_path, filepath.Ext(abs_path))
+			if _, err := os.Stat(content_path); err == nil {
+				msig.SetContentFile(content_path)
+			}
+		}
+	}
+
+	return msig, nil
+}
+
+// Accepts PEM (with block type "PKCS7" or "CMS") and DER.
+func NewMultSignatureFromBytes(raw []byte) (*MultSignature, CodedError) {
+	block, _ := pem.Decode(raw)
+	if block != nil && (block.Type == "PKCS7" || block.Type == "CMS") {
+		raw = block.Bytes
+	}
+
+	// Remove outer layer
+	ci := content_info_decode{}
+	_, err := asn1.Unmarshal(raw, &ci)
+	if err != nil {
+		// Maybe it uses indefinite lengths (BER)
+		if msig, cerr := new_mult_signature_from_ber(raw); cerr == nil {
+			return msig, nil
+		}
+		merr := NewMultiError("failed to parse CMS content info", ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+	if !ci.ContentType.Equal(idSignedData) {
+		merr := NewMultiError("CMS content is not signed data", ERR_PARSE_SIGNATURE, nil)
+		merr.SetParam("content-type", ci.ContentType.String())
+		return nil, merr
+	}
+
+	return new_mult_signature_from_signed_data(ci.Content.Bytes)
+}
+
+// Parses a DER encoded signed data which is not wrapped in a content info.
+func new_mult_signature_from_signed_data(raw []byte) (*MultSignature, CodedError) {
+	sd := signed_data_decode{}
+	_, err := asn1.Unmarshal(raw, &sd)
+	if err != nil {
+		merr := NewMultiError("failed to parse CMS signed data", ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("raw-data", raw)
+		return nil, merr
+	}
+
+	msig := new(MultSignature)
+	if cerr := msig.load_from_decode(sd); cerr != nil {
+		return nil, cerr
+	}
+	return msig, nil
+}
+
+func (msig *MultSignature) load_from_decode(sd signed_data_decode) CodedError {
+	msig.base.RawContent = sd.RawContent
+	msig.base.Version = sd.Version
+	msig.base.DigestAlgorithms = sd.DigestAlgorithms
+	msig.base.EncapContentInfo = sd.EncapContentInfo
+	msig.ContentAttached = sd.EncapContentInfo.EContent
+
+	// Load certificates (attribute certificates and other formats are not supported yet)
+	msig.certs = make([]*Certificate, 0)
+	msig.base.Certificates = make([]certificate_choice, 0)
+	for _, raw := range sd.Certificates {
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+		cert := new(Certificate)
+		cert.init()
+		if _, cerr := cert.load_from_der(raw.FullBytes); cerr != nil {
+			return cerr
+		}
+		msig.certs = append(msig.certs, cert)
+		choice := certificate_choice{}
+		choice.RawContent = raw.FullBytes
+		choice.Certificate = cert.base
+		msig.base.Certificates = append(msig.base.Certificates, choice)
+	}
+
+	// Load CRLs (other formats are not supported yet)
+	msig.base.CRLs = make([]revocation_info_choice, 0)
+	for _, raw := range sd.CRLs {
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			continue
+		}
+		choice := revocation_info_choice{}
+		if _, cerr := choice.CRL.LoadFromDER(raw.FullBytes); cerr != nil {
+			return cerr
+		}
+		choice.RawContent = raw.FullBytes
+		msig.base.CRLs = append(msig.base.CRLs, choice)
+	}
+
+	// Load signatures
+	msig.base.SignerInfos = make([]signer_info_raw, len(sd.SignerInfos))
+	msig.Signatures = make([]Signature, len(sd.SignerInfos))
+	for i, dec := range sd.SignerInfos {
+		si, cerr := dec.to_signer_info()
+		if cerr != nil {
+			return cerr
+		}
+		msig.base.SignerInfos[i] = si
+		if cerr := msig.Signatures[i].load_from_signer_info(si, msig.certs); cerr != nil {
+			return cerr
+		}
+	}
+
+	return nil
+}
+
+func new_mult_signature(content []byte, attached bool) *MultSignature {
+	msig := new(MultSignature)
+	msig.base.EncapContentInfo.EContentType = idData
+	if attached {
+		msig.base.EncapContentInfo.EContent = content
+		msig.ContentAttached = content
+	} else {
+		msig.base.EncapContentInfo.fallback_content = content
+	}
+	msig.certs = make([]*Certificate, 0)
+	msig.base.Certificates = make([]certificate_choice, 0)
+	return msig
+}
+
+// Adds the certificate to the certificates set, unless it is already there.
+func (msig *MultSignature) add_cert(cert *Certificate) {
+	for _, other := range msig.certs {
+		if bytes.Equal(other.FingerPrint, cert.FingerPrint) {
+			return
+		}
+	}
+	msig.certs = append(msig.certs, cert)
+	choice := certificate_choice{}
+	choice.RawContent = cert.base.RawContent
+	choice.Certificate = cert.base
+	msig.base.Certificates = append(msig.base.Certificates, choice)
+}
+
+// Builds and signs a new signer info (SHA256 with RSA) using the certificate and private key from the PFX. The content type attribute is only added if content_type is not nil, as it MUST NOT be present on counter signatures.
+//
+// If policy is not nil, the signature policy identifier and signing certificate V2 attributes are also added.
+//
+// Possible errors are: ERR_NO_PRIVATE_KEY, ERR_NO_CONTENT, ERR_PARSE_CERT, ERR_FAILED_TO_SIGN, ERR_FAILED_TO_ENCODE, ERR_POLICY_NO_HASH, ERR_POLICY_KEY_SIZE
+func new_signer_info(pfx PFX, encap *encapsulated_content_info, content_type asn1.ObjectIdentifier, policy *SignaturePolicy, now time.Time) (signer_info_raw, CodedError) {
+	si := signer_info_raw{}
+	if !pfx.HasKey() || pfx.Cert == nil {
+		return si, NewMultiError("PFX has no private key or certificate", ERR_NO_PRIVATE_KEY, nil)
+	}
+	if !encap.IsHashable() {
+		return si, NewMultiError("no content to sign", ERR_NO_CONTENT, nil)
+	}
+
+	ias, cerr := pfx.Cert.issuer_and_serial()
+	if cerr != nil {
+		return si, cerr
+	}
+	si.Sid_V1 = ias
+	si.DigestAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	si.SignatureAlgorithm = algorithm_identifier{Algorithm: idSha256WithRSAEncryption}
+	if content_type != nil {
+		si.SetContentTypeAttr(content_type)
+	}
+	si.SetSigningTime(now)
+	if policy != nil {
+		if cerr := si.set_policy_attrs(*policy, pfx); cerr != nil {
+			return si, cerr
+		}
+	}
+	// Required on time stamp tokens (see RFC 3161 Section 2.4.1 and RFC 5816)
+	if content_type.Equal(idCtTSTInfo) {
+		attr, cerr := new_signing_certificate_v2_attr(pfx.Cert)
+		if cerr != nil {
+			return si, cerr
+		}
+		si.set_signed_attr(attr)
+	}
+	if _, cerr := si.GetFinalMessageDigest(encap); cerr != nil {
+		return si, cerr
+	}
+	if cerr := si.Sign(pfx.rsa_key); cerr != nil {
+		return si, cerr
+	}
+	si.SetAppropriateVersion()
+	return si, nil
+}
+
+// Adds a new signature using the certificate and private key from the PFX. The content (attached or fallback) MUST have already been set.
+func (msig *MultSignature) add_signature_at(pfx PFX, policy *SignaturePolicy, now time.Time) CodedError {
+	si, cerr := new_signer_info(pfx, &msig.base.EncapContentInfo, msig.base.EncapContentInfo.EContentType, policy, now)
+	if cerr != nil {
+		return cerr
+	}
+
+	// Update signed data
+	msig.add_cert(pfx.Cert)
+	msig.base.SignerInfos = append(msig.base.SignerInfos, si)
+	msig.base.update_algs()
+	msig.base.set_appropriate_version()
+	// Ensure it will be marshaled again
+	msig.base.RawContent = nil
+
+	sig := Signature{}
+	if cerr := sig.load_from_signer_info(si, msig.certs); cerr != nil {
+		return cerr
+	}
+	msig.Signatures = append(msig.Signatures, sig)
+	return nil
+}
+
+// Adds a counter signature to sig, which MUST be one of this file signatures or counter signatures. The counter signature signs the signature value of sig (see RFC 5652 Section 11.4)
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND, ERR_NO_PRIVATE_KEY, ERR_PARSE_CERT, ERR_FAILED_TO_SIGN, ERR_FAILED_TO_ENCODE
+func (msig *MultSignature) CounterSign(sig *Signature, pfx PFX) CodedError {
+	return msig.counter_sign_at(sig, pfx, time.Now())
+}
+
+func (msig *MultSignature) counter_sign_at(sig *Signature, pfx PFX, now time.Time) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	encap := &encapsulated_content_info{EContent: sig.base.Signature}
+	si, cerr := new_signer_info(pfx, encap, nil, nil, now)
+	if cerr != nil {
+		return cerr
+	}
+
+	msig.add_cert(pfx.Cert)
+	counter := Signature{}
+	if cerr := counter.load_from_signer_info(si, msig.certs); cerr != nil {
+		return cerr
+	}
+	sig.CounterSigns = append(sig.CounterSigns, counter)
+	// Ensure it will be marshaled again (see sync_counter_signs)
+	sig.base.RawContent = nil
+	return nil
+}
+
+// Returns an error if sig is not one of this file signatures or counter signatures. A copy (ex: a range variable) could still be changed, but the changes would never be saved.
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND
+func (msig *MultSignature) check_has_signature(sig *Signature) CodedError {
+	if !signatures_contain(msig.Signatures, sig) {
+		return NewMultiError("signature is not one of this file signatures or counter signatures (is it a copy?)", ERR_SIGNATURE_NOT_FOUND, nil)
+	}
+	return nil
+}
+
+// Returns true if sig points to one of sigs or to one of their counter signatures.
+func signatures_contain(sigs []Signature, sig *Signature) bool {
+	for i := range sigs {
+		if &sigs[i] == sig || signatures_contain(sigs[i].CounterSigns, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// Updates the signer infos so they include any new counter signatures.
+func (msig *MultSignature) sync_signer_infos() {
+	for i := range msig.Signatures {
+		if msig.Signatures[i].sync_counter_signs() {
+			msig.base.SignerInfos[i] = msig.Signatures[i].base
+			msig.base.RawContent = nil
+		}
+	}
+}
+
+// Rebuilds the counter signature unsigned attributes from CounterSigns if this signature or any of its counter signatures was changed. Returns true if the signer info was changed.
+func (sig *Signature) sync_counter_signs() bool {
+	changed := len(sig.base.RawContent) == 0
+	for i := range sig.CounterSigns {
+		if sig.CounterSigns[i].sync_counter_signs() {
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	// The counter signatures are kept where they were, as archive time stamps cover the attributes that come before them
+	attrs := sig.base.UnsignedAttrs
+	pos := len(attrs)
+	for i, attr := range attrs {
+		if attr.Type.Equal(idCounterSignature) {
+			pos = i
+			break
+		}
+	}
+	counter_attrs := make([]attribute, len(sig.CounterSigns))
+	for i, counter := range sig.CounterSigns {
+		counter_attrs[i].Type = idCounterSignature
+		counter_attrs[i].Values = []interface{}{counter.base}
+	}
+	ans := make([]attribute, 0, len(attrs)+len(counter_attrs))
+	ans = append(ans, attrs[:pos]...)
+	ans = append(ans, counter_attrs...)
+	sig.base.UnsignedAttrs = append(ans, remove_attrs_by_type(attrs[pos:], idCounterSignature)...)
+	sig.base.RawContent = nil
+	return true
+}
+
+// Adds a new signer (in parallel to the existing ones) over the same content. For detached signatures, the content file MUST have been set. (see SetContentFile)
+//
+// Attribute certificates and other certificate formats included in a parsed signature file will not be kept.
+//
+// Possible errors are: ERR_NO_PRIVATE_KEY, ERR_NO_CONTENT, ERR_PARSE_CERT, ERR_FAILED_TO_SIGN, ERR_FAILED_TO_ENCODE
+func (msig *MultSignature) CoSign(pfx PFX) CodedError {
+	return msig.add_signature_at(pfx, nil, time.Now())
+}
+
+// Same as CoSign but following a signature policy (ex: AD-RB).
+func (msig *MultSignature) CoSignWithPolicy(pfx PFX, policy *SignaturePolicy) CodedError {
+	return msig.add_signature_at(pfx, policy, time.Now())
+}
+
+// Returns the content digest for the given algorithm either by hashing the content or, if it is not available, by looking at the message digest attribute of the signers.
+func (msig *MultSignature) content_digest(alg_id algorithm_identifier) ([]byte, bool) {
+	if msig.base.EncapContentInfo.IsHashable() {
+		ans, cerr := msig.base.EncapContentInfo.HashAs(alg_id)
+		return ans, cerr == nil
+	}
+	for _, si := range msig.base.SignerInfos {
+		if !si.DigestAlgorithm.Algorithm.Equal(alg_id.Algorithm) {
+			continue
+		}
+		if attr, ok := si.get_signed_attr(idMessageDigest); ok {
+			ans := make([]byte, 0)
+			if err := attr.first_value(&ans); err == nil {
+				return ans, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Joins multiple signature files about the same content (i.e. signed "in parallel") into a single one. Certificates and CRLs are deduplicated.
+//
+// Possible errors are: ERR_NO_SIGNATURES, ERR_CONTENT_MISMATCH, ERR_FILE_NOT_EXISTS, ERR_FAILED_ABS_PATH
+func JoinSignatures(sigs ...*MultSignature) (*MultSignature, CodedError) {
+	if len(sigs) == 0 {
+		return nil, NewMultiError("no signatures to join", ERR_NO_SIGNATURES, nil)
+	}
+
+	// Ensure all signatures are about the same content
+	ref := sigs[0]
+	for i, other := range sigs[1:] {
+		if !other.base.EncapContentInfo.EContentType.Equal(ref.base.EncapContentInfo.EContentType) {
+			merr := NewMultiError("signatures have different content types", ERR_CONTENT_MISMATCH, nil)
+			merr.SetParam("index", i+1)
+			merr.SetParam("expected", ref.base.EncapContentInfo.EContentType)
+			merr.SetParam("actual", other.base.EncapContentInfo.EContentType)
+			return nil, merr
+		}
+		for _, si := range other.base.SignerInfos {
+			ref_digest, ok1 := ref.content_digest(si.DigestAlgorithm)
+			other_digest, ok2 := other.content_digest(si.DigestAlgorithm)
+			if !ok1 || !ok2 || !bytes.Equal(ref_digest, other_digest) {
+				merr := NewMultiError("signatures have different content digests", ERR_CONTENT_MISMATCH, nil)
+				merr.SetParam("index", i+1)
+				merr.SetParam("algorithm", si.DigestAlgorithm.Algorithm)
+				merr.SetParam("expected", ref_digest)
+				merr.SetParam("actual", other_digest)
+				return nil, merr
+			}
+		}
+	}
+
+	// Prefer attached content
+	ans := new_mult_signature(nil, false)
+	ans.base.EncapContentInfo.EContentType = ref.base.EncapContentInfo.EContentType
+	for _, msig := range sigs {
+		if !msig.base.EncapContentInfo.IsDetached() {
+			ans.base.EncapContentInfo.EContent = msig.base.EncapContentInfo.EContent
+			ans.ContentAttached = msig.base.EncapContentInfo.EContent
+			break
+		}
+	}
+	for _, msig := range sigs {
+		if msig.ContentFilePath != "" {
+			if cerr := ans.SetContentFile(msig.ContentFilePath); cerr != nil {
+				return nil, cerr
+			}
+			break
+		}
+	}
+
+	// Merge everything else
+	ans.base.CRLs = make([]revocation_info_choice, 0)
+	for _, msig := range sigs {
+		msig.sync_signer_infos()
+		for _, cert := range msig.certs {
+			ans.add_cert(cert)
+		}
+		for _, crl := range msig.base.CRLs {
+			ans.add_crl(crl)
+		}
+		ans.base.SignerInfos = append(ans.base.SignerInfos, msig.base.SignerInfos...)
+		ans.Signatures = append(ans.Signatures, msig.Signatures...)
+	}
+	ans.base.update_algs()
+	ans.base.set_appropriate_version()
+
+	return ans, nil
+}
+
+// Adds the CRL to the CRLs set, unless it is already there.
+func (msig *MultSignature) add_crl(crl revocation_info_choice) {
+	for _, other := range msig.base.CRLs {
+		if bytes.Equal(other.RawContent, crl.RawContent) {
+			return
+		}
+	}
+	msig.base.CRLs = append(msig.base.CRLs, crl)
+}
+
+// Sets the file that will be used as content for detached signatures.
+func (msig *MultSignature) SetContentFile(path string) CodedError {
+	if cerr := msig.base.EncapContentInfo.SetFallbackFile(path); cerr != nil {
+		return cerr
+	}
+	msig.ContentFilePath = msig.base.EncapContentInfo.fallback_file
+	msig.ContentFileName = filepath.Base(msig.ContentFilePath)
+	return nil
+}
+
+// Fills the signature fields from its signer info. The signer is searched among the given certificates and is left empty if not found.
+func (sig *Signature) load_from_signer_info(si signer_info_raw, certs []*Certificate) CodedError {
+	sig.base = si
+
+	for _, cert := range certs {
+		if si.is_signed_by(*cert) {
+			sig.Signer = *cert
+			break
+		}
+	}
+
+	// Load counter signatures
+	sig.CounterSigns = make([]Signature, 0)
+	for _, attr := range si.UnsignedAttrs {
+		if !attr.Type.Equal(idCounterSignature) {
+			continue
+		}
+		for _, val := range attr.Values {
+			raw, ok := val.(asn1.RawValue)
+			if !ok {
+				continue
+			}
+			dec := signer_info_decode{}
+			_, err := asn1.Unmarshal(raw.FullBytes, &dec)
+			if err != nil {
+				merr := NewMultiError("failed to parse counter signature", ERR_PARSE_SIGNATURE, nil, err)
+				merr.SetParam("raw-data", raw.FullBytes)
+				return merr
+			}
+			counter_si, cerr := dec.to_signer_info()
+			if cerr != nil {
+				return cerr
+			}
+			counter := Signature{}
+			if cerr := counter.load_from_signer_info(counter_si, certs); cerr != nil {
+				return cerr
+			}
+			sig.CounterSigns = append(sig.CounterSigns, counter)
+		}
+	}
+
+	if cerr := sig.load_time_stamps(); cerr != nil {
+		return cerr
+	}
+	if cerr := sig.load_validation_values(); cerr != nil {
+		return cerr
+	}
+
+	if attr, ok := si.get_signed_attr(idSigningTime); ok {
+		if err := attr.first_value(&sig.SigningTime); err != nil {
+			merr := NewMultiError("failed to parse signing time attribute", ERR_PARSE_SIGNATURE, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+	}
+
+	if attr, ok := si.get_signed_attr(idCommitmentTypeIndication); ok {
+		commitment := commitment_type_indication{}
+		if err := attr.first_value(&commitment); err != nil {
+			merr := NewMultiError("failed to parse commitment type indication attribute", ERR_PARSE_SIGNATURE, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+		sig.Commitment = commitment2str(commitment.CommitmentTypeId)
+	}
+
+	if attr, ok := si.get_signed_attr(idSignerLocation); ok {
+		location := signer_location{}
+		if err := attr.first_value(&location); err != nil {
+			merr := NewMultiError("failed to parse signer location attribute", ERR_PARSE_SIGNATURE, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+		sig.SignerLocation = location.CountryName + ":" + location.LocalityName
+	}
+
+	if attr, ok := si.get_signed_attr(idAaEtsSigPolicyId); ok {
+		spi := signature_policy_id{}
+		if err := attr.first_value(&spi); err == nil {
+			sig.PolicyID = spi.SigPolicyId.String()
+		}
+	}
+
+	return nil
 }
 
 // Will attempt to save as a detached signature with file name "[content file with extension].sig" Ex: "contract.txt.sig"
+//
+// Attached signatures are saved as "[content file with extension].p7s" and, if there is no content file, the file this signature was loaded from is overwritten. Other existing files are never overwritten: use SaveToFile for that.
+//
+// Possible errors are: ERR_FILE_ALREADY_EXISTS, ERR_FAILED_TO_WRITE_FILE, ERR_FAILED_TO_ENCODE
 func (msig *MultSignature) SaveToP7SFile() CodedError {
+	path := msig.FilePath
+	if msig.ContentFilePath != "" {
+		if msig.base.EncapContentInfo.IsDetached() {
+			path = msig.ContentFilePath + ".sig"
+		} else {
+			path = msig.ContentFilePath + ".p7s"
+		}
+	}
+	if path == "" {
+		return NewMultiError("no content file nor signature file path to save to", ERR_FAILED_TO_WRITE_FILE, nil)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if abs_path, err := filepath.Abs(path); err != nil || abs_path != msig.FilePath {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	return msig.save_to_file(path, flags)
+}
+
+// Saves the signature as DER. The file is overwritten if it already exists.
+//
+// Possible errors are: ERR_FAILED_TO_WRITE_FILE, ERR_FAILED_TO_ENCODE
+func (msig *MultSignature) SaveToFile(path string) CodedError {
+	return msig.save_to_file(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+}
+
+func (msig *MultSignature) save_to_file(path string, flags int) CodedError {
+	dat, cerr := msig.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		merr := NewMultiError("file already exists", ERR_FILE_ALREADY_EXISTS, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+	if err == nil {
+		_, err = f.Write(dat)
+		if close_err := f.Close(); err == nil {
+			err = close_err
+		}
+	}
+	if err != nil {
+		merr := NewMultiError("failed to write to file", ERR_FAILED_TO_WRITE_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+
+	abs_path, err := filepath.Abs(path)
+	if err == nil {
+		msig.FilePath = abs_path
+		msig.FileName = filepath.Base(abs_path)
+	}
 	return nil
 }
 
-// Verify all signatures recursively
+// Returns the signed data wrapped in a content info (see RFC 5652 Section 3) encoded as DER.
+func (msig *MultSignature) MarshalDER() ([]byte, CodedError) {
+	sd_raw, cerr := msig.marshal_signed_data()
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	ci := content_info_decode{}
+	ci.ContentType = idSignedData
+	ci.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd_raw}
+	dat, err := asn1.Marshal(ci)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal content info", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return dat, nil
+}
+
+// Returns the signed data without the content info.
+func (msig *MultSignature) marshal_signed_data() ([]byte, CodedError) {
+	msig.sync_signer_infos()
+	sd_raw, err := asn1.Marshal(msig.base)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal signed data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return sd_raw, nil
+}
+
+// Same as MarshalDER but encoded as PEM with block type "PKCS7".
+func (msig *MultSignature) MarshalPEM() ([]byte, CodedError) {
+	dat, cerr := msig.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+	block := &pem.Block{Type: "PKCS7", Bytes: dat}
+	return pem.EncodeToMemory(block), nil
+}
+
+// Verify all signatures recursively. The results are saved on each signature Status field.
+//
+// Certificate authorities included in the signature file (or in the certificate values of a signature) are added to the store (if they are valid).
+//
+// Possible errors are: ERR_NO_CONTENT (when the signature is detached and no content file was set)
 func (msig *MultSignature) CheckAll(store *CAStore) CodedError {
+	return msig.check_all_at(store, time.Now())
+}
+
+func (msig *MultSignature) check_all_at(store *CAStore, now time.Time) CodedError {
+	return msig.check_all_for_at(store, CERT_USAGE_DOCUMENT_SIGNING, now)
+}
+
+// Same as check_all_at, but the signer certificates are checked for the given usage instead. (ex: CERT_USAGE_TIMESTAMPING for time stamp tokens)
+func (msig *MultSignature) check_all_for_at(store *CAStore, usage CERT_USAGE, now time.Time) CodedError {
+	encap := &msig.base.EncapContentInfo
+	if !encap.IsHashable() {
+		merr := NewMultiError("no content to verify the signatures against", ERR_NO_CONTENT, nil)
+		merr.SetParam("FilePath", msig.FilePath)
+		return merr
+	}
+
+	for _, cert := range msig.certs {
+		if cert.IsCA() {
+			store.add_ca_at_time(cert, now)
+		}
+	}
+	// Including the ones from certificate values (AD-RC)
+	for _, sig := range msig.Signatures {
+		for _, cert := range sig.values_certs {
+			if cert.IsCA() {
+				store.add_ca_at_time(cert, now)
+			}
+		}
+	}
+
+	// Calculate all content digests in a single pass
+	alg_ids := make([]algorithm_identifier, 0)
+	for _, sig := range msig.Signatures {
+		alg_ids = append(alg_ids, sig.base.DigestAlgorithm)
+	}
+	encap.hash_all(alg_ids)
+
+	crls := msig.crl_list()
+	for i := range msig.Signatures {
+		msig.Signatures[i].check_at(store, encap, usage, false, now)
+		msig.Signatures[i].check_refs_at(store, msig.certs, crls, now)
+		msig.check_archive_time_stamps_at(&msig.Signatures[i], store, now)
+	}
 	return nil
 }
 
+// Verifies this signature and its counter signatures against the given content. The signer certificate MUST be valid for the given usage. The is_counter flag tells whether this is a counter signature.
+func (sig *Signature) check_at(store *CAStore, encap *encapsulated_content_info, usage CERT_USAGE, is_counter bool, now time.Time) {
+	sig.Status = SignatureCheck{}
+
+	if sig.Signer.base.TBSCertificate.SerialNumber == nil {
+		merr := NewMultiError("signer certificate not found", ERR_SIGNER_NOT_FOUND, nil)
+		merr.SetParam("Sid_V1.SerialNumber", sig.base.Sid_V1.SerialNumber)
+		merr.SetParam("Sid_V3", nice_hex(sig.base.Sid_V3))
+		sig.Status.SignerCertError = merr
+	} else {
+		// Check integrity
+		pubkey, err := sig.Signer.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+		if err == nil {
+			sig.Status.IntegrityError = sig.base.verify(encap, pubkey, is_counter)
+			sig.Status.Integrity = (sig.Status.IntegrityError == nil)
+		} else {
+			sig.Status.IntegrityError = NewMultiError("failed to parse signer public key", ERR_PARSE_RSA_PUBKEY, nil, err)
+		}
+
+		// Check signer certificate
+		path, _, errs, _ := store.verify_cert_at(&sig.Signer, now)
+		if len(path) > 0 {
+			sig.Status.RootCA = path[len(path)-1].Subject
+		}
+		if cerr := sig.Signer.ValidFor(usage); cerr != nil {
+			errs = append(errs, cerr)
+		}
+		sig.Status.CRL_Status = sig.Signer.CRL_Status
+		if len(errs) == 1 {
+			sig.Status.SignerCertError = errs[0]
+		}
+		if len(errs) > 1 {
+			inner := make([]interface{}, len(errs))
+			for i := range errs {
+				inner[i] = errs[i]
+			}
+			merr := NewMultiError("signer certificate is not valid", errs[0].Code(), nil, inner...)
+			merr.SetParam("Signer.Subject", sig.Signer.Subject)
+			sig.Status.SignerCertError = merr
+		}
+	}
+
+	for i := range sig.TimeStamps {
+		sig.TimeStamps[i].check_at(store, sig.base.Signature, now)
+	}
+	sig.Status.PolicyErrors = sig.check_policy(store.Policies, now)
+
+	// The content of a counter signature is the signature value of the signer info it countersigns (see RFC 5652 Section 11.4)
+	counter_encap := &encapsulated_content_info{EContent: sig.base.Signature}
+	for i := range sig.CounterSigns {
+		sig.CounterSigns[i].check_at(store, counter_encap, usage, true, now)
+	}
+}
+
 type SignatureCheck struct {
-	Integrity       bool
+	Integrity bool
+	// Why the integrity check failed (ex: ERR_BAD_DIGEST if the content does not match the message digest attribute)
+	IntegrityError  CodedError
 	RootCA          string
 	CRL_Status      CRLStatus
 	SignerCertError CodedError
 	PolicyErrors    []CodedError
+	// Validation references (AD-RV) not found among the supplied certificates and CRLs
+	RefsErrors []CodedError
 }
 
 func (sig SignatureCheck) IsSignerCertValid() bool {
@@ -52,3 +813,7 @@ func (sig SignatureCheck) IsSignerCertValid() bool {
 func (sig SignatureCheck) IsPolicyCompliant() bool {
 	return len(sig.PolicyErrors) == 0
 }
+
+func (sig SignatureCheck) AreRefsValid() bool {
+	return len(sig.RefsErrors) == 0
+}
@@ -0,0 +1,527 @@
+package libICP
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewMultSignatureFromFile_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/fulano_attached.p7s")
+	require.Nil(t, cerr)
+	require.NotNil(t, msig)
+
+	assert.Equal(t, "fulano_attached.p7s", msig.FileName)
+	assert.True(t, filepath.IsAbs(msig.FilePath))
+	assert.Equal(t, []byte("The quick fox jumps over the lazy dog."), msig.ContentAttached)
+	assert.False(t, msig.base.EncapContentInfo.IsDetached())
+	assert.Equal(t, 2, len(msig.base.Certificates))
+	require.Equal(t, 1, len(msig.Signatures))
+
+	sig := msig.Signatures[0]
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=LáLáLá?/CN=Fulano da Sílva", sig.Signer.Subject)
+	assert.False(t, sig.SigningTime.IsZero())
+	assert.Equal(t, 4, len(sig.base.SignedAttrs))
+	assert.Equal(t, byte(0x31), sig.base.SignedRaw[0])
+}
+
+func Test_NewMultSignatureFromFile_2(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/beltrano_detached.p7s")
+	require.Nil(t, cerr)
+	require.NotNil(t, msig)
+
+	assert.Nil(t, msig.ContentAttached)
+	assert.True(t, msig.base.EncapContentInfo.IsDetached())
+	assert.Equal(t, "", msig.ContentFilePath)
+	require.Equal(t, 1, len(msig.Signatures))
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=FakeBank Certificados Digitais/CN=Beltrano Freitas:12345678900", msig.Signatures[0].Signer.Subject)
+}
+
+func Test_NewMultSignatureFromFile_3(t *testing.T) {
+	// The content file should be found when it is next to the signature
+	dir, err := ioutil.TempDir("", "libICP")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	content, err := ioutil.ReadFile("data/hash_test.txt")
+	require.Nil(t, err)
+	sig, err := ioutil.ReadFile("data/test-sigs/beltrano_detached.p7s")
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "hash_test.txt"), content, 0600))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "hash_test.txt.p7s"), sig, 0600))
+
+	msig, cerr := NewMultSignatureFromFile(filepath.Join(dir, "hash_test.txt.p7s"))
+	require.Nil(t, cerr)
+	assert.Equal(t, "hash_test.txt", msig.ContentFileName)
+	assert.True(t, msig.base.EncapContentInfo.IsHashable())
+}
+
+func Test_NewMultSignatureFromFile_4(t *testing.T) {
+	_, cerr := NewMultSignatureFromFile("data/test-sigs/this_file_does_not_exist.p7s")
+	require.NotNil(t, cerr)
+	assert.Equal(t, ERR_READ_FILE, int(cerr.Code()))
+}
+
+func Test_NewMultSignatureFromBytes_1(t *testing.T) {
+	_, cerr := NewMultSignatureFromBytes([]byte("not a signature"))
+	require.NotNil(t, cerr)
+	assert.Equal(t, ERR_PARSE_SIGNATURE, int(cerr.Code()))
+}
+
+func Test_Signature_LoadFromSignerInfo_1(t *testing.T) {
+	si := signer_info_raw{}
+	si.SetSigningTime(time.Date(2018, 11, 20, 12, 30, 0, 0, time.UTC))
+
+	commitment := attribute{}
+	commitment.Type = idCommitmentTypeIndication
+	commitment.Values = []interface{}{commitment_type_indication{CommitmentTypeId: idCtiEtsProofOfApproval}}
+	si.SignedAttrs = append(si.SignedAttrs, commitment)
+
+	location := attribute{}
+	location.Type = idSignerLocation
+	location.Values = []interface{}{signer_location{CountryName: "076", LocalityName: "Brasília-DF"}}
+	si.SignedAttrs = append(si.SignedAttrs, location)
+
+	sig := Signature{}
+	cerr := sig.load_from_signer_info(si, nil)
+	require.Nil(t, cerr)
+	assert.Equal(t, time.Date(2018, 11, 20, 12, 30, 0, 0, time.UTC), sig.SigningTime)
+	assert.Equal(t, "proofOfApproval", sig.Commitment)
+	assert.Equal(t, "076:Brasília-DF", sig.SignerLocation)
+}
+
+func get_test_store(t *testing.T, with_fakebank bool) *CAStore {
+	store := NewCAStore(false)
+	certs, errs := NewCertificateFromFile("data/test-chain/certs/root-ca.cert.pem")
+	require.Nil(t, errs)
+	store.direct_add_ca(certs[0])
+	if with_fakebank {
+		certs, errs = NewCertificateFromFile("data/test-chain/intermediate/fakebank/certs/fakebank-ca.crt.pem")
+		require.Nil(t, errs)
+		store.direct_add_ca(certs[0])
+	}
+	store.Policies = get_test_policies(t)
+	return store
+}
+
+func Test_MultSignature_CheckAll_1(t *testing.T) {
+	// The intermediate CA should be taken from the signature file
+	store := get_test_store(t, false)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	assert.Nil(t, status.SignerCertError)
+	assert.True(t, status.IsSignerCertValid())
+	assert.True(t, status.IsPolicyCompliant())
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=Apenas para testes - SEM VALOR LEGAL/CN=Autoridade Certificadora Raiz de Testes - SEM VALOR LEGAL", status.RootCA)
+	assert.EqualValues(t, CRL_UNSURE_OR_NOT_FOUND, status.CRL_Status)
+}
+
+func Test_MultSignature_CheckAll_2(t *testing.T) {
+	// Signer certificate has expired
+	store := get_test_store(t, true)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+
+	cerr = msig.check_all_at(store, time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	require.NotNil(t, status.SignerCertError)
+	assert.EqualValues(t, ERR_NOT_AFTER_DATE, status.SignerCertError.Code())
+}
+
+func Test_MultSignature_CheckAll_3(t *testing.T) {
+	// Wrong content
+	store := get_test_store(t, true)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+	require.Nil(t, msig.SetContentFile("data/test-chain/serial"))
+
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.False(t, status.Integrity)
+	require.NotNil(t, status.IntegrityError)
+	assert.EqualValues(t, ERR_BAD_DIGEST, status.IntegrityError.Code())
+	assert.Nil(t, status.SignerCertError)
+}
+
+func Test_MultSignature_CheckAll_4(t *testing.T) {
+	store := get_test_store(t, true)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+	require.Nil(t, msig.SetContentFile("data/hash_test.txt"))
+
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	assert.Nil(t, status.SignerCertError)
+}
+
+func Test_MultSignature_CheckAll_5(t *testing.T) {
+	// No content
+	store := get_test_store(t, true)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+
+	cerr = msig.CheckAll(store)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_CONTENT, cerr.Code())
+}
+
+func Test_MultSignature_CheckAll_6(t *testing.T) {
+	// Signer certificate is only allowed to be used for encryption
+	store := get_test_store(t, true)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	msig.Signatures[0].Signer.ext_key_usage = ext_key_usage{Exists: true, KeyEncipherment: true}
+
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	require.NotNil(t, status.SignerCertError)
+	assert.EqualValues(t, ERR_CERT_USAGE_NOT_ALLOWED, status.SignerCertError.Code())
+}
+
+func Test_Signature_CheckAt_1(t *testing.T) {
+	// Signer not found
+	store := get_test_store(t, true)
+	sig := Signature{}
+	sig.check_at(store, &encapsulated_content_info{EContent: []byte{}}, CERT_USAGE_DOCUMENT_SIGNING, false, time.Now())
+	assert.False(t, sig.Status.Integrity)
+	require.NotNil(t, sig.Status.SignerCertError)
+	assert.EqualValues(t, ERR_SIGNER_NOT_FOUND, sig.Status.SignerCertError.Code())
+}
+
+func Test_Signature_CheckAt_2(t *testing.T) {
+	// Invalid signer public key
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	sig := &msig.Signatures[0]
+	sig.Signer.base.TBSCertificate.SubjectPublicKeyInfo.PublicKey.Bytes = []byte{1, 2, 3}
+	sig.check_at(get_test_store(t, true), &msig.base.EncapContentInfo, CERT_USAGE_DOCUMENT_SIGNING, false, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	assert.False(t, sig.Status.Integrity)
+	require.NotNil(t, sig.Status.IntegrityError)
+	assert.EqualValues(t, ERR_PARSE_RSA_PUBKEY, sig.Status.IntegrityError.Code())
+}
+
+func Test_MultSignature_MarshalDER_1(t *testing.T) {
+	// Parsed signatures must be kept exactly as they are
+	raw, err := ioutil.ReadFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, err)
+	msig, cerr := NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+
+	dat, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.Equal(t, raw, dat)
+}
+
+func Test_MultSignature_MarshalDER_2(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.Nil(t, cerr)
+
+	dat1, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig2, cerr := NewMultSignatureFromBytes(dat1)
+	require.Nil(t, cerr)
+	dat2, cerr := msig2.MarshalDER()
+	require.Nil(t, cerr)
+	assert.Equal(t, dat1, dat2)
+
+	require.Equal(t, 1, len(msig2.Signatures))
+	assert.Equal(t, msig.Signatures[0].SigningTime, msig2.Signatures[0].SigningTime)
+	assert.Equal(t, pfx.Cert.Subject, msig2.Signatures[0].Signer.Subject)
+	assert.Equal(t, []byte("Lorem Ipsum Dolor Est\n"), msig2.ContentAttached)
+
+	store := get_test_store(t, true)
+	cerr = msig2.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig2.Signatures[0].Status.Integrity)
+	assert.Nil(t, msig2.Signatures[0].Status.SignerCertError)
+}
+
+func Test_MultSignature_MarshalPEM_1(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), false)
+	require.Nil(t, cerr)
+
+	dat, cerr := msig.MarshalPEM()
+	require.Nil(t, cerr)
+	assert.True(t, bytes.HasPrefix(dat, []byte("-----BEGIN PKCS7-----")))
+	msig2, cerr := NewMultSignatureFromBytes(dat)
+	require.Nil(t, cerr)
+	assert.True(t, msig2.base.EncapContentInfo.IsDetached())
+	der1, _ := msig.MarshalDER()
+	der2, _ := msig2.MarshalDER()
+	assert.Equal(t, der1, der2)
+}
+
+func Test_MultSignature_SaveToP7SFile_1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libICP")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	content_path := filepath.Join(dir, "contract.txt")
+	require.Nil(t, ioutil.WriteFile(content_path, []byte("Lorem Ipsum Dolor Est\n"), 0600))
+
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignFile(content_path, false)
+	require.Nil(t, cerr)
+	require.Nil(t, msig.SaveToP7SFile())
+	assert.Equal(t, "contract.txt.sig", msig.FileName)
+
+	msig2, cerr := NewMultSignatureFromFile(content_path + ".sig")
+	require.Nil(t, cerr)
+	assert.Equal(t, content_path, msig2.ContentFilePath)
+	store := get_test_store(t, true)
+	cerr = msig2.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig2.Signatures[0].Status.Integrity)
+}
+
+func Test_MultSignature_SaveToP7SFile_2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libICP")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	content_path := filepath.Join(dir, "contract.txt")
+	require.Nil(t, ioutil.WriteFile(content_path, []byte("Lorem Ipsum Dolor Est\n"), 0600))
+
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignFile(content_path, true)
+	require.Nil(t, cerr)
+	require.Nil(t, msig.SaveToP7SFile())
+	assert.Equal(t, "contract.txt.p7s", msig.FileName)
+}
+
+func Test_MultSignature_SaveToP7SFile_3(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.Nil(t, cerr)
+	cerr = msig.SaveToP7SFile()
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_FAILED_TO_WRITE_FILE, cerr.Code())
+}
+
+func Test_MultSignature_SaveToP7SFile_4(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libICP")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	content_path := filepath.Join(dir, "contract.txt")
+	require.Nil(t, ioutil.WriteFile(content_path, []byte("Lorem Ipsum Dolor Est\n"), 0600))
+
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignFile(content_path, false)
+	require.Nil(t, cerr)
+	require.Nil(t, msig.SaveToP7SFile())
+	// Saving it again updates its own file
+	require.Nil(t, msig.SaveToP7SFile())
+
+	// But another signature must not replace it
+	other, cerr := pfx.SignFile(content_path, false)
+	require.Nil(t, cerr)
+	cerr = other.SaveToP7SFile()
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_FILE_ALREADY_EXISTS, cerr.Code())
+	msig2, cerr := NewMultSignatureFromFile(content_path + ".sig")
+	require.Nil(t, cerr)
+	assert.Equal(t, msig.Signatures[0].base.Signature, msig2.Signatures[0].base.Signature)
+}
+
+func Test_MultSignature_CoSign_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+
+	require.Nil(t, msig.CoSign(pfx))
+	assert.Equal(t, 3, len(msig.base.Certificates))
+	assert.Equal(t, 1, len(msig.base.DigestAlgorithms))
+	require.Equal(t, 2, len(msig.Signatures))
+	assert.Equal(t, pfx.Cert.Subject, msig.Signatures[1].Signer.Subject)
+
+	// Save and load it again
+	dat, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(dat)
+	require.Nil(t, cerr)
+	assert.Equal(t, 1, msig.base.Version)
+	assert.Equal(t, 3, len(msig.base.Certificates))
+	require.Equal(t, 2, len(msig.Signatures))
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	for _, sig := range msig.Signatures {
+		assert.True(t, sig.Status.Integrity)
+		assert.Nil(t, sig.Status.SignerCertError)
+	}
+}
+
+func Test_MultSignature_CoSign_2(t *testing.T) {
+	// Detached signature without content
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+	pfx := get_ciclano_pfx(t)
+
+	cerr = msig.CoSign(pfx)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_CONTENT, cerr.Code())
+	assert.Equal(t, 1, len(msig.Signatures))
+
+	require.Nil(t, msig.SetContentFile("data/hash_test.txt"))
+	require.Nil(t, msig.CoSign(pfx))
+	assert.Equal(t, 2, len(msig.Signatures))
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	for _, sig := range msig.Signatures {
+		assert.True(t, sig.Status.Integrity)
+	}
+}
+
+func Test_MultSignature_CounterSign_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+
+	require.Nil(t, msig.CounterSign(&msig.Signatures[0], pfx))
+	require.Equal(t, 1, len(msig.Signatures[0].CounterSigns))
+	counter := msig.Signatures[0].CounterSigns[0]
+	assert.Equal(t, pfx.Cert.Subject, counter.Signer.Subject)
+	_, has_content_type := counter.base.get_signed_attr(idContentType)
+	assert.False(t, has_content_type, "counter signatures MUST NOT have the content type attribute")
+
+	// Counter sign the counter signature
+	require.Nil(t, msig.CounterSign(&msig.Signatures[0].CounterSigns[0], get_ciclano_pfx(t)))
+
+	// Save and load it again
+	dat, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(dat)
+	require.Nil(t, cerr)
+	assert.Equal(t, 3, len(msig.base.Certificates))
+	require.Equal(t, 1, len(msig.Signatures))
+	require.Equal(t, 1, len(msig.Signatures[0].CounterSigns))
+	require.Equal(t, 1, len(msig.Signatures[0].CounterSigns[0].CounterSigns))
+	assert.Equal(t, pfx.Cert.Subject, msig.Signatures[0].CounterSigns[0].Signer.Subject)
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	assert.True(t, sig.Status.Integrity)
+	assert.True(t, sig.CounterSigns[0].Status.Integrity)
+	assert.Nil(t, sig.CounterSigns[0].Status.SignerCertError)
+	assert.True(t, sig.CounterSigns[0].CounterSigns[0].Status.Integrity)
+
+	// Must be byte stable
+	dat2, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.Equal(t, dat, dat2)
+}
+
+func Test_MultSignature_CounterSign_2(t *testing.T) {
+	// A tampered signature value must invalidate the counter signature
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.Nil(t, cerr)
+	require.Nil(t, msig.CounterSign(&msig.Signatures[0], pfx))
+	msig.Signatures[0].base.Signature[0] ^= 0xFF
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.False(t, msig.Signatures[0].Status.Integrity)
+	assert.False(t, msig.Signatures[0].CounterSigns[0].Status.Integrity)
+}
+
+func Test_MultSignature_CounterSign_3(t *testing.T) {
+	// A copy of the signature would never be saved
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	cerr = msig.CounterSign(&sig, pfx)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+	assert.Equal(t, 0, len(sig.CounterSigns))
+}
+
+func Test_JoinSignatures_1(t *testing.T) {
+	msig1, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	msig2, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+	msig3, cerr := pfx.SignFile("data/hash_test.txt", false)
+	require.Nil(t, cerr)
+
+	msig, cerr := JoinSignatures(msig2, msig3, msig1)
+	require.Nil(t, cerr)
+	assert.False(t, msig.base.EncapContentInfo.IsDetached())
+	assert.Equal(t, 3, len(msig.base.Certificates))
+	assert.Equal(t, 1, len(msig.base.DigestAlgorithms))
+	require.Equal(t, 3, len(msig.Signatures))
+
+	// Save and load it again
+	dat, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(dat)
+	require.Nil(t, cerr)
+	require.Equal(t, 3, len(msig.Signatures))
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	for _, sig := range msig.Signatures {
+		assert.True(t, sig.Status.Integrity)
+		assert.Nil(t, sig.Status.SignerCertError)
+	}
+}
+
+func Test_JoinSignatures_2(t *testing.T) {
+	// Different content
+	msig1, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_detached.p7s")
+	require.Nil(t, cerr)
+	msig2, cerr := get_ciclano_pfx(t).SignBytes([]byte("Lorem Ipsum Dolor Est\n"), true)
+	require.Nil(t, cerr)
+
+	_, cerr = JoinSignatures(msig1, msig2)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_CONTENT_MISMATCH, cerr.Code())
+}
+
+func Test_JoinSignatures_3(t *testing.T) {
+	_, cerr := JoinSignatures()
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_SIGNATURES, cerr.Code())
+}
+
+func Test_JoinSignatures_4(t *testing.T) {
+	// The content file is gone
+	msig1, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	msig2, cerr := get_ciclano_pfx(t).SignFile("data/hash_test.txt", false)
+	require.Nil(t, cerr)
+	msig2.ContentFilePath = "data/does_not_exist.txt"
+
+	_, cerr = JoinSignatures(msig1, msig2)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_FILE_NOT_EXISTS, cerr.Code())
+}
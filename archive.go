@@ -0,0 +1,359 @@
+package libICP
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// OCSP and other revocation values are not supported. (they are ignored when parsing)
+type revocation_values struct {
+	RawContent asn1.RawContent
+	CRLVals    []asn1.RawValue `asn1:"explicit,tag:0,optional,omitempty"`
+}
+
+// Returns the CRLs its issuers had when each certificate in the path was checked. (see CAStore.VerifyCert)
+func path_crls(path []*Certificate) []certificate_list {
+	ans := make([]certificate_list, 0)
+	for i := 0; i < len(path)-1; i++ {
+		if len(path[i+1].crl.RawContent) > 0 {
+			ans = append(ans, path[i+1].crl)
+		}
+	}
+	return ans
+}
+
+// Adds the certificate and revocation values (CAdES-X Long) to sig, which MUST be one of this file signatures. They are the certificates in the certification path of the signer (except its own) and the CRLs the store used to check them. Together with the validation references (see AddValidationRefs) this turns the signature into an AD-RC one.
+//
+// Any previous values of sig are replaced. This is refused if sig already has an archive time stamp, as it covers the previous values.
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND, ERR_HAS_ARCHIVE_TIMESTAMP, ERR_SIGNER_NOT_FOUND and the ones from CAStore.VerifyCert
+func (msig *MultSignature) AddValidationValues(sig *Signature, store *CAStore) CodedError {
+	return msig.add_validation_values_at(sig, store, time.Now())
+}
+
+func (msig *MultSignature) add_validation_values_at(sig *Signature, store *CAStore, now time.Time) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	if _, ok := sig.get_unsigned_attr(idAaEtsArchiveTimestampV2); ok {
+		return NewMultiError("values cannot be replaced after an archive time stamp was added", ERR_HAS_ARCHIVE_TIMESTAMP, nil)
+	}
+	path, cerr := sig.signer_path_at(store, now)
+	if cerr != nil {
+		return cerr
+	}
+
+	sig.values_certs = path[1:]
+	sig.values_crls = path_crls(path)
+	cert_vals := make([]asn1.RawValue, len(sig.values_certs))
+	for i, cert := range sig.values_certs {
+		cert_vals[i] = asn1.RawValue{FullBytes: cert.base.RawContent}
+	}
+	rev_vals := revocation_values{}
+	for _, crl := range sig.values_crls {
+		rev_vals.CRLVals = append(rev_vals.CRLVals, asn1.RawValue{FullBytes: crl.RawContent})
+	}
+
+	attrs := remove_attrs_by_type(sig.base.UnsignedAttrs, idAaEtsCertValues)
+	attrs = remove_attrs_by_type(attrs, idAaEtsRevocationValues)
+	cert_attr := attribute{}
+	cert_attr.Type = idAaEtsCertValues
+	cert_attr.Values = []interface{}{cert_vals}
+	rev_attr := attribute{}
+	rev_attr.Type = idAaEtsRevocationValues
+	rev_attr.Values = []interface{}{rev_vals}
+	sig.base.UnsignedAttrs = append(attrs, cert_attr, rev_attr)
+	// Ensure it will be marshaled again (see sync_signer_infos)
+	sig.base.RawContent = nil
+	return nil
+}
+
+// Parses the certificate and revocation values from the unsigned attributes.
+func (sig *Signature) load_validation_values() CodedError {
+	sig.values_certs = make([]*Certificate, 0)
+	sig.values_crls = make([]certificate_list, 0)
+	if attr, ok := sig.get_unsigned_attr(idAaEtsCertValues); ok {
+		cert_vals := make([]asn1.RawValue, 0)
+		if err := attr.first_value(&cert_vals); err != nil {
+			merr := NewMultiError("failed to parse certificate values attribute", ERR_PARSE_SIGNATURE, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+		for _, raw := range cert_vals {
+			cert := new(Certificate)
+			cert.init()
+			if _, cerr := cert.load_from_der(raw.FullBytes); cerr != nil {
+				return cerr
+			}
+			sig.values_certs = append(sig.values_certs, cert)
+		}
+	}
+	if attr, ok := sig.get_unsigned_attr(idAaEtsRevocationValues); ok {
+		rev_vals := revocation_values{}
+		if err := attr.first_value(&rev_vals); err != nil {
+			merr := NewMultiError("failed to parse revocation values attribute", ERR_PARSE_SIGNATURE, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+		for _, raw := range rev_vals.CRLVals {
+			crl := certificate_list{}
+			if _, cerr := crl.LoadFromDER(raw.FullBytes); cerr != nil {
+				return cerr
+			}
+			sig.values_crls = append(sig.values_crls, crl)
+		}
+	}
+	return nil
+}
+
+// Returns the DER encoded elements of a SEQUENCE (ex: the fields of a struct).
+func sequence_elements(val interface{}) ([]asn1.RawValue, CodedError) {
+	raw, err := asn1.Marshal(val)
+	if err != nil {
+		return nil, NewMultiError("failed to encode sequence", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	seq := asn1.RawValue{}
+	if _, err := asn1.Unmarshal(raw, &seq); err != nil {
+		return nil, NewMultiError("failed to decode sequence", ERR_FAILED_TO_DECODE, nil, err)
+	}
+	return split_elements(seq.Bytes)
+}
+
+// Splits concatenated DER encoded elements.
+func split_elements(raw []byte) ([]asn1.RawValue, CodedError) {
+	ans := make([]asn1.RawValue, 0)
+	for len(raw) > 0 {
+		elem := asn1.RawValue{}
+		rest, err := asn1.Unmarshal(raw, &elem)
+		if err != nil {
+			return nil, NewMultiError("failed to decode element", ERR_FAILED_TO_DECODE, nil, err)
+		}
+		ans = append(ans, elem)
+		raw = rest
+	}
+	return ans, nil
+}
+
+// Writes the data covered by an archive time stamp V2 (see RFC 5126 Section 6.4.1), which is the concatenation of: the encapsulated content info, the content itself (if the signature is detached), the certificates and CRLs fields of the signed data (when present), all fields of the signer info of sig except the unsigned attributes and, at last, each unsigned attribute.
+//
+// Everything is written exactly as it is (or will be) encoded in the file. Only the first n_attrs unsigned attributes of sig (in the order they were added) are included, so each archive time stamp covers only the attributes that came before it.
+func (msig *MultSignature) write_archive_data(out io.Writer, sig *Signature, n_attrs int) CodedError {
+	msig.sync_signer_infos()
+	w := &err_writer{w: out}
+	covered := make(map[string]int)
+	for _, attr := range sig.base.UnsignedAttrs[:n_attrs] {
+		raw, err := asn1.Marshal(attr)
+		if err != nil {
+			return NewMultiError("failed to encode unsigned attribute", ERR_FAILED_TO_ENCODE, nil, err)
+		}
+		covered[string(raw)]++
+	}
+
+	// Signed data
+	elems, cerr := sequence_elements(msig.base)
+	if cerr != nil {
+		return cerr
+	}
+	for i, elem := range elems {
+		is_set := elem.Class == asn1.ClassContextSpecific && (elem.Tag == 0 || elem.Tag == 1)
+		// Version and digest algorithms come first
+		if i != 2 && !is_set {
+			continue
+		}
+		w.Write(elem.FullBytes)
+		if i == 2 && msig.base.EncapContentInfo.IsDetached() {
+			r, cerr := msig.base.EncapContentInfo.content_reader()
+			if cerr != nil {
+				return cerr
+			}
+			_, err := io.Copy(w, r)
+			r.Close()
+			if err != nil {
+				return NewMultiError("failed to read content", ERR_READ_FILE, nil, err)
+			}
+		}
+	}
+
+	// Signer info
+	elems, cerr = sequence_elements(sig.base)
+	if cerr != nil {
+		return cerr
+	}
+	for _, elem := range elems {
+		if elem.Class != asn1.ClassContextSpecific || elem.Tag != 1 {
+			w.Write(elem.FullBytes)
+			continue
+		}
+		attrs, cerr := split_elements(elem.Bytes)
+		if cerr != nil {
+			return cerr
+		}
+		// Unsigned attributes are encoded as a SEQUENCE in the order they were added, so this is also the order they had when the archive time stamp was requested
+		for _, raw_attr := range attrs {
+			key := string(raw_attr.FullBytes)
+			if covered[key] == 0 {
+				continue
+			}
+			covered[key]--
+			w.Write(raw_attr.FullBytes)
+		}
+	}
+	if w.err != nil {
+		return NewMultiError("failed to write archive time stamp data", ERR_FAILED_TO_WRITE_FILE, nil, w.err)
+	}
+	return nil
+}
+
+// Keeps the first write error, so it can be checked only once after many writes.
+type err_writer struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *err_writer) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+	n, err := ew.w.Write(p)
+	ew.err = err
+	return n, err
+}
+
+// Returns the position among the unsigned attributes of sig of the archive time stamp attribute holding ts or -1 if it is not there.
+func (sig *Signature) archive_time_stamp_attr_index(ts *TimeStamp) int {
+	for i, attr := range sig.base.UnsignedAttrs {
+		if !attr.Type.Equal(idAaEtsArchiveTimestampV2) {
+			continue
+		}
+		for _, val := range attr.Values {
+			if raw, ok := val.(asn1.RawValue); ok && bytes.Equal(raw.FullBytes, ts.raw) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the digest of the data covered by an archive time stamp, which are the first n_attrs unsigned attributes of sig and everything else. (see write_archive_data)
+func (msig *MultSignature) archive_digest(sig *Signature, alg_id algorithm_identifier, n_attrs int) ([]byte, CodedError) {
+	hasher, _, cerr := get_hasher(alg_id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if cerr := msig.write_archive_data(hasher, sig, n_attrs); cerr != nil {
+		return nil, cerr
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Adds an archive time stamp (V2) to sig, which MUST be one of this file signatures. Archive time stamps V3 (see ETSI EN 319 122-1) are not supported, neither for creation nor for verification. It covers the content, the certificates and CRLs of this file and all the attributes of sig, including any previous archive time stamps. The signature should already be an AD-RC one (see AddValidationValues), so this turns it into an AD-RA one.
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND, ERR_NO_TSA_CLIENT, ERR_NO_CONTENT, ERR_FAILED_TO_OPEN_FILE, ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_ENCODE, ERR_HTTP, ERR_TSA_REJECTED, ERR_PARSE_TIMESTAMP, ERR_SECURE_RANDOM
+func (msig *MultSignature) AddArchiveTimeStamp(sig *Signature, client *TSAClient) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	if cerr := check_tsa_client(client); cerr != nil {
+		return cerr
+	}
+	alg_id := client.hash_alg()
+	digest, cerr := msig.archive_digest(sig, alg_id, len(sig.base.UnsignedAttrs))
+	if cerr != nil {
+		return cerr
+	}
+	ts, cerr := client.request(alg_id, digest)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := sig.add_time_stamp_attr(idAaEtsArchiveTimestampV2, ts); cerr != nil {
+		return cerr
+	}
+	sig.ArchiveTimeStamps = append(sig.ArchiveTimeStamps, *ts)
+	return nil
+}
+
+// Returns the most recent (i.e. last added) archive time stamp of sig or nil if there is none.
+func (sig *Signature) last_archive_time_stamp() *TimeStamp {
+	if len(sig.ArchiveTimeStamps) == 0 {
+		return nil
+	}
+	return &sig.ArchiveTimeStamps[len(sig.ArchiveTimeStamps)-1]
+}
+
+// Adds a new archive time stamp to sig, which MUST be an AD-RA signature of this file. Before that, the certification path of the TSA of the most recent archive time stamp (and the CRLs used to check it) are added to its token, so it can still be verified after the TSA certificate expires.
+//
+// This should be done before the TSA certificate of the most recent archive time stamp expires or the algorithms it uses become weak. Set client.HashAlgorithm in order to use a stronger hash algorithm.
+//
+// Possible errors are: ERR_NO_ARCHIVE_TIMESTAMP, the ones from CAStore.VerifyCert and the ones from AddArchiveTimeStamp
+func (msig *MultSignature) Renew(sig *Signature, store *CAStore, client *TSAClient) CodedError {
+	return msig.renew_at(sig, store, client, time.Now())
+}
+
+func (msig *MultSignature) renew_at(sig *Signature, store *CAStore, client *TSAClient, now time.Time) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	if cerr := check_tsa_client(client); cerr != nil {
+		return cerr
+	}
+	last := sig.last_archive_time_stamp()
+	if last == nil {
+		return NewMultiError("signature has no archive time stamp", ERR_NO_ARCHIVE_TIMESTAMP, nil)
+	}
+	path, _, errs, _ := store.without_cert_policies().verify_cert_at(&last.TSA, now)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	// Add the validation data to the token
+	for _, cert := range path {
+		last.token.add_cert(cert)
+	}
+	for _, crl := range path_crls(path) {
+		choice := revocation_info_choice{}
+		choice.RawContent = crl.RawContent
+		choice.CRL = crl
+		last.token.add_crl(choice)
+	}
+	last.token.base.RawContent = nil
+	raw, cerr := last.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	// Replace the old token
+	for i, attr := range sig.base.UnsignedAttrs {
+		if !attr.Type.Equal(idAaEtsArchiveTimestampV2) {
+			continue
+		}
+		for j, val := range attr.Values {
+			if old, ok := val.(asn1.RawValue); ok && string(old.FullBytes) == string(last.raw) {
+				sig.base.UnsignedAttrs[i].RawContent = nil
+				sig.base.UnsignedAttrs[i].Values[j] = asn1.RawValue{FullBytes: raw}
+			}
+		}
+	}
+	last.raw = raw
+	sig.base.RawContent = nil
+
+	return msig.AddArchiveTimeStamp(sig, client)
+}
+
+// Verifies the archive time stamps of sig. Each one covers the unsigned attributes added before it, including the previous archive time stamps, so each one proves the former existed when it was generated. Like any time stamp, each one is verified at its own generation time (see check_digest_at).
+func (msig *MultSignature) check_archive_time_stamps_at(sig *Signature, store *CAStore, now time.Time) {
+	for i := range sig.ArchiveTimeStamps {
+		ts := &sig.ArchiveTimeStamps[i]
+		var digest []byte
+		var cerr CodedError
+		if n_attrs := sig.archive_time_stamp_attr_index(ts); n_attrs >= 0 {
+			digest, cerr = msig.archive_digest(sig, ts.info.MessageImprint.HashAlgorithm, n_attrs)
+		} else {
+			cerr = NewMultiError("archive time stamp not found among the unsigned attributes", ERR_NO_ARCHIVE_TIMESTAMP, nil)
+		}
+		ts.check_digest_at(store, digest, now)
+		ts.Status.ImprintError = cerr
+	}
+}
@@ -0,0 +1,214 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns an AD-RA signature (with a single archive time stamp) made at 2026-01-01.
+func get_test_adra(t *testing.T, tsa *test_tsa, content []byte, attached bool) *MultSignature {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytesWithPolicy(content, attached, get_test_policy(t))
+	require.Nil(t, cerr)
+	sig := &msig.Signatures[0]
+	store := get_test_store_with_crl(t)
+	require.Nil(t, msig.AddTimeStamp(sig, tsa.client()))
+	require.Nil(t, msig.add_validation_refs_at(sig, store, tsa.client(), tsa.now))
+	require.Nil(t, msig.add_validation_values_at(sig, store, tsa.now))
+	require.Nil(t, msig.AddArchiveTimeStamp(sig, tsa.client()))
+	return msig
+}
+
+func Test_MultSignature_AddArchiveTimeStamp_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	require.Equal(t, 1, len(msig.Signatures[0].ArchiveTimeStamps))
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	require.Equal(t, 1, len(sig.ArchiveTimeStamps))
+	assert.Equal(t, 2, len(sig.values_certs))
+	assert.Equal(t, 1, len(sig.values_crls))
+
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	sig = msig.Signatures[0]
+	assert.True(t, sig.Status.Integrity)
+	assert.True(t, sig.Status.AreRefsValid(), "%v", sig.Status.RefsErrors)
+	assert.True(t, sig.TimeStamps[0].Status.IsValid())
+	assert.True(t, sig.RefsTimeStamps[0].Status.IsValid())
+	assert.True(t, sig.ArchiveTimeStamps[0].Status.IsValid())
+}
+
+func Test_MultSignature_AddArchiveTimeStamp_2(t *testing.T) {
+	// Detached signature whose content was changed
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), false)
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	msig.base.EncapContentInfo.fallback_content = []byte("The quick fox jumps over the lazy dog.")
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig.Signatures[0].ArchiveTimeStamps[0].Status.IsValid())
+
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	msig.base.EncapContentInfo.fallback_content = []byte("The quick fox jumps over the lazy cat.")
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.False(t, msig.Signatures[0].Status.Integrity)
+	assert.True(t, msig.Signatures[0].ArchiveTimeStamps[0].Status.Integrity)
+	assert.False(t, msig.Signatures[0].ArchiveTimeStamps[0].Status.ImprintMatches)
+	assert.Nil(t, msig.Signatures[0].ArchiveTimeStamps[0].Status.ImprintError)
+
+	// The archived data cannot be hashed
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	msig.base.EncapContentInfo.fallback_content = []byte("The quick fox jumps over the lazy dog.")
+	msig.Signatures[0].ArchiveTimeStamps[0].info.MessageImprint.HashAlgorithm.Algorithm = idMGF1
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].ArchiveTimeStamps[0].Status
+	assert.False(t, status.IsValid())
+	assert.NotNil(t, status.ImprintError)
+}
+
+func Test_MultSignature_AddArchiveTimeStamp_3(t *testing.T) {
+	// Both archive time stamps have the same generation time
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	require.Nil(t, msig.AddArchiveTimeStamp(&msig.Signatures[0], tsa.client()))
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	require.Equal(t, 2, len(sig.ArchiveTimeStamps))
+	require.True(t, sig.ArchiveTimeStamps[0].GenTime.Equal(sig.ArchiveTimeStamps[1].GenTime))
+
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	for _, ts := range msig.Signatures[0].ArchiveTimeStamps {
+		assert.True(t, ts.Status.IsValid(), "%v", ts.Status)
+	}
+}
+
+func Test_MultSignature_AddArchiveTimeStamp_4(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+
+	// A copy of the signature
+	sig := msig.Signatures[0]
+	cerr := msig.AddArchiveTimeStamp(&sig, tsa.client())
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+
+	// No client
+	cerr = msig.AddArchiveTimeStamp(&msig.Signatures[0], nil)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_TSA_CLIENT, cerr.Code())
+	assert.Equal(t, 1, len(msig.Signatures[0].ArchiveTimeStamps))
+}
+
+func Test_MultSignature_AddValidationValues_1(t *testing.T) {
+	// The certificate and revocation values supply what the store lacks
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+
+	cerr = msig.check_all_at(get_test_store(t, false), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	assert.Nil(t, sig.Status.SignerCertError)
+	assert.True(t, sig.Status.AreRefsValid(), "%v", sig.Status.RefsErrors)
+}
+
+func Test_MultSignature_AddValidationValues_2(t *testing.T) {
+	// A copy of the signature
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	sig := msig.Signatures[0]
+	cerr := msig.add_validation_values_at(&sig, get_test_store_with_crl(t), tsa.now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+}
+
+func Test_MultSignature_AddValidationValues_3(t *testing.T) {
+	// The archive time stamp covers the values
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	cerr := msig.add_validation_values_at(&msig.Signatures[0], get_test_store_with_crl(t), tsa.now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_HAS_ARCHIVE_TIMESTAMP, cerr.Code())
+}
+
+func Test_MultSignature_Renew_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	sig := &msig.Signatures[0]
+	first_len := len(sig.ArchiveTimeStamps[0].token.certs)
+
+	// One year later, with a stronger hash algorithm
+	tsa.now = tsa.now.AddDate(1, 0, 0)
+	client := tsa.client()
+	client.HashAlgorithm = idSha512.String()
+	require.Nil(t, msig.renew_at(sig, get_test_store_with_crl(t), client, tsa.now))
+	require.Equal(t, 2, len(sig.ArchiveTimeStamps))
+	assert.True(t, len(sig.ArchiveTimeStamps[0].token.certs) > first_len)
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	require.Equal(t, 2, len(msig.Signatures[0].ArchiveTimeStamps))
+
+	cerr = msig.check_all_at(get_test_store_with_crl(t), time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	for _, ts := range msig.Signatures[0].ArchiveTimeStamps {
+		assert.True(t, ts.Status.IsValid(), "%v", ts.Status.TSACertError)
+		if ts.GenTime.Equal(tsa.now) {
+			assert.Equal(t, idSha512, ts.info.MessageImprint.HashAlgorithm.Algorithm)
+		}
+	}
+}
+
+func Test_MultSignature_Renew_2(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	cerr := msig.Renew(&msig.Signatures[0], get_test_store(t, true), tsa.client())
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_ARCHIVE_TIMESTAMP, cerr.Code())
+}
+
+func Test_MultSignature_Renew_3(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	first_len := len(msig.Signatures[0].ArchiveTimeStamps[0].token.certs)
+
+	// A copy of the signature
+	sig := msig.Signatures[0]
+	cerr := msig.renew_at(&sig, get_test_store_with_crl(t), tsa.client(), tsa.now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+
+	// No client
+	cerr = msig.renew_at(&msig.Signatures[0], get_test_store_with_crl(t), nil, tsa.now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_TSA_CLIENT, cerr.Code())
+	assert.Equal(t, 1, len(msig.Signatures[0].ArchiveTimeStamps))
+	assert.Equal(t, first_len, len(msig.Signatures[0].ArchiveTimeStamps[0].token.certs))
+}
@@ -1,7 +1,11 @@
 package libICP
 
 import (
+	"errors"
 	"math/big"
+	"net"
+	"strconv"
+	"unicode/utf16"
 
 	"github.com/OpenICP-BR/asn1"
 )
@@ -12,6 +16,56 @@ type attribute struct {
 	Values     []interface{} `asn1:"set"`
 }
 
+type attribute_decode struct {
+	RawContent asn1.RawContent
+	Type       asn1.ObjectIdentifier
+	Values     []asn1.RawValue `asn1:"set"`
+}
+
+// Converts to an attribute whose values are kept as raw values, so they will be marshaled exactly as they were read.
+func (attr attribute_decode) to_attribute() attribute {
+	ans := attribute{}
+	ans.RawContent = attr.RawContent
+	ans.Type = attr.Type
+	ans.Values = make([]interface{}, len(attr.Values))
+	for i, val := range attr.Values {
+		ans.Values[i] = val
+	}
+	return ans
+}
+
+// Decodes the first value of this attribute into out. Works both for decoded attributes (whose values are asn1.RawValue) and for the ones we built.
+func (attr attribute) first_value(out interface{}) error {
+	if len(attr.Values) == 0 {
+		return errors.New("attribute has no values")
+	}
+	raw, ok := attr.Values[0].(asn1.RawValue)
+	if !ok {
+		var err error
+		raw.FullBytes, err = asn1.Marshal(attr.Values[0])
+		if err != nil {
+			return err
+		}
+	}
+	_, err := asn1.Unmarshal(raw.FullBytes, out)
+	return err
+}
+
+// See RFC 5126 Section 5.11.1
+type commitment_type_indication struct {
+	RawContent               asn1.RawContent
+	CommitmentTypeId         asn1.ObjectIdentifier
+	CommitmentTypeQualifiers []asn1.RawValue `asn1:"optional,omitempty"`
+}
+
+// See RFC 5126 Section 5.11.2
+type signer_location struct {
+	RawContent    asn1.RawContent
+	CountryName   string   `asn1:"tag:0,explicit,optional,omitempty"`
+	LocalityName  string   `asn1:"tag:1,explicit,optional,omitempty"`
+	PostalAddress []string `asn1:"tag:2,explicit,optional,omitempty"`
+}
+
 type extension struct {
 	ExtnID    asn1.ObjectIdentifier
 	Critical  bool `asn1:"optional"`
@@ -105,6 +159,33 @@ func (ans *ext_key_usage) FromExtension(ext extension) CodedError {
 	return nil
 }
 
+type ext_extended_key_usage struct {
+	Exists   bool
+	Purposes []asn1.ObjectIdentifier
+}
+
+func (ans *ext_extended_key_usage) FromExtension(ext extension) CodedError {
+	purposes := []asn1.ObjectIdentifier{}
+	_, err := asn1.Unmarshal(ext.ExtnValue, &purposes)
+	if err != nil {
+		merr := NewMultiError("failed to parse extended key usage extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	ans.Exists = true
+	ans.Purposes = purposes
+	return nil
+}
+
+func (ans ext_extended_key_usage) Has(purpose asn1.ObjectIdentifier) bool {
+	for _, item := range ans.Purposes {
+		if item.Equal(purpose) {
+			return true
+		}
+	}
+	return false
+}
+
 type ext_basic_constraints struct {
 	Exists  bool
 	CA      bool
@@ -113,8 +194,8 @@ type ext_basic_constraints struct {
 
 // I had to created this struct because github.com/gjvnq/asn1 does can't ignore fields with `asn1:"-"`
 type ext_basic_constraints_raw struct {
-	CA      bool
-	PathLen int `asn1:"optional"`
+	CA      bool `asn1:"optional"`
+	PathLen int  `asn1:"optional"`
 }
 
 func (ans *ext_basic_constraints) FromExtension(ext extension) CodedError {
@@ -201,3 +282,294 @@ func (ans *ext_subject_key_id) FromExtension(ext extension) CodedError {
 	ans.Exists = true
 	return nil
 }
+
+type ext_subject_alt_name struct {
+	Exists bool
+	general_names
+	OtherNames []another_name
+}
+
+func (ans *ext_subject_alt_name) FromExtension(ext extension) CodedError {
+	// GeneralName is a CHOICE, so each item must be decoded according to its tag
+	raw := []asn1.RawValue{}
+	_, err := asn1.Unmarshal(ext.ExtnValue, &raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse subject alternative name extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	for _, item := range raw {
+		if item.Class != asn1.ClassContextSpecific {
+			continue
+		}
+		if err := ans.append_general_name(item); err != nil {
+			merr := NewMultiError("failed to parse subject alternative name extention", ERR_PARSE_EXTENSION, nil, err)
+			merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+			return merr
+		}
+		if item.Tag != 0 {
+			continue
+		}
+		other := another_name{}
+		if _, err := asn1.UnmarshalWithParams(item.FullBytes, &other, "tag:0"); err != nil {
+			merr := NewMultiError("failed to parse other name in subject alternative name extention", ERR_PARSE_EXTENSION, nil, err)
+			merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+			return merr
+		}
+		ans.OtherNames = append(ans.OtherNames, other)
+	}
+	ans.Exists = true
+	return nil
+}
+
+// A policy found in the certificate policies extension. (see RFC 5280 Section 4.2.1.4)
+type CertificatePolicy struct {
+	// OID of the policy (ex: "2.16.76.1.2.1.51")
+	ID          string
+	CPSURIs     []string
+	UserNotices []UserNotice
+}
+
+// Text to be displayed to the relying party. (see RFC 5280 Section 4.2.1.4)
+type UserNotice struct {
+	Organization  string
+	NoticeNumbers []int
+	ExplicitText  string
+}
+
+type policy_information struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	PolicyQualifiers []policy_qualifier_info `asn1:"optional"`
+}
+
+type policy_qualifier_info struct {
+	PolicyQualifierId asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+type notice_reference struct {
+	Organization  asn1.RawValue
+	NoticeNumbers []int
+}
+
+type ext_certificate_policies struct {
+	Exists   bool
+	Policies []CertificatePolicy
+}
+
+func (ans *ext_certificate_policies) FromExtension(ext extension) CodedError {
+	raw := []policy_information{}
+	_, err := asn1.Unmarshal(ext.ExtnValue, &raw)
+	if err != nil {
+		merr := NewMultiError("failed to parse certificate policies extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	for _, info := range raw {
+		policy := CertificatePolicy{ID: info.PolicyIdentifier.String()}
+		for _, qualifier := range info.PolicyQualifiers {
+			switch {
+			case qualifier.PolicyQualifierId.Equal(idQtCPS):
+				policy.CPSURIs = append(policy.CPSURIs, string(qualifier.Qualifier.Bytes))
+			case qualifier.PolicyQualifierId.Equal(idQtUnotice):
+				notice, err := parse_user_notice(qualifier.Qualifier)
+				if err != nil {
+					merr := NewMultiError("failed to parse user notice in certificate policies extention", ERR_PARSE_EXTENSION, nil, err)
+					merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+					return merr
+				}
+				policy.UserNotices = append(policy.UserNotices, notice)
+			}
+		}
+		ans.Policies = append(ans.Policies, policy)
+	}
+	ans.Exists = true
+	return nil
+}
+
+// Both fields of an user notice are optional, so they are told apart by their tags: the notice reference is a SEQUENCE while the explicit text is a string.
+func parse_user_notice(raw asn1.RawValue) (UserNotice, error) {
+	ans := UserNotice{}
+	items := []asn1.RawValue{}
+	if _, err := asn1.Unmarshal(raw.FullBytes, &items); err != nil {
+		return ans, err
+	}
+	for _, item := range items {
+		if item.Class == asn1.ClassUniversal && item.Tag == asn1.TagSequence {
+			ref := notice_reference{}
+			if _, err := asn1.Unmarshal(item.FullBytes, &ref); err != nil {
+				return ans, err
+			}
+			ans.Organization = display_text(ref.Organization)
+			ans.NoticeNumbers = ref.NoticeNumbers
+		} else {
+			ans.ExplicitText = display_text(item)
+		}
+	}
+	return ans, nil
+}
+
+// Not all versions of the asn1 package define it.
+const tag_bmp_string = 30
+
+// Decodes an IA5String, VisibleString, BMPString or UTF8String.
+func display_text(raw asn1.RawValue) string {
+	if raw.Tag == tag_bmp_string {
+		seq := make([]uint16, len(raw.Bytes)/2)
+		for i := range seq {
+			seq[i] = uint16(raw.Bytes[2*i])<<8 | uint16(raw.Bytes[2*i+1])
+		}
+		return string(utf16.Decode(seq))
+	}
+	return string(raw.Bytes)
+}
+
+type policy_mapping struct {
+	IssuerDomainPolicy  asn1.ObjectIdentifier
+	SubjectDomainPolicy asn1.ObjectIdentifier
+}
+
+type ext_policy_mappings struct {
+	Exists   bool
+	Mappings []policy_mapping
+}
+
+func (ans *ext_policy_mappings) FromExtension(ext extension) CodedError {
+	_, err := asn1.Unmarshal(ext.ExtnValue, &ans.Mappings)
+	if err != nil {
+		merr := NewMultiError("failed to parse policy mappings extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	ans.Exists = true
+	return nil
+}
+
+// Absent fields are set to -1.
+type ext_policy_constraints struct {
+	Exists                bool
+	RequireExplicitPolicy int
+	InhibitPolicyMapping  int
+}
+
+func (ans *ext_policy_constraints) FromExtension(ext extension) CodedError {
+	fail := func(err error) CodedError {
+		merr := NewMultiError("failed to parse policy constraints extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	// Both fields are optional integers, so they can only be told apart by their tags
+	raw := []asn1.RawValue{}
+	_, err := asn1.Unmarshal(ext.ExtnValue, &raw)
+	if err != nil {
+		return fail(err)
+	}
+	ans.RequireExplicitPolicy = -1
+	ans.InhibitPolicyMapping = -1
+	for _, item := range raw {
+		if item.Class != asn1.ClassContextSpecific || item.Tag > 1 {
+			continue
+		}
+		value := 0
+		if _, err := asn1.UnmarshalWithParams(item.FullBytes, &value, "tag:"+strconv.Itoa(item.Tag)); err != nil {
+			return fail(err)
+		}
+		if item.Tag == 0 {
+			ans.RequireExplicitPolicy = value
+		} else {
+			ans.InhibitPolicyMapping = value
+		}
+	}
+	ans.Exists = true
+	return nil
+}
+
+type ext_inhibit_any_policy struct {
+	Exists    bool
+	SkipCerts int
+}
+
+func (ans *ext_inhibit_any_policy) FromExtension(ext extension) CodedError {
+	_, err := asn1.Unmarshal(ext.ExtnValue, &ans.SkipCerts)
+	if err != nil {
+		merr := NewMultiError("failed to parse inhibit any policy extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	ans.Exists = true
+	return nil
+}
+
+// Only the supported name forms are kept, but the tags of the unsupported ones are recorded so names of those forms can be rejected. IP address ranges have the address and the mask (see RFC 5280 Section 4.2.1.10)
+type name_subtrees struct {
+	general_names
+	IPRanges []net.IPNet
+}
+
+// The minimum and maximum fields are not used in the internet PKI profile.
+type general_subtree struct {
+	Base    asn1.RawValue
+	Minimum int `asn1:"optional,tag:0"`
+	Maximum int `asn1:"optional,tag:1"`
+}
+
+// Parses the content of a GeneralSubtrees.
+func (ans *name_subtrees) from_raw(raw []byte) error {
+	for len(raw) > 0 {
+		subtree := general_subtree{}
+		rest, err := asn1.Unmarshal(raw, &subtree)
+		if err != nil {
+			return err
+		}
+		raw = rest
+		base := subtree.Base
+		if base.Class != asn1.ClassContextSpecific || base.Tag != 7 {
+			if err := ans.append_general_name(base); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(base.Bytes) != 2*net.IPv4len && len(base.Bytes) != 2*net.IPv6len {
+			return errors.New("invalid IP address range in name constraint")
+		}
+		size := len(base.Bytes) / 2
+		ans.IPRanges = append(ans.IPRanges, net.IPNet{IP: net.IP(base.Bytes[:size]), Mask: net.IPMask(base.Bytes[size:])})
+	}
+	return nil
+}
+
+type ext_name_constraints struct {
+	Exists    bool
+	Permitted name_subtrees
+	Excluded  name_subtrees
+}
+
+func (ans *ext_name_constraints) FromExtension(ext extension) CodedError {
+	fail := func(err error) CodedError {
+		merr := NewMultiError("failed to parse name constraints extention", ERR_PARSE_EXTENSION, nil, err)
+		merr.SetParam("raw-ExtnValue", ext.ExtnValue)
+		return merr
+	}
+	// Both fields are optional, so they can only be told apart by their tags
+	raw := []asn1.RawValue{}
+	_, err := asn1.Unmarshal(ext.ExtnValue, &raw)
+	if err != nil {
+		return fail(err)
+	}
+	for _, item := range raw {
+		if item.Class != asn1.ClassContextSpecific {
+			continue
+		}
+		switch item.Tag {
+		case 0:
+			err = ans.Permitted.from_raw(item.Bytes)
+		case 1:
+			err = ans.Excluded.from_raw(item.Bytes)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	ans.Exists = true
+	return nil
+}
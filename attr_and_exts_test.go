@@ -109,3 +109,156 @@ func Test_ExtSubjectKeyId_FromExtension_2(t *testing.T) {
 	expected := []byte{0x69, 0xA8, 0xBE, 0x75, 0xD9, 0xC4, 0xEF, 0x6C, 0xE7, 0x13, 0x45, 0xE4, 0x61, 0x6E, 0xE5, 0x68, 0xF8, 0xB6, 0x40, 0x5E}
 	assert.Equal(t, expected, ext.KeyId)
 }
+
+func Test_ExtExtendedKeyUsage_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	ext := ext_extended_key_usage{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtExtendedKeyUsage_FromExtension_2(t *testing.T) {
+	raw_ext := extension{}
+	raw_ext.ExtnValue = []byte{0x30, 0x0A, 0x06, 0x08, 0x2B, 0x06, 0x01, 0x05, 0x05, 0x07, 0x03, 0x08}
+	ext := ext_extended_key_usage{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.True(t, ext.Has(idKpTimeStamping))
+	assert.False(t, ext.Has(idCeExtKeyUsage))
+}
+
+func Test_ExtSubjectAltName_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	ext := ext_subject_alt_name{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtSubjectAltName_FromExtension_2(t *testing.T) {
+	raw_ext := extension{}
+	// DNS name, email and an other name with a PrintableString
+	raw_ext.ExtnValue = []byte{0x30, 0x22, 0x82, 0x01, 'a', 0x81, 0x03, 'b', '@', 'c', 0xA0, 0x18, 0x06, 0x05, 0x60, 0x4C, 0x01, 0x03, 0x03, 0xA0, 0x0F, 0x13, 0x0D, '1', '1', '2', '2', '2', '3', '3', '3', '0', '0', '0', '1', '8'}
+	ext := ext_subject_alt_name{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.Equal(t, []string{"b@c"}, ext.Emails)
+	assert.Equal(t, []string{"a"}, ext.DNSNames)
+	assert.Equal(t, []int{0}, ext.UnsupportedForms)
+	require.Len(t, ext.OtherNames, 1)
+	assert.Equal(t, idIcpBrasilCNPJ, ext.OtherNames[0].TypeId)
+	assert.Equal(t, "1122233300018", other_name_string(ext.OtherNames[0].Value))
+}
+
+func Test_ExtCertificatePolicies_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	ext := ext_certificate_policies{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtCertificatePolicies_FromExtension_2(t *testing.T) {
+	raw_ext := extension{}
+	// anyPolicy with an user notice that only has an explicit text (as BMPString)
+	raw_ext.ExtnValue = []byte{0x30, 0x1E, 0x30, 0x1C, 0x06, 0x04, 0x55, 0x1D, 0x20, 0x00, 0x30, 0x14, 0x30, 0x12, 0x06, 0x08, 0x2B, 0x06, 0x01, 0x05, 0x05, 0x07, 0x02, 0x02, 0x30, 0x06, 0x1E, 0x04, 0x00, 'O', 0x00, 'i'}
+	ext := ext_certificate_policies{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.Equal(t, []CertificatePolicy{{ID: "2.5.29.32.0", UserNotices: []UserNotice{{ExplicitText: "Oi"}}}}, ext.Policies)
+}
+
+func Test_ExtPolicyConstraints_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	ext := ext_policy_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtPolicyConstraints_FromExtension_2(t *testing.T) {
+	raw_ext := extension{}
+	raw_ext.ExtnValue = []byte{0x30, 0x06, 0x80, 0x01, 0x00, 0x81, 0x01, 0x02}
+	ext := ext_policy_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.Equal(t, 0, ext.RequireExplicitPolicy)
+	assert.Equal(t, 2, ext.InhibitPolicyMapping)
+
+	raw_ext.ExtnValue = []byte{0x30, 0x03, 0x81, 0x01, 0x01}
+	err = ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.Equal(t, -1, ext.RequireExplicitPolicy)
+	assert.Equal(t, 1, ext.InhibitPolicyMapping)
+}
+
+func Test_ExtPolicyMappings_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	raw_ext.ExtnValue = []byte{0x30, 0x0C, 0x30, 0x0A, 0x06, 0x03, 0x2A, 0x03, 0x04, 0x06, 0x03, 0x2A, 0x03, 0x05}
+	ext := ext_policy_mappings{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	require.Len(t, ext.Mappings, 1)
+	assert.Equal(t, "1.2.3.4", ext.Mappings[0].IssuerDomainPolicy.String())
+	assert.Equal(t, "1.2.3.5", ext.Mappings[0].SubjectDomainPolicy.String())
+}
+
+func Test_ExtInhibitAnyPolicy_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	raw_ext.ExtnValue = []byte{0x02, 0x01, 0x01}
+	ext := ext_inhibit_any_policy{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.Equal(t, 1, ext.SkipCerts)
+}
+
+func Test_ExtNameConstraints_FromExtension_1(t *testing.T) {
+	raw_ext := extension{}
+	ext := ext_name_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtNameConstraints_FromExtension_2(t *testing.T) {
+	raw_ext := extension{}
+	// Permitted: DNS name "b.c" and URI ".de"; excluded: IP range 10.0.0.0/8
+	raw_ext.ExtnValue = []byte{0x30, 0x1E, 0xA0, 0x0E, 0x30, 0x05, 0x82, 0x03, 'b', '.', 'c', 0x30, 0x05, 0x86, 0x03, '.', 'd', 'e', 0xA1, 0x0C, 0x30, 0x0A, 0x87, 0x08, 10, 0, 0, 0, 255, 0, 0, 0}
+	ext := ext_name_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.True(t, ext.Exists)
+	assert.Equal(t, []string{"b.c"}, ext.Permitted.DNSNames)
+	assert.Equal(t, []string{".de"}, ext.Permitted.URIs)
+	assert.Empty(t, ext.Permitted.IPRanges)
+	require.Len(t, ext.Excluded.IPRanges, 1)
+	assert.Equal(t, "10.0.0.0/8", ext.Excluded.IPRanges[0].String())
+}
+
+func Test_ExtNameConstraints_FromExtension_3(t *testing.T) {
+	raw_ext := extension{}
+	// The IP range has 6 bytes
+	raw_ext.ExtnValue = []byte{0x30, 0x1C, 0xA0, 0x0E, 0x30, 0x05, 0x82, 0x03, 'b', '.', 'c', 0x30, 0x05, 0x86, 0x03, '.', 'd', 'e', 0xA1, 0x0A, 0x30, 0x08, 0x87, 0x06, 10, 0, 0, 255, 0, 0}
+	ext := ext_name_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_PARSE_EXTENSION, err.Code())
+}
+
+func Test_ExtNameConstraints_FromExtension_4(t *testing.T) {
+	raw_ext := extension{}
+	// Excluded: registered ID 1.2
+	raw_ext.ExtnValue = []byte{0x30, 0x07, 0xA1, 0x05, 0x30, 0x03, 0x88, 0x01, 0x2A}
+	ext := ext_name_constraints{}
+	err := ext.FromExtension(raw_ext)
+	require.Nil(t, err)
+	assert.Equal(t, []int{8}, ext.Excluded.UnsupportedForms)
+	assert.Empty(t, ext.Permitted.UnsupportedForms)
+}
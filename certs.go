@@ -8,7 +8,7 @@ import (
 
 type certificate_choice struct {
 	RawContent          asn1.RawContent
-	Certificate         Certificate              `asn1:"optional,omitempty"`
+	Certificate         certificate_pack         `asn1:"optional,omitempty"`
 	ExtendedCertificate extended_certificate     `asn1:"tag:0,optional,omitempty"`
 	V1AttrCert          attribute_certificate_v1 `asn1:"tag:1,optional,omitempty"`
 	V2AttrCert          attribute_certificate_v2 `asn1:"tag:2,optional,omitempty"`
@@ -117,6 +117,24 @@ func (cert certificate_pack) GetBytesToSign() []byte {
 	return []byte(cert.TBSCertificate.RawContent)
 }
 
+// Used by CMS to identify a certificate. The issuer is kept as a raw value so it can be compared (and re-encoded) byte by byte.
+type issuer_and_serial_number struct {
+	RawContent   asn1.RawContent
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// Only used to get the raw bytes of the issuer and subject names.
+type tbs_certificate_names_decode struct {
+	RawContent   asn1.RawContent
+	Version      int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber *big.Int
+	Signature    algorithm_identifier_decode
+	Issuer       asn1.RawValue
+	Validity     asn1.RawValue
+	Subject      asn1.RawValue
+}
+
 type issuer_and_serial struct {
 	RawContent asn1.RawContent
 	Issuer     []general_name
@@ -47,6 +47,14 @@ type tbs_cert_list struct {
 	CRLExtensions       []extension           `asn1:"optional,omitempty,tag:0,explicit"`
 }
 
+// Used to get the raw issuer name.
+type tbs_cert_list_names_decode struct {
+	RawContent asn1.RawContent
+	Version    int `asn1:"optional"`
+	Signature  algorithm_identifier_decode
+	Issuer     asn1.RawValue
+}
+
 type revoked_certificate struct {
 	UserCertificate    *big.Int
 	RevocationDate     time.Time
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/OpenICP-BR/libICP"
+	"github.com/mkideal/cli"
+)
+
+type joinSigsT struct {
+	cli.Helper
+	Output  string `cli:"o,output" usage:"path to write the joined signature" dft:"joined.p7s"`
+	Content string `cli:"c,content" usage:"path to the signed content (needed only if all signatures are detached and you want to check them)"`
+}
+
+func JoinFunc(ctx *cli.Context) error {
+	argv := ctx.Argv().(*joinSigsT)
+	paths := ctx.Args()
+	if len(paths) < 2 {
+		return errors.New("at least two signature files are needed")
+	}
+
+	// Load signatures
+	sigs := make([]*libICP.MultSignature, len(paths))
+	for i, path := range paths {
+		msig, cerr := libICP.NewMultSignatureFromFile(path)
+		if cerr != nil {
+			return cerr
+		}
+		if argv.Content != "" {
+			if cerr := msig.SetContentFile(argv.Content); cerr != nil {
+				return cerr
+			}
+		}
+		sigs[i] = msig
+	}
+
+	// Join them
+	msig, cerr := libICP.JoinSignatures(sigs...)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := msig.SaveToFile(argv.Output); cerr != nil {
+		return cerr
+	}
+
+	for _, sig := range msig.Signatures {
+		fmt.Printf("Signer:  %s\n", sig.Signer.Subject)
+	}
+	fmt.Printf("%s[DONE] %d signatures written to file: %s%s\n", FgGreen+Bold, len(msig.Signatures), Reset, argv.Output)
+
+	return nil
+}
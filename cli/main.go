@@ -47,22 +47,31 @@ var sign = &cli.Command{
 	},
 }
 
-type joinSigsT struct {
-	cli.Helper
-	Name string `cli:"name" usage:"your name"`
-}
-
 var joinSigs = &cli.Command{
 	Name: "join",
 	Desc: "Join multiple signature files into one",
+	Text: "Usage: join -o [output file] [signature files...]",
 	Argv: func() interface{} { return new(joinSigsT) },
+	Fn:   JoinFunc,
+}
+
+var tsaCmd = &cli.Command{
+	Name: "tsa",
+	Desc: "Time Stamp Authority (RFC 3161) commands",
 	Fn: func(ctx *cli.Context) error {
-		argv := ctx.Argv().(*joinSigsT)
-		ctx.String("Hello, verify command, I am %s\n", argv.Name)
+		help.Run(nil)
 		return nil
 	},
 }
 
+var tsaServe = &cli.Command{
+	Name: "serve",
+	Desc: "Runs a Time Stamp Authority (only for testing and staging environments)",
+	Text: "Usage: tsa serve -p [tsa.p12] -w [password] --policy [OID] -l [address]",
+	Argv: func() interface{} { return new(tsaServeT) },
+	Fn:   TSAServeFunc,
+}
+
 type verifyT struct {
 	cli.Helper
 	Name string `cli:"name" usage:"your name"`
@@ -93,6 +102,9 @@ func main() {
 		cli.Tree(sign),
 		cli.Tree(verify),
 		cli.Tree(joinSigs),
+		cli.Tree(tsaCmd,
+			cli.Tree(tsaServe),
+		),
 	)
 	clix.InstallBashCompletion(root)
 	if err := root.Run(os.Args[1:]); err != nil {
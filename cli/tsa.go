@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/OpenICP-BR/libICP"
+	"github.com/OpenICP-BR/libICP/tsa"
+	"github.com/mkideal/cli"
+	clix "github.com/mkideal/cli/ext"
+)
+
+type tsaServeT struct {
+	cli.Helper
+	PFX        string        `cli:"p,pfx" usage:"path to the TSA certificate and private key (.p12 or .pfx)"`
+	Password   string        `cli:"w,password" usage:"password of the PFX file"`
+	Policy     string        `cli:"policy" usage:"OID of the TSA policy"`
+	Accuracy   clix.Duration `cli:"a,accuracy" usage:"accuracy of the server clock (ex: 1s, 500ms)"`
+	SerialFile string        `cli:"s,serial-file" usage:"path to the file that keeps the last serial number" dft:"tsa-serial.txt"`
+	Listen     string        `cli:"l,listen" usage:"address to listen on" dft:":3161"`
+}
+
+func TSAServeFunc(ctx *cli.Context) error {
+	argv := ctx.Argv().(*tsaServeT)
+	if argv.PFX == "" || argv.Policy == "" {
+		return errors.New("both --pfx and --policy are required")
+	}
+
+	pfx, cerr := libICP.NewPFXFromFile(argv.PFX, argv.Password)
+	if cerr != nil {
+		return cerr
+	}
+	srv, cerr := tsa.NewServer(pfx, argv.Policy, argv.SerialFile)
+	if cerr != nil {
+		return cerr
+	}
+	srv.Accuracy = argv.Accuracy.Duration
+
+	fmt.Printf("%sTSA:%s     %s\n", Bold, Reset, pfx.Cert.Subject)
+	fmt.Printf("%sPolicy:%s  %s\n", Bold, Reset, argv.Policy)
+	fmt.Printf("%s[READY] Listening on %s%s\n", FgGreen+Bold, argv.Listen, Reset)
+	return srv.ListenAndServe(argv.Listen)
+}
@@ -1,6 +1,10 @@
 package libICP
 
 import (
+	"bytes"
+	"hash"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -10,9 +14,11 @@ import (
 type encapsulated_content_info struct {
 	RawContent    asn1.RawContent
 	EContentType  asn1.ObjectIdentifier
-	EContent      []byte `asn1:"explicit,optional,omitempty"`
+	EContent      []byte `asn1:"tag:0,explicit,optional,omitempty"`
 	fallback_file string
-	hashes        map[string][]byte
+	// Used for detached signatures of content that is only available in memory
+	fallback_content []byte
+	hashes           map[string][]byte
 }
 
 func (ec *encapsulated_content_info) SetFallbackFile(path string) CodedError {
@@ -29,6 +35,7 @@ func (ec *encapsulated_content_info) SetFallbackFile(path string) CodedError {
 		return merr
 	}
 	ec.fallback_file = abs_path
+	ec.hashes = nil
 	return nil
 }
 
@@ -36,44 +43,88 @@ func (ec encapsulated_content_info) IsDetached() bool {
 	return ec.EContent == nil
 }
 
-// Return true if EContent is not nil or if fallback_file exists
+// Return true if EContent is not nil, if fallback_file or fallback_content exists or if the content digests were calculated while streaming
 func (ec encapsulated_content_info) IsHashable() bool {
-	return ec.EContent != nil || ec.fallback_file != ""
+	return ec.EContent != nil || ec.fallback_file != "" || ec.fallback_content != nil || len(ec.hashes) > 0
 }
 
 func (ec *encapsulated_content_info) HashAs(alg_id algorithm_identifier) ([]byte, CodedError) {
-	if ec.hashes == nil {
-		ec.hashes = make(map[string][]byte)
+	if cerr := ec.hash_all([]algorithm_identifier{alg_id}); cerr != nil {
+		return nil, cerr
+	}
+	return ec.hashes[alg_id.Algorithm.String()], nil
+}
+
+// Calculates (in a single pass over the content) all digests that were not calculated yet.
+func (ec *encapsulated_content_info) hash_all(alg_ids []algorithm_identifier) CodedError {
+	// Check which hashes are missing
+	missing := make([]algorithm_identifier, 0)
+	for _, alg_id := range alg_ids {
+		if _, ok := ec.hashes[alg_id.Algorithm.String()]; ok {
+			continue
+		}
+		if _, _, cerr := get_hasher(alg_id); cerr != nil {
+			return cerr
+		}
+		missing = append(missing, alg_id)
 	}
-	// Check if the hash was already calculated
-	if ans, ok := ec.hashes[alg_id.ToHex()]; ok {
-		return ans, nil
+	if len(missing) == 0 {
+		return nil
 	}
-	// Get hasher
-	hasher, _, cerr := get_hasher(alg_id)
+	// Hash
+	r, cerr := ec.content_reader()
 	if cerr != nil {
-		return nil, cerr
+		return cerr
 	}
-	// Hash
-	if ec.IsDetached() {
-		// Open file
-		f, err := os.Open(ec.fallback_file)
-		defer f.Close()
-		if err != nil {
-			merr := NewMultiError("failed to open file", ERR_FAILED_TO_OPEN_FILE, nil)
-			merr.SetParam("path", ec.fallback_file)
-			return nil, merr
-		}
-		ans, cerr := run_hash_reader(hasher, f)
+	defer r.Close()
+	return ec.hash_reader(r, missing)
+}
+
+// Returns a reader for the content, be it attached or not.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_FAILED_TO_OPEN_FILE
+func (ec encapsulated_content_info) content_reader() (io.ReadCloser, CodedError) {
+	if !ec.IsDetached() {
+		return ioutil.NopCloser(bytes.NewReader(ec.EContent)), nil
+	}
+	if ec.fallback_content != nil {
+		return ioutil.NopCloser(bytes.NewReader(ec.fallback_content)), nil
+	}
+	if ec.fallback_file == "" {
+		return nil, NewMultiError("no content", ERR_NO_CONTENT, nil)
+	}
+	// Open file
+	f, err := os.Open(ec.fallback_file)
+	if err != nil {
+		merr := NewMultiError("failed to open file", ERR_FAILED_TO_OPEN_FILE, nil)
+		merr.SetParam("path", ec.fallback_file)
+		return nil, merr
+	}
+	return f, nil
+}
+
+// Reads r until EOF and stores its digests with all the given algorithms.
+func (ec *encapsulated_content_info) hash_reader(r io.Reader, alg_ids []algorithm_identifier) CodedError {
+	hashers := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0)
+	for _, alg_id := range alg_ids {
+		hasher, _, cerr := get_hasher(alg_id)
 		if cerr != nil {
-			return nil, cerr
+			return cerr
 		}
-		ec.hashes[alg_id.ToHex()] = ans
-		return ans, nil
+		hashers[alg_id.Algorithm.String()] = hasher
+		writers = append(writers, hasher)
 	}
-	ans := run_hash(hasher, ec.EContent)
-	ec.hashes[alg_id.ToHex()] = ans
-	return ans, nil
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return NewMultiError("failed to read content", ERR_FAILED_HASH, nil, err)
+	}
+	if ec.hashes == nil {
+		ec.hashes = make(map[string][]byte)
+	}
+	for key, hasher := range hashers {
+		ec.hashes[key] = hasher.Sum(nil)
+	}
+	return nil
 }
 
 /*	According to RFC 5652 Section 5.2 Page 11 Paragraph 2:
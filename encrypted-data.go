@@ -0,0 +1,207 @@
+package libICP
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// See RFC 5652 Section 8
+type encrypted_data_raw struct {
+	RawContent           asn1.RawContent
+	Version              int
+	EncryptedContentInfo encrypted_content_info
+	UnprotectedAttrs     asn1.RawValue `asn1:"tag:1,optional,omitempty"`
+}
+
+// See RFC 8018 Appendix A.4
+type pbes2_params struct {
+	KeyDerivationFunc algorithm_identifier_decode
+	EncryptionScheme  algorithm_identifier_decode
+}
+
+// See RFC 8018 Appendix A.2. The PRF defaults to hmacWithSHA1.
+type pbkdf2_params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                         `asn1:"optional,omitempty"`
+	PRF            algorithm_identifier_decode `asn1:"optional,omitempty"`
+}
+
+// HMAC algorithms, used both as PBKDF2 PRFs and as MAC algorithms. (see RFC 8018 Appendix B.1 and RFC 3370 Section 3.1)
+var hmac_algs = map[string]func() hash.Hash{
+	idHmacSHA1.String():       sha1.New,
+	idHmacWithSHA1.String():   sha1.New,
+	idHmacWithSHA224.String(): sha256.New224,
+	idHmacWithSHA256.String(): sha256.New,
+	idHmacWithSHA384.String(): sha512.New384,
+	idHmacWithSHA512.String(): sha512.New,
+}
+
+// Used when encrypting.
+const pbkdf2_iterations = 100000
+
+// Decryption rejects larger iteration counts, so a forged file cannot keep the CPU busy for hours.
+const pbkdf2_max_iterations = 10000000
+const pbkdf2_salt_size = 16
+
+// See RFC 8018 Section 5.2
+func pbkdf2(prf func() hash.Hash, password, salt []byte, iterations, size int) []byte {
+	mac := hmac.New(prf, password)
+	h_len := mac.Size()
+	n_blocks := (size + h_len - 1) / h_len
+	ans := make([]byte, 0, n_blocks*h_len)
+	counter := make([]byte, 4)
+	u := make([]byte, 0, h_len)
+	for block := 1; block <= n_blocks; block++ {
+		mac.Reset()
+		mac.Write(salt)
+		binary.BigEndian.PutUint32(counter, uint32(block))
+		mac.Write(counter)
+		u = mac.Sum(u[:0])
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		ans = append(ans, t...)
+	}
+	return ans[:size]
+}
+
+// Encrypts the content with PBES2, using PBKDF2 (with HMAC-SHA256) to derive the key from the password. Only AES-CBC is accepted, as it is the only AES mode defined for PBES2.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE
+func encrypt_content_with_password(alg_oid string, password string, content []byte) (encrypted_content_info, CodedError) {
+	eci := encrypted_content_info{}
+	alg, ok := content_enc_algs[alg_oid]
+	if !ok || alg.gcm {
+		merr := NewMultiError("unsupported content encryption algorithm for password based encryption", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", alg_oid)
+		return eci, merr
+	}
+
+	kdf_params := pbkdf2_params{IterationCount: pbkdf2_iterations}
+	var cerr CodedError
+	if kdf_params.Salt, cerr = random_bytes(pbkdf2_salt_size); cerr != nil {
+		return eci, cerr
+	}
+	kdf_params.PRF.Algorithm = idHmacWithSHA256
+	kdf_params.PRF.Parameters = asn1.RawValue{Tag: asn1.TagNull}
+	key := pbkdf2(hmac_algs[idHmacWithSHA256.String()], []byte(password), kdf_params.Salt, kdf_params.IterationCount, alg.key_size)
+
+	inner, _, cerr := encrypt_content_with_key(alg_oid, key, content)
+	if cerr != nil {
+		return eci, cerr
+	}
+	params := pbes2_params{}
+	params.KeyDerivationFunc.Algorithm = idPBKDF2
+	if params.KeyDerivationFunc.Parameters, cerr = marshal_alg_params(kdf_params); cerr != nil {
+		return eci, cerr
+	}
+	params.EncryptionScheme = inner.ContentEncryptionAlgorithm
+
+	eci.ContentEncryptionAlgorithm.Algorithm = idPBES2
+	if eci.ContentEncryptionAlgorithm.Parameters, cerr = marshal_alg_params(params); cerr != nil {
+		return eci, cerr
+	}
+	eci.EncryptedContent = inner.EncryptedContent
+	return eci, nil
+}
+
+// Besides PBES2 (with AES-CBC), the legacy PKCS#12 algorithms (3DES and 40 bit RC2) are also accepted, so the encrypted data found in PFX files can be read too. The iteration count must be between 1 and pbkdf2_max_iterations.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT
+func decrypt_content_with_password(eci encrypted_content_info, password string) ([]byte, CodedError) {
+	alg_id := eci.ContentEncryptionAlgorithm
+	fail := func(errs ...interface{}) CodedError {
+		merr := NewMultiError("failed to decrypt content", ERR_FAILED_TO_DECRYPT, nil, errs...)
+		merr.SetParam("algorithm", alg_id.Algorithm.String())
+		return merr
+	}
+	bad_iterations := func(iterations int) CodedError {
+		merr := NewMultiError("invalid iteration count for password based encryption", ERR_FAILED_TO_DECRYPT, nil)
+		merr.SetParam("algorithm", alg_id.Algorithm.String())
+		merr.SetParam("iterations", iterations)
+		return merr
+	}
+	unknown := func(alg asn1.ObjectIdentifier) CodedError {
+		merr := NewMultiError("unsupported password based encryption algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", alg.String())
+		return merr
+	}
+
+	switch {
+	case alg_id.Algorithm.Equal(idPbeWithSHAAnd3KeyTripleDES_CBC), alg_id.Algorithm.Equal(idPbeWithSHAAnd40BitRC2_CBC):
+		params := pbes1_parameters{}
+		if _, err := asn1.Unmarshal(alg_id.Parameters.FullBytes, &params); err != nil {
+			return nil, fail(err)
+		}
+		if params.Iterations < 1 || params.Iterations > pbkdf2_max_iterations {
+			return nil, bad_iterations(params.Iterations)
+		}
+		ciphertext, err := octet_string_bytes(eci.EncryptedContent)
+		if err != nil {
+			return nil, fail(err)
+		}
+		if len(ciphertext) == 0 || len(ciphertext)%8 != 0 {
+			return nil, fail()
+		}
+		real_decrypt := decrypt_PbeWithSHAAnd3KeyTripleDES_CBC
+		if alg_id.Algorithm.Equal(idPbeWithSHAAnd40BitRC2_CBC) {
+			real_decrypt = decrypt_PbeWithSHAAnd40BitRC2_CBC
+		}
+		content, cerr := real_decrypt(conv_password(password), params.Iterations, params.Salt, ciphertext)
+		if cerr != nil {
+			return nil, fail(cerr)
+		}
+		if content == nil {
+			return nil, fail()
+		}
+		return content, nil
+
+	case alg_id.Algorithm.Equal(idPBES2):
+		params := pbes2_params{}
+		if _, err := asn1.Unmarshal(alg_id.Parameters.FullBytes, &params); err != nil {
+			return nil, fail(err)
+		}
+		if !params.KeyDerivationFunc.Algorithm.Equal(idPBKDF2) {
+			return nil, unknown(params.KeyDerivationFunc.Algorithm)
+		}
+		kdf_params := pbkdf2_params{}
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf_params); err != nil {
+			return nil, fail(err)
+		}
+		if kdf_params.IterationCount < 1 || kdf_params.IterationCount > pbkdf2_max_iterations {
+			return nil, bad_iterations(kdf_params.IterationCount)
+		}
+		prf_oid := idHmacWithSHA1
+		if len(kdf_params.PRF.Algorithm) > 0 {
+			prf_oid = kdf_params.PRF.Algorithm
+		}
+		prf, ok := hmac_algs[prf_oid.String()]
+		if !ok {
+			return nil, unknown(prf_oid)
+		}
+		alg, ok := content_enc_algs[params.EncryptionScheme.Algorithm.String()]
+		if !ok || alg.gcm {
+			return nil, unknown(params.EncryptionScheme.Algorithm)
+		}
+		if kdf_params.KeyLength != 0 && kdf_params.KeyLength != alg.key_size {
+			return nil, fail()
+		}
+		key := pbkdf2(prf, []byte(password), kdf_params.Salt, kdf_params.IterationCount, alg.key_size)
+		inner := encrypted_content_info{ContentType: eci.ContentType, ContentEncryptionAlgorithm: params.EncryptionScheme, EncryptedContent: eci.EncryptedContent}
+		return decrypt_content(inner, key, nil, nil)
+	}
+	return nil, unknown(alg_id.Algorithm)
+}
@@ -0,0 +1,342 @@
+package libICP
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+type enveloped_data_raw struct {
+	RawContent           asn1.RawContent
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"tag:0,optional,omitempty"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encrypted_content_info
+	UnprotectedAttrs     asn1.RawValue `asn1:"tag:1,optional,omitempty"`
+}
+
+// Used with AES-GCM, as it is an authenticated encryption algorithm. (see RFC 5083 and RFC 5084)
+type auth_enveloped_data_raw struct {
+	RawContent               asn1.RawContent
+	Version                  int
+	OriginatorInfo           asn1.RawValue   `asn1:"tag:0,optional,omitempty"`
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo encrypted_content_info
+	AuthAttrs                asn1.RawValue `asn1:"tag:1,optional,omitempty"`
+	MAC                      []byte
+	UnauthAttrs              asn1.RawValue `asn1:"tag:2,optional,omitempty"`
+}
+
+// The encrypted content is kept as a raw value because BER encoders often split it into a constructed OCTET STRING.
+type encrypted_content_info struct {
+	RawContent                 asn1.RawContent
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm algorithm_identifier_decode
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional,omitempty"`
+}
+
+// Only the key transport recipient info is supported. Rid_V0 is used for version 0 and Rid_V2 (subject key identifier) for version 2.
+type key_trans_recipient_info struct {
+	RawContent             asn1.RawContent
+	Version                int
+	Rid_V0                 issuer_and_serial_number `asn1:"optional,omitempty"`
+	Rid_V2                 []byte                   `asn1:"tag:0,optional,omitempty"`
+	KeyEncryptionAlgorithm algorithm_identifier_decode
+	EncryptedKey           []byte
+}
+
+// See RFC 4055 Section 4.1
+type rsaes_oaep_params struct {
+	HashFunc    algorithm_identifier_decode `asn1:"explicit,tag:0,optional"`
+	MaskGenFunc algorithm_identifier_decode `asn1:"explicit,tag:1,optional"`
+	PSourceFunc algorithm_identifier_decode `asn1:"explicit,tag:2,optional"`
+}
+
+// See RFC 5084 Section 3.2
+type gcm_parameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+const gcm_tag_size = 16
+
+// Returns the octets of a primitive or constructed OCTET STRING (with any tag).
+func octet_string_bytes(val asn1.RawValue) ([]byte, error) {
+	if !val.IsCompound {
+		return val.Bytes, nil
+	}
+	ans := make([]byte, 0)
+	rest := val.Bytes
+	for len(rest) > 0 {
+		child := asn1.RawValue{}
+		var err error
+		rest, err = asn1.Unmarshal(rest, &child)
+		if err != nil {
+			return nil, err
+		}
+		dat, err := octet_string_bytes(child)
+		if err != nil {
+			return nil, err
+		}
+		ans = append(ans, dat...)
+	}
+	return ans, nil
+}
+
+func random_bytes(n int) ([]byte, CodedError) {
+	ans := make([]byte, n)
+	if _, err := rand.Read(ans); err != nil {
+		return nil, NewMultiError("failed to generate random bytes", ERR_SECURE_RANDOM, nil, err)
+	}
+	return ans, nil
+}
+
+func marshal_alg_params(val interface{}) (asn1.RawValue, CodedError) {
+	raw, err := asn1.Marshal(val)
+	if err != nil {
+		return asn1.RawValue{}, NewMultiError("failed to encode algorithm parameters", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return asn1.RawValue{FullBytes: raw}, nil
+}
+
+// Encrypts the content with a new random key. The MAC is only returned for AES-GCM.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE
+func encrypt_content(alg_oid string, content []byte) (eci encrypted_content_info, key, mac []byte, cerr CodedError) {
+	alg, ok := content_enc_algs[alg_oid]
+	if !ok {
+		merr := NewMultiError("unknown content encryption algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", alg_oid)
+		return eci, nil, nil, merr
+	}
+	if key, cerr = random_bytes(alg.key_size); cerr != nil {
+		return
+	}
+	eci, mac, cerr = encrypt_content_with_key(alg_oid, key, content)
+	return eci, key, mac, cerr
+}
+
+// Same as encrypt_content but with a given key, which MUST have the right size for the algorithm.
+func encrypt_content_with_key(alg_oid string, key, content []byte) (eci encrypted_content_info, mac []byte, cerr CodedError) {
+	alg := content_enc_algs[alg_oid]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return eci, nil, NewMultiError("failed to encrypt content", ERR_FAILED_TO_ENCRYPT, nil, err)
+	}
+	eci.ContentEncryptionAlgorithm.Algorithm = str2oid_key(alg_oid)
+
+	var ciphertext []byte
+	if alg.gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return eci, nil, NewMultiError("failed to encrypt content", ERR_FAILED_TO_ENCRYPT, nil, err)
+		}
+		params := gcm_parameters{ICVLen: gcm_tag_size}
+		if params.Nonce, cerr = random_bytes(aead.NonceSize()); cerr != nil {
+			return
+		}
+		sealed := aead.Seal(nil, params.Nonce, content, nil)
+		ciphertext, mac = sealed[:len(sealed)-gcm_tag_size], sealed[len(sealed)-gcm_tag_size:]
+		if eci.ContentEncryptionAlgorithm.Parameters, cerr = marshal_alg_params(params); cerr != nil {
+			return
+		}
+	} else {
+		var iv []byte
+		if iv, cerr = random_bytes(aes.BlockSize); cerr != nil {
+			return
+		}
+		// PKCS #7 padding (see RFC 5652 Section 6.3)
+		pad := aes.BlockSize - len(content)%aes.BlockSize
+		ciphertext = append(append([]byte{}, content...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		if eci.ContentEncryptionAlgorithm.Parameters, cerr = marshal_alg_params(iv); cerr != nil {
+			return
+		}
+	}
+	eci.EncryptedContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: ciphertext}
+	return eci, mac, nil
+}
+
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT
+func decrypt_content(eci encrypted_content_info, key, mac, aad []byte) ([]byte, CodedError) {
+	fail := func(errs ...interface{}) CodedError {
+		merr := NewMultiError("failed to decrypt content", ERR_FAILED_TO_DECRYPT, nil, errs...)
+		merr.SetParam("algorithm", eci.ContentEncryptionAlgorithm.Algorithm.String())
+		return merr
+	}
+	alg, ok := content_enc_algs[eci.ContentEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		merr := NewMultiError("unknown content encryption algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", eci.ContentEncryptionAlgorithm.Algorithm.String())
+		return nil, merr
+	}
+	ciphertext, err := octet_string_bytes(eci.EncryptedContent)
+	if err != nil {
+		return nil, fail(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	if alg.gcm {
+		params := gcm_parameters{}
+		if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, fail(err)
+		}
+		var aead cipher.AEAD
+		if len(params.Nonce) == 12 {
+			aead, err = cipher.NewGCMWithTagSize(block, params.ICVLen)
+		} else if params.ICVLen == gcm_tag_size {
+			aead, err = cipher.NewGCMWithNonceSize(block, len(params.Nonce))
+		} else {
+			return nil, fail()
+		}
+		if err != nil || len(mac) != params.ICVLen {
+			return nil, fail(err)
+		}
+		content, err := aead.Open(nil, params.Nonce, append(append([]byte{}, ciphertext...), mac...), aad)
+		if err != nil {
+			return nil, fail(err)
+		}
+		return content, nil
+	}
+
+	iv := []byte{}
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, fail(err)
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fail()
+	}
+	content := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, ciphertext)
+	pad := int(content[len(content)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(content[len(content)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fail()
+	}
+	return content[:len(content)-pad], nil
+}
+
+// RSA-OAEP always uses SHA256 (for both the hash and the mask generation functions) when encrypting.
+//
+// Possible errors are: ERR_PARSE_CERT, ERR_PARSE_RSA_PUBKEY, ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_ENCRYPT, ERR_FAILED_TO_ENCODE
+func new_key_trans_recipient_info(cert *Certificate, key_alg string, key []byte) (key_trans_recipient_info, CodedError) {
+	ktri := key_trans_recipient_info{}
+	ias, cerr := cert.issuer_and_serial()
+	if cerr != nil {
+		return ktri, cerr
+	}
+	ktri.Rid_V0 = ias
+	pubkey, err := cert.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+	if err != nil {
+		merr := NewMultiError("failed to parse recipient public key", ERR_PARSE_RSA_PUBKEY, nil, err)
+		merr.SetParam("cert.Subject", cert.Subject)
+		return ktri, merr
+	}
+
+	switch key_alg {
+	case KEY_TRANSPORT_RSA_PKCS1V15:
+		ktri.KeyEncryptionAlgorithm.Algorithm = idRSAEncryption
+		ktri.KeyEncryptionAlgorithm.Parameters = asn1.RawValue{Tag: asn1.TagNull}
+		ktri.EncryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, &pubkey, key)
+	case KEY_TRANSPORT_RSA_OAEP:
+		params := rsaes_oaep_params{}
+		params.HashFunc.Algorithm = idSha256
+		params.MaskGenFunc.Algorithm = idMGF1
+		if params.MaskGenFunc.Parameters, cerr = marshal_alg_params(params.HashFunc); cerr != nil {
+			return ktri, cerr
+		}
+		ktri.KeyEncryptionAlgorithm.Algorithm = idRSAES_OAEP
+		if ktri.KeyEncryptionAlgorithm.Parameters, cerr = marshal_alg_params(params); cerr != nil {
+			return ktri, cerr
+		}
+		ktri.EncryptedKey, err = rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, &pubkey, key, nil)
+	default:
+		merr := NewMultiError("unknown key transport algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("algorithm", key_alg)
+		return ktri, merr
+	}
+	if err != nil {
+		merr := NewMultiError("failed to encrypt content key", ERR_FAILED_TO_ENCRYPT, nil, err)
+		merr.SetParam("cert.Subject", cert.Subject)
+		return ktri, merr
+	}
+	return ktri, nil
+}
+
+// Returns whether this recipient info refers to the given certificate.
+func (ktri key_trans_recipient_info) is_for(cert Certificate) bool {
+	if len(ktri.Rid_V2) > 0 {
+		return cert.SubjectKeyId == nice_hex(ktri.Rid_V2)
+	}
+	if ktri.Rid_V0.SerialNumber == nil {
+		return false
+	}
+	ias, cerr := cert.issuer_and_serial()
+	if cerr != nil {
+		return false
+	}
+	return ias.SerialNumber.Cmp(ktri.Rid_V0.SerialNumber) == 0 && bytes.Equal(ias.Issuer.FullBytes, ktri.Rid_V0.Issuer.FullBytes)
+}
+
+// For PKCS #1 v1.5, a wrong key is returned instead of an error when the padding is invalid, so the content decryption fails instead. (see crypto/rsa.DecryptPKCS1v15SessionKey)
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_FAILED_TO_DECRYPT, ERR_SECURE_RANDOM
+func (ktri key_trans_recipient_info) decrypt_key(priv *rsa.PrivateKey, key_size int) ([]byte, CodedError) {
+	alg := ktri.KeyEncryptionAlgorithm
+	switch {
+	case alg.Algorithm.Equal(idRSAEncryption):
+		key, cerr := random_bytes(key_size)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if err := rsa.DecryptPKCS1v15SessionKey(nil, priv, ktri.EncryptedKey, key); err != nil {
+			return nil, NewMultiError("failed to decrypt content key", ERR_FAILED_TO_DECRYPT, nil, err)
+		}
+		return key, nil
+	case alg.Algorithm.Equal(idRSAES_OAEP):
+		// SHA1 is the default for both functions
+		params := rsaes_oaep_params{}
+		if len(alg.Parameters.FullBytes) > 0 {
+			if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+				return nil, NewMultiError("failed to parse RSA-OAEP parameters", ERR_FAILED_TO_DECRYPT, nil, err)
+			}
+		}
+		hash_alg := algorithm_identifier{Algorithm: idSha1}
+		if len(params.HashFunc.Algorithm) > 0 {
+			hash_alg.Algorithm = params.HashFunc.Algorithm
+		}
+		mgf_hash_alg := algorithm_identifier{Algorithm: idSha1}
+		if len(params.MaskGenFunc.Algorithm) > 0 {
+			mgf_alg := algorithm_identifier_decode{}
+			if _, err := asn1.Unmarshal(params.MaskGenFunc.Parameters.FullBytes, &mgf_alg); err != nil || !params.MaskGenFunc.Algorithm.Equal(idMGF1) {
+				merr := NewMultiError("unknown mask generation function", ERR_UNKOWN_ALGORITHM, nil)
+				merr.SetParam("algorithm", params.MaskGenFunc.Algorithm.String())
+				return nil, merr
+			}
+			mgf_hash_alg.Algorithm = mgf_alg.Algorithm
+		}
+		_, hash, cerr := get_hasher(hash_alg)
+		if cerr != nil {
+			return nil, cerr
+		}
+		_, mgf_hash, cerr := get_hasher(mgf_hash_alg)
+		if cerr != nil {
+			return nil, cerr
+		}
+		key, err := priv.Decrypt(nil, ktri.EncryptedKey, &rsa.OAEPOptions{Hash: hash, MGFHash: mgf_hash})
+		if err != nil {
+			return nil, NewMultiError("failed to decrypt content key", ERR_FAILED_TO_DECRYPT, nil, err)
+		}
+		return key, nil
+	}
+	merr := NewMultiError("unknown key transport algorithm", ERR_UNKOWN_ALGORITHM, nil)
+	merr.SetParam("algorithm", alg.Algorithm.String())
+	return nil, merr
+}
@@ -0,0 +1,218 @@
+package libICP
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Runs the name constraints processing of RFC 5280 Section 6.1 on a path as returned by build_path (the end certificate first and the trust anchor last). As in the certificate policy processing, the trust anchor is an input, so its own constraints are not applied. Instead of intersecting the permitted subtrees, the constraints of each CA are checked separately, which gives the same result.
+//
+// Only the directoryName, rfc822Name, dNSName, URI and IP address name forms are supported. As required by RFC 5280 Section 4.2.1.10, a certificate with a name of another form (like the otherName used by ICP-Brasil) is rejected if a CA above it constrains that form.
+//
+// Possible errors are: ERR_NAME_NOT_PERMITTED, ERR_NAME_EXCLUDED, ERR_UNSUPPORTED_NAME_CONSTRAINT
+func process_name_constraints(path []*Certificate) []CodedError {
+	ans := []CodedError{}
+	n := len(path) - 1
+	for i := n - 1; i >= 0; i-- {
+		cert := path[i]
+		// Self-issued intermediate certificates are not checked (see RFC 5280 Section 4.2.1.10)
+		if i > 0 && cert.Subject == cert.Issuer {
+			continue
+		}
+		names := cert.constrained_names()
+		for _, ca := range path[i+1 : n] {
+			if !ca.ext_name_constraints.Exists {
+				continue
+			}
+			if err := ca.ext_name_constraints.check(names); err != nil {
+				err.SetParam("cert.Subject", cert.Subject)
+				err.SetParam("ca.Subject", ca.Subject)
+				ans = append(ans, err)
+			}
+		}
+	}
+	return ans
+}
+
+// Returns the subject and the subject alternative names. Email addresses in the subject are also checked as rfc822Name. (see RFC 5280 Section 4.2.1.10)
+func (cert *Certificate) constrained_names() general_names {
+	ans := cert.ext_subject_alt_name.general_names
+	subject := cert.base.TBSCertificate.Subject
+	if len(subject) > 0 {
+		ans.DirectoryNames = append([]nameT{subject}, ans.DirectoryNames...)
+	}
+	for _, set := range subject {
+		for _, item := range set {
+			if item.Type.Equal(idEmailName) {
+				ans.Emails = append([]string{fmt.Sprintf("%s", item.Value)}, ans.Emails...)
+			}
+		}
+	}
+	return ans
+}
+
+// Returns an error for the first name which is not in the permitted subtrees or which is in the excluded ones. The permitted subtrees of a name form only restrict names of that form.
+func (nc ext_name_constraints) check(names general_names) *MultiError {
+	for _, tag := range names.UnsupportedForms {
+		if int_in_list(tag, nc.Permitted.UnsupportedForms) || int_in_list(tag, nc.Excluded.UnsupportedForms) {
+			merr := NewMultiError("name constraints on this name form are not supported", ERR_UNSUPPORTED_NAME_CONSTRAINT, nil)
+			merr.SetParam("name-form", general_name_form(tag))
+			return &merr
+		}
+	}
+
+	var ans *MultiError
+	check_form := func(form string, count, n_permitted int, within func(i int, subtrees name_subtrees) bool) {
+		for i := 0; i < count && ans == nil; i++ {
+			if n_permitted > 0 && !within(i, nc.Permitted) {
+				merr := NewMultiError("name not in the permitted subtrees of the name constraints", ERR_NAME_NOT_PERMITTED, nil)
+				ans = &merr
+			} else if within(i, nc.Excluded) {
+				merr := NewMultiError("name in the excluded subtrees of the name constraints", ERR_NAME_EXCLUDED, nil)
+				ans = &merr
+			} else {
+				continue
+			}
+			ans.SetParam("name-form", form)
+			ans.SetParam("name", names.string_at(form, i))
+		}
+	}
+
+	check_form("directoryName", len(names.DirectoryNames), len(nc.Permitted.DirectoryNames), func(i int, subtrees name_subtrees) bool {
+		for _, base := range subtrees.DirectoryNames {
+			if dn_within(names.DirectoryNames[i], base) {
+				return true
+			}
+		}
+		return false
+	})
+	check_form("rfc822Name", len(names.Emails), len(nc.Permitted.Emails), func(i int, subtrees name_subtrees) bool {
+		return any_str_within(names.Emails[i], subtrees.Emails, email_within)
+	})
+	check_form("dNSName", len(names.DNSNames), len(nc.Permitted.DNSNames), func(i int, subtrees name_subtrees) bool {
+		return any_str_within(names.DNSNames[i], subtrees.DNSNames, dns_within)
+	})
+	check_form("uniformResourceIdentifier", len(names.URIs), len(nc.Permitted.URIs), func(i int, subtrees name_subtrees) bool {
+		return any_str_within(names.URIs[i], subtrees.URIs, uri_within)
+	})
+	check_form("iPAddress", len(names.IPs), len(nc.Permitted.IPRanges), func(i int, subtrees name_subtrees) bool {
+		for _, ip_range := range subtrees.IPRanges {
+			if ip_within(names.IPs[i], ip_range) {
+				return true
+			}
+		}
+		return false
+	})
+	return ans
+}
+
+// Used for error messages.
+func (names general_names) string_at(form string, i int) string {
+	switch form {
+	case "directoryName":
+		return names.DirectoryNames[i].String()
+	case "rfc822Name":
+		return names.Emails[i]
+	case "dNSName":
+		return names.DNSNames[i]
+	case "uniformResourceIdentifier":
+		return names.URIs[i]
+	}
+	return names.IPs[i].String()
+}
+
+func any_str_within(name string, bases []string, within func(name, base string) bool) bool {
+	for _, base := range bases {
+		if within(name, base) {
+			return true
+		}
+	}
+	return false
+}
+
+// A name is within a directoryName constraint if the RDNs of the constraint are its first RDNs. Values are compared ignoring case and repeated spaces. (see RFC 5280 Section 7.1)
+func dn_within(name, base nameT) bool {
+	if len(base) > len(name) {
+		return false
+	}
+	for i, base_set := range base {
+		set := name[i]
+		if len(set) != len(base_set) {
+			return false
+		}
+		for j, base_item := range base_set {
+			if !set[j].Type.Equal(base_item.Type) || normalize_dn_value(set[j].Value) != normalize_dn_value(base_item.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func normalize_dn_value(value interface{}) string {
+	s := fmt.Sprintf("%s", value)
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// The constraint may be a mailbox ("joao@example.com"), all mailboxes of a host ("example.com") or all mailboxes of the subdomains of a domain (".example.com").
+func email_within(email, base string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	if strings.Contains(base, "@") {
+		base_at := strings.LastIndex(base, "@")
+		return email[:at] == base[:base_at] && strings.EqualFold(email[at+1:], base[base_at+1:])
+	}
+	host := strings.ToLower(email[at+1:])
+	base = strings.ToLower(base)
+	if strings.HasPrefix(base, ".") {
+		return strings.HasSuffix(host, base)
+	}
+	return host == base
+}
+
+// The constraint matches the domain itself and its subdomains. If it starts with a period, only the subdomains are matched.
+func dns_within(name, base string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	base = strings.ToLower(base)
+	if base == "" {
+		return true
+	}
+	if strings.HasPrefix(base, ".") {
+		return strings.HasSuffix(name, base)
+	}
+	return name == base || strings.HasSuffix(name, "."+base)
+}
+
+// The constraint applies to the host of the URI. A constraint starting with a period matches only the subdomains, otherwise only the host itself. URIs without a host are never within a constraint.
+func uri_within(uri, base string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return false
+	}
+	base = strings.ToLower(base)
+	if strings.HasPrefix(base, ".") {
+		return strings.HasSuffix(host, base)
+	}
+	return host == base
+}
+
+// IPv4 addresses only match IPv4 ranges and IPv6 addresses only match IPv6 ranges.
+func ip_within(ip net.IP, ip_range net.IPNet) bool {
+	if len(ip) != len(ip_range.IP) || len(ip) != len(ip_range.Mask) {
+		return false
+	}
+	for i := range ip {
+		if ip[i]&ip_range.Mask[i] != ip_range.IP[i]&ip_range.Mask[i] {
+			return false
+		}
+	}
+	return true
+}
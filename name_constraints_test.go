@@ -0,0 +1,149 @@
+package libICP
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get_name_constraints_test_cert(t *testing.T, name string) *Certificate {
+	certs, errs := NewCertificateFromFile("data/test-name-constraints/" + name + ".crt.pem")
+	require.Nil(t, errs)
+	return certs[0]
+}
+
+func get_name_constraints_test_store(t *testing.T) *CAStore {
+	store := get_test_store(t, false)
+	store.direct_add_ca(get_name_constraints_test_cert(t, "restricted-ca"))
+	return store
+}
+
+func name_constraints_err_codes(errs []CodedError) []ErrorCode {
+	ans := []ErrorCode{}
+	for _, err := range errs {
+		if err.Code() == ERR_NAME_NOT_PERMITTED || err.Code() == ERR_NAME_EXCLUDED {
+			ans = append(ans, err.Code())
+		}
+	}
+	return ans
+}
+
+func Test_Certificate_NameConstraints_1(t *testing.T) {
+	ca := get_name_constraints_test_cert(t, "restricted-ca")
+	nc := ca.ext_name_constraints
+	require.True(t, nc.Exists)
+	assert.Equal(t, []string{"fakebank.com.br"}, nc.Permitted.DNSNames)
+	assert.Equal(t, []string{"fakebank.com.br"}, nc.Permitted.Emails)
+	require.Len(t, nc.Permitted.DirectoryNames, 1)
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil", nc.Permitted.DirectoryNames[0].String())
+	assert.Equal(t, []string{"evil.fakebank.com.br"}, nc.Excluded.DNSNames)
+	require.Len(t, nc.Excluded.IPRanges, 1)
+	assert.Equal(t, "10.0.0.0/8", nc.Excluded.IPRanges[0].String())
+}
+
+func Test_CAStore_VerifyCertAt_NameConstraints_1(t *testing.T) {
+	store := get_name_constraints_test_store(t)
+	now := time.Now()
+
+	cert := get_name_constraints_test_cert(t, "ok")
+	_, _, errs, _ := store.verify_cert_at(cert, now)
+	assert.Nil(t, errs)
+
+	cert = get_name_constraints_test_cert(t, "excluded")
+	_, _, errs, _ = store.verify_cert_at(cert, now)
+	assert.Equal(t, []ErrorCode{ERR_NAME_EXCLUDED}, name_constraints_err_codes(errs))
+
+	cert = get_name_constraints_test_cert(t, "not-permitted")
+	_, _, errs, _ = store.verify_cert_at(cert, now)
+	assert.Equal(t, []ErrorCode{ERR_NAME_NOT_PERMITTED}, name_constraints_err_codes(errs))
+}
+
+func Test_ProcessNameConstraints_1(t *testing.T) {
+	root := &Certificate{Subject: "root", Issuer: "root"}
+	ca := &Certificate{Subject: "ca", Issuer: "root"}
+	ca.ext_name_constraints.Exists = true
+	ca.ext_name_constraints.Permitted.DNSNames = []string{"example.com"}
+	end_cert := &Certificate{Subject: "end", Issuer: "ca"}
+
+	end_cert.ext_subject_alt_name.DNSNames = []string{"www.example.com"}
+	assert.Empty(t, process_name_constraints([]*Certificate{end_cert, ca, root}))
+
+	end_cert.ext_subject_alt_name.DNSNames = []string{"www.example.com", "example.org"}
+	errs := process_name_constraints([]*Certificate{end_cert, ca, root})
+	require.Len(t, errs, 1)
+	assert.EqualValues(t, ERR_NAME_NOT_PERMITTED, errs[0].Code())
+
+	// The constraints of the trust anchor are not applied
+	errs = process_name_constraints([]*Certificate{end_cert, ca})
+	assert.Empty(t, errs)
+}
+
+func Test_ProcessNameConstraints_2(t *testing.T) {
+	root := &Certificate{Subject: "root", Issuer: "root"}
+	ca := &Certificate{Subject: "ca", Issuer: "root"}
+	ca.ext_name_constraints.Exists = true
+	ca.ext_name_constraints.Permitted.DNSNames = []string{"example.com"}
+	end_cert := &Certificate{Subject: "end", Issuer: "ca"}
+	end_cert.ext_subject_alt_name.UnsupportedForms = []int{0}
+	assert.Empty(t, process_name_constraints([]*Certificate{end_cert, ca, root}))
+
+	// A constraint on other names can not be checked
+	ca.ext_name_constraints.Excluded.UnsupportedForms = []int{0}
+	errs := process_name_constraints([]*Certificate{end_cert, ca, root})
+	require.Len(t, errs, 1)
+	assert.EqualValues(t, ERR_UNSUPPORTED_NAME_CONSTRAINT, errs[0].Code())
+}
+
+func Test_DNWithin_1(t *testing.T) {
+	base := nameT{
+		[]atv{atv{Type: idCountryName, Value: "BR"}},
+		[]atv{atv{Type: idOrganizationName, Value: "Fake  ICP-Brasil"}},
+	}
+	name := nameT{
+		[]atv{atv{Type: idCountryName, Value: "br"}},
+		[]atv{atv{Type: idOrganizationName, Value: "Fake ICP-BRASIL"}},
+		[]atv{atv{Type: idCommonName, Value: "Fulano"}},
+	}
+	assert.True(t, dn_within(name, base))
+	assert.True(t, dn_within(name, nameT{}))
+	assert.False(t, dn_within(base, name))
+	name[1][0].Value = "Other"
+	assert.False(t, dn_within(name, base))
+}
+
+func Test_EmailWithin_1(t *testing.T) {
+	assert.True(t, email_within("joao@example.com", "joao@EXAMPLE.com"))
+	assert.False(t, email_within("maria@example.com", "joao@example.com"))
+	assert.True(t, email_within("joao@Example.com", "example.com"))
+	assert.False(t, email_within("joao@mail.example.com", "example.com"))
+	assert.True(t, email_within("joao@mail.example.com", ".example.com"))
+	assert.False(t, email_within("joao@example.com", ".example.com"))
+	assert.False(t, email_within("joao", "example.com"))
+}
+
+func Test_DNSWithin_1(t *testing.T) {
+	assert.True(t, dns_within("example.com", "example.com"))
+	assert.True(t, dns_within("www.Example.com", "example.com"))
+	assert.False(t, dns_within("badexample.com", "example.com"))
+	assert.False(t, dns_within("example.com", ".example.com"))
+	assert.True(t, dns_within("www.example.com", ".example.com"))
+	assert.True(t, dns_within("anything", ""))
+}
+
+func Test_URIWithin_1(t *testing.T) {
+	assert.True(t, uri_within("https://example.com:8080/path", "example.com"))
+	assert.False(t, uri_within("https://www.example.com/", "example.com"))
+	assert.True(t, uri_within("https://www.example.com/", ".example.com"))
+	assert.False(t, uri_within("urn:example.com", "example.com"))
+}
+
+func Test_IPWithin_1(t *testing.T) {
+	_, ip_range, _ := net.ParseCIDR("10.0.0.0/8")
+	ip_range.IP = ip_range.IP.To4()
+	assert.True(t, ip_within(net.IP{10, 1, 2, 3}, *ip_range))
+	assert.False(t, ip_within(net.IP{11, 1, 2, 3}, *ip_range))
+	assert.False(t, ip_within(net.ParseIP("::ffff:10.1.2.3"), *ip_range))
+}
@@ -2,7 +2,9 @@ package libICP
 
 import (
 	"fmt"
+	"net"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/OpenICP-BR/asn1"
@@ -73,7 +75,65 @@ func (this nameT) String() string {
 type another_name struct {
 	RawContent asn1.RawContent
 	TypeId     asn1.ObjectIdentifier
-	Value      interface{} `asn1:"tag:0,explicit"`
+	Value      asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+// The name forms of a GeneralName (see RFC 5280 Section 4.2.1.6) which are used by name constraints. IP addresses have 4 or 16 bytes. The other forms (otherName, x400Address, ediPartyName and registeredID) are not decoded, only their tags are kept in UnsupportedForms.
+type general_names struct {
+	DirectoryNames   []nameT
+	Emails           []string
+	DNSNames         []string
+	URIs             []string
+	IPs              []net.IP
+	UnsupportedForms []int
+}
+
+// Names of the GeneralName forms by tag.
+var general_name_forms = []string{"otherName", "rfc822Name", "dNSName", "x400Address", "directoryName", "ediPartyName", "uniformResourceIdentifier", "iPAddress", "registeredID"}
+
+func general_name_form(tag int) string {
+	if tag < 0 || tag >= len(general_name_forms) {
+		return "[" + strconv.Itoa(tag) + "]"
+	}
+	return general_name_forms[tag]
+}
+
+// Appends a GeneralName. Only the tag of the unsupported forms is kept.
+func (ans *general_names) append_general_name(item asn1.RawValue) error {
+	if item.Class != asn1.ClassContextSpecific {
+		return nil
+	}
+	switch item.Tag {
+	case 1:
+		ans.Emails = append(ans.Emails, string(item.Bytes))
+	case 2:
+		ans.DNSNames = append(ans.DNSNames, string(item.Bytes))
+	case 4:
+		// Name is a CHOICE, so the tag is explicit
+		name := nameT{}
+		if _, err := asn1.Unmarshal(item.Bytes, &name); err != nil {
+			return err
+		}
+		ans.DirectoryNames = append(ans.DirectoryNames, name)
+	case 6:
+		ans.URIs = append(ans.URIs, string(item.Bytes))
+	case 7:
+		ans.IPs = append(ans.IPs, net.IP(item.Bytes))
+	default:
+		if !int_in_list(item.Tag, ans.UnsupportedForms) {
+			ans.UnsupportedForms = append(ans.UnsupportedForms, item.Tag)
+		}
+	}
+	return nil
+}
+
+func int_in_list(n int, list []int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
 }
 
 type edi_party_name struct {
@@ -22,6 +22,8 @@ var idSha3_512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 10}
 var idMd2WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 2}
 var idMd4WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 3}
 var idMd5WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}
+var idRSAES_OAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+var idMGF1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
 var idSha1WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
 var idSha256WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
 var idSha384WithRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
@@ -31,12 +33,55 @@ var idAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
 var idCeBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
 var idCeKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 15}
 var idCeCRLDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 31}
+var idCeExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+var idCeCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+var idCeSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+var idCeCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+var idAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+var idCePolicyMappings = asn1.ObjectIdentifier{2, 5, 29, 33}
+var idCePolicyConstraints = asn1.ObjectIdentifier{2, 5, 29, 36}
+var idCeInhibitAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 54}
+var idCeNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+var idQtCPS = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+var idQtUnotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+var idKpTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+var idKpClientAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+var idKpEmailProtection = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+var idKpOCSPSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}
+var idAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
 var idCtContentInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 6}
 var idContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
 var idMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
 var idSigningTime = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
-var idCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+var idCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+var idCommitmentTypeIndication = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 16}
+var idSignerLocation = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 17}
+var idAaEtsSigPolicyId = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 15}
+var idAaSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+var idSpqEtsUri = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 5, 1}
+var idCtiEtsProofOfOrigin = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 1}
+var idCtiEtsProofOfReceipt = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 2}
+var idCtiEtsProofOfDelivery = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 3}
+var idCtiEtsProofOfSender = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 4}
+var idCtiEtsProofOfApproval = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 5}
+var idCtiEtsProofOfCreation = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 6, 6}
 var idData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+var idCtTSTInfo = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+var idAaSignatureTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+var idAaEtsCertificateRefs = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 21}
+var idAaEtsRevocationRefs = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 22}
+var idAaEtsEscTimeStamp = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 25}
+var idAaEtsCertValues = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 23}
+var idAaEtsRevocationValues = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 24}
+var idAaEtsArchiveTimestampV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 48}
+var idAes128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+var idAes192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+var idAes256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+var idAes128GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+var idAes192GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+var idAes256GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+var idCtAuthData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 2}
+var idCtAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
 var idSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
 var idEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
 var idSignedAndEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 4}
@@ -54,6 +99,24 @@ var idPKCS12_8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1
 var idPKCS12_CertBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
 var idPbeWithSHAAnd3KeyTripleDES_CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
 var idPbeWithSHAAnd40BitRC2_CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+var idPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+var idPBES2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+var idHmacWithSHA1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+var idHmacWithSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+var idHmacWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+var idHmacWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+var idHmacWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+var idHmacSHA1 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 1, 2}
+var idIcpBrasilPersonData = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 1}
+var idIcpBrasilResponsibleName = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 2}
+var idIcpBrasilCNPJ = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 3}
+var idIcpBrasilResponsibleData = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 4}
+var idIcpBrasilVoterID = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 5}
+var idIcpBrasilPersonCEI = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 6}
+var idIcpBrasilCompanyCEI = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 7}
+var idIcpBrasilCompanyName = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 8}
+var idIcpBrasilOAB = asn1.ObjectIdentifier{2, 16, 76, 1, 4, 2, 1, 1}
+var idIcpBrasilCertPolicies = asn1.ObjectIdentifier{2, 16, 76, 1, 2}
 
 func oid_key2str(oid asn1.ObjectIdentifier) string {
 	switch {
@@ -76,6 +139,25 @@ func oid_key2str(oid asn1.ObjectIdentifier) string {
 	}
 }
 
+func commitment2str(oid asn1.ObjectIdentifier) string {
+	switch {
+	case oid.Equal(idCtiEtsProofOfOrigin):
+		return "proofOfOrigin"
+	case oid.Equal(idCtiEtsProofOfReceipt):
+		return "proofOfReceipt"
+	case oid.Equal(idCtiEtsProofOfDelivery):
+		return "proofOfDelivery"
+	case oid.Equal(idCtiEtsProofOfSender):
+		return "proofOfSender"
+	case oid.Equal(idCtiEtsProofOfApproval):
+		return "proofOfApproval"
+	case oid.Equal(idCtiEtsProofOfCreation):
+		return "proofOfCreation"
+	default:
+		return oid.String()
+	}
+}
+
 func str2oid_key(s string) asn1.ObjectIdentifier {
 	switch s {
 	case "C":
@@ -27,3 +27,13 @@ func Test_OID_2String2Key(t *testing.T) {
 	assert.Nil(t, str2oid_key("1.2.840.113549.1.7.1a"))
 	assert.True(t, str2oid_key("1.2.840.113549.1.7.1").Equal(idData))
 }
+
+func Test_OID_Commitment2String(t *testing.T) {
+	assert.Equal(t, "proofOfOrigin", commitment2str(idCtiEtsProofOfOrigin))
+	assert.Equal(t, "proofOfReceipt", commitment2str(idCtiEtsProofOfReceipt))
+	assert.Equal(t, "proofOfDelivery", commitment2str(idCtiEtsProofOfDelivery))
+	assert.Equal(t, "proofOfSender", commitment2str(idCtiEtsProofOfSender))
+	assert.Equal(t, "proofOfApproval", commitment2str(idCtiEtsProofOfApproval))
+	assert.Equal(t, "proofOfCreation", commitment2str(idCtiEtsProofOfCreation))
+	assert.Equal(t, "1.2.840.113549.1.7.1", commitment2str(idData))
+}
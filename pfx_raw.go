@@ -671,9 +671,21 @@ func pad_msg(msg []byte) []byte {
 	return append(msg, ps...)
 }
 
+// Returns nil if the padding is invalid (usually due to a wrong password).
 func unpad_msg(msg []byte) []byte {
+	if len(msg) == 0 {
+		return nil
+	}
 	l := msg[len(msg)-1]
+	if l == 0 || l > 8 || int(l) > len(msg) {
+		return nil
+	}
 	top := len(msg) - int(l)
+	for _, b := range msg[top:] {
+		if b != l {
+			return nil
+		}
+	}
 	return msg[:top]
 }
 
@@ -0,0 +1,359 @@
+package libICP
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// OIDs of the ICP-Brasil AD-RB (Digital Signatures with Basic Reference) policies. (see DOC-ICP-15.03)
+const (
+	POLICY_AD_RB_V2_1 = "2.16.76.1.7.1.1.2.1"
+	POLICY_AD_RB_V2_2 = "2.16.76.1.7.1.1.2.2"
+	POLICY_AD_RB_V2_3 = "2.16.76.1.7.1.1.2.3"
+)
+
+// Rules of a signature policy which are checked when creating and verifying signatures.
+type SignaturePolicy struct {
+	// Ex: "AD-RB v2.3"
+	Name string
+	OID  string
+	// Where the policy document can be downloaded from.
+	URI string
+	// OID of the algorithm used on Hash.
+	HashAlgorithm string
+	// Digest of the DER encoded policy document. It is required for creating signatures and checked when verifying them, so signatures under a policy without it are never policy compliant. The built-in policies do not have it: it comes from the LPA (see PolicyStore.LoadLPA) or from SetDocument.
+	Hash []byte
+	// OIDs of the allowed digest algorithms. If empty, any algorithm is allowed.
+	DigestAlgorithms []string
+	// OIDs of the allowed signature algorithms. If empty, any algorithm is allowed.
+	SignatureAlgorithms []string
+	// Minimum RSA key size in bits.
+	MinKeySize int
+	// Minimum RSA key size in bits for each signature algorithm OID. Algorithms not listed use MinKeySize.
+	MinKeySizes map[string]int
+	// OIDs of the signed attributes that MUST be present.
+	MandatedSignedAttrs []string
+	// OIDs of the unsigned attributes that MUST be present.
+	MandatedUnsignedAttrs []string
+	// Signatures made outside this period do not follow the policy. Zero values mean no limit.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Signatures made at or after this time do not follow the policy. It is zero if the policy was not revoked.
+	RevocationDate time.Time
+}
+
+func new_ad_rb_v2_policy(version, oid string) SignaturePolicy {
+	return SignaturePolicy{
+		Name:                "AD-RB v" + version,
+		OID:                 oid,
+		URI:                 "http://politicas.icpbrasil.gov.br/PA_AD_RB_v" + version[:1] + "_" + version[2:] + ".der",
+		HashAlgorithm:       idSha256.String(),
+		DigestAlgorithms:    []string{idSha256.String(), idSha512.String()},
+		SignatureAlgorithms: []string{idSha256WithRSAEncryption.String(), idSha512WithRSAEncryption.String(), idRSAEncryption.String()},
+		MinKeySize:          2048,
+		MandatedSignedAttrs: []string{idContentType.String(), idMessageDigest.String(), idAaEtsSigPolicyId.String(), idAaSigningCertificateV2.String()},
+	}
+}
+
+var known_policies = map[string]SignaturePolicy{
+	POLICY_AD_RB_V2_1: new_ad_rb_v2_policy("2.1", POLICY_AD_RB_V2_1),
+	POLICY_AD_RB_V2_2: new_ad_rb_v2_policy("2.2", POLICY_AD_RB_V2_2),
+	POLICY_AD_RB_V2_3: new_ad_rb_v2_policy("2.3", POLICY_AD_RB_V2_3),
+}
+
+// Returns the rules of a known signature policy. Before signing, the policy document (which can be downloaded from SignaturePolicy.URI) MUST be set with SetDocument.
+//
+// Possible errors are: ERR_POLICY_UNKNOWN
+func NewSignaturePolicy(oid string) (*SignaturePolicy, CodedError) {
+	policy, ok := known_policies[oid]
+	if !ok {
+		merr := NewMultiError("unknown signature policy", ERR_POLICY_UNKNOWN, nil)
+		merr.SetParam("oid", oid)
+		return nil, merr
+	}
+	return &policy, nil
+}
+
+// Calculates the policy hash from the DER encoded policy document.
+func (policy *SignaturePolicy) SetDocument(raw []byte) CodedError {
+	hasher, _, cerr := get_hasher(policy.hash_alg())
+	if cerr != nil {
+		return cerr
+	}
+	policy.Hash = run_hash(hasher, raw)
+	return nil
+}
+
+func (policy SignaturePolicy) hash_alg() algorithm_identifier {
+	return algorithm_identifier{Algorithm: str2oid_key(policy.HashAlgorithm)}
+}
+
+func has_oid(list []string, oid asn1.ObjectIdentifier) bool {
+	for _, item := range list {
+		if item == oid.String() {
+			return true
+		}
+	}
+	return false
+}
+
+type other_hash_alg_and_value struct {
+	RawContent    asn1.RawContent
+	HashAlgorithm algorithm_identifier
+	HashValue     []byte
+}
+
+type sig_policy_qualifier_info struct {
+	RawContent           asn1.RawContent
+	SigPolicyQualifierId asn1.ObjectIdentifier
+	SigQualifier         asn1.RawValue
+}
+
+// The signaturePolicyImplied choice (NULL) is not supported.
+type signature_policy_id struct {
+	RawContent          asn1.RawContent
+	SigPolicyId         asn1.ObjectIdentifier
+	SigPolicyHash       other_hash_alg_and_value
+	SigPolicyQualifiers []sig_policy_qualifier_info `asn1:"optional,omitempty"`
+}
+
+type issuer_serial struct {
+	RawContent   asn1.RawContent
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// The HashAlgorithm is omitted when it is SHA256. (see RFC 5035 Section 4)
+type ess_cert_id_v2 struct {
+	RawContent    asn1.RawContent
+	HashAlgorithm algorithm_identifier `asn1:"optional"`
+	CertHash      []byte
+	IssuerSerial  issuer_serial `asn1:"optional"`
+}
+
+type signing_certificate_v2 struct {
+	RawContent asn1.RawContent
+	Certs      []ess_cert_id_v2
+	Policies   []asn1.RawValue `asn1:"optional,omitempty"`
+}
+
+func (policy SignaturePolicy) to_attribute() (attribute, CodedError) {
+	attr := attribute{}
+	spi := signature_policy_id{}
+	spi.SigPolicyId = str2oid_key(policy.OID)
+	spi.SigPolicyHash.HashAlgorithm = policy.hash_alg()
+	spi.SigPolicyHash.HashValue = policy.Hash
+	if policy.URI != "" {
+		raw, err := asn1.MarshalWithParams(policy.URI, "ia5")
+		if err != nil {
+			merr := NewMultiError("failed to encode signature policy URI", ERR_FAILED_TO_ENCODE, nil, err)
+			merr.SetParam("policy.URI", policy.URI)
+			return attr, merr
+		}
+		qualifier := sig_policy_qualifier_info{SigPolicyQualifierId: idSpqEtsUri}
+		qualifier.SigQualifier.FullBytes = raw
+		spi.SigPolicyQualifiers = []sig_policy_qualifier_info{qualifier}
+	}
+	attr.Type = idAaEtsSigPolicyId
+	attr.Values = []interface{}{spi}
+	return attr, nil
+}
+
+// Possible errors are: ERR_PARSE_CERT
+func new_signing_certificate_v2_attr(cert *Certificate) (attribute, CodedError) {
+	attr := attribute{}
+	ias, cerr := cert.issuer_and_serial()
+	if cerr != nil {
+		return attr, cerr
+	}
+	hasher, _, _ := get_hasher(algorithm_identifier{Algorithm: idSha256})
+	cert_id := ess_cert_id_v2{}
+	cert_id.CertHash = run_hash(hasher, cert.base.RawContent)
+	cert_id.IssuerSerial.SerialNumber = cert.base.TBSCertificate.SerialNumber
+	// directoryName [4] Name
+	cert_id.IssuerSerial.Issuer = []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: ias.Issuer.FullBytes}}
+
+	attr.Type = idAaSigningCertificateV2
+	attr.Values = []interface{}{signing_certificate_v2{Certs: []ess_cert_id_v2{cert_id}}}
+	return attr, nil
+}
+
+// Returns the minimum key size for signatures made with the given algorithms. Since rsaEncryption does not name a digest algorithm, the size required for the RSA signature algorithm with the same digest algorithm is used for it.
+func (policy SignaturePolicy) min_key_size(sig_alg, digest_alg asn1.ObjectIdentifier) int {
+	if size, ok := policy.MinKeySizes[sig_alg.String()]; ok {
+		return size
+	}
+	if sig_alg.Equal(idRSAEncryption) {
+		for alg, digest := range signature_alg_digests {
+			if size, ok := policy.MinKeySizes[alg]; ok && digest.Equal(digest_alg) {
+				return size
+			}
+		}
+	}
+	return policy.MinKeySize
+}
+
+// Adds the signature policy identifier and the signing certificate V2 signed attributes.
+//
+// Possible errors are: ERR_POLICY_NO_HASH, ERR_POLICY_KEY_SIZE, ERR_FAILED_TO_ENCODE, ERR_PARSE_CERT
+func (si *signer_info_raw) set_policy_attrs(policy SignaturePolicy, pfx PFX) CodedError {
+	if policy.Hash == nil {
+		merr := NewMultiError("the signature policy document hash is missing", ERR_POLICY_NO_HASH, nil)
+		merr.SetParam("policy.OID", policy.OID)
+		return merr
+	}
+	if min_size := policy.min_key_size(si.SignatureAlgorithm.Algorithm, si.DigestAlgorithm.Algorithm); pfx.rsa_key.N.BitLen() < min_size {
+		merr := NewMultiError("the private key is too small for the signature policy", ERR_POLICY_KEY_SIZE, nil)
+		merr.SetParam("key-size", pfx.rsa_key.N.BitLen())
+		merr.SetParam("policy.MinKeySize", min_size)
+		return merr
+	}
+	attr, cerr := policy.to_attribute()
+	if cerr != nil {
+		return cerr
+	}
+	cert_attr, cerr := new_signing_certificate_v2_attr(pfx.Cert)
+	if cerr != nil {
+		return cerr
+	}
+	si.set_signed_attr(attr)
+	si.set_signed_attr(cert_attr)
+	return nil
+}
+
+// Returns every way in which this signature does not follow the rules (taken from policies) of the signature policy it claims to follow. Signatures without the signature policy identifier attribute are not checked. If the policy document hash is unknown, ERR_POLICY_NO_HASH is returned, as the signature may refer to any document. The signing time attribute is chosen by the signer, so the policy signing period and revocation date are checked against the earliest valid signature time stamp (or now, if there is none). The time stamps MUST have been checked before.
+func (sig Signature) check_policy(policies *PolicyStore, now time.Time) []CodedError {
+	errs := make([]CodedError, 0)
+	attr, ok := sig.base.get_signed_attr(idAaEtsSigPolicyId)
+	if !ok {
+		return errs
+	}
+	spi := signature_policy_id{}
+	if err := attr.first_value(&spi); err != nil {
+		merr := NewMultiError("failed to parse signature policy identifier attribute", ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("attr", attr)
+		return append(errs, merr)
+	}
+	policy, cerr := policies.Get(spi.SigPolicyId.String())
+	if cerr != nil {
+		return append(errs, cerr)
+	}
+
+	// Policy document
+	if policy.Hash == nil {
+		merr := NewMultiError("the signature policy document hash is unknown, so the policy document cannot be checked (see PolicyStore.LoadLPA)", ERR_POLICY_NO_HASH, nil)
+		merr.SetParam("policy.OID", policy.OID)
+		errs = append(errs, merr)
+	} else if !spi.SigPolicyHash.HashAlgorithm.Algorithm.Equal(policy.hash_alg().Algorithm) || !bytes.Equal(spi.SigPolicyHash.HashValue, policy.Hash) {
+		merr := NewMultiError("signature policy hash does not match", ERR_POLICY_HASH_MISMATCH, nil)
+		merr.SetParam("policy.OID", policy.OID)
+		errs = append(errs, merr)
+	}
+
+	// Signing period
+	signing_time := now
+	for _, ts := range sig.TimeStamps {
+		if ts.Status.IsValid() && ts.GenTime.Before(signing_time) {
+			signing_time = ts.GenTime
+		}
+	}
+	if !policy.NotBefore.IsZero() && signing_time.Before(policy.NotBefore) {
+		merr := NewMultiError("signature was made before the signature policy signing period", ERR_POLICY_NOT_BEFORE_DATE, nil)
+		merr.SetParam("signing-time", signing_time)
+		merr.SetParam("policy.NotBefore", policy.NotBefore)
+		errs = append(errs, merr)
+	}
+	if !policy.NotAfter.IsZero() && signing_time.After(policy.NotAfter) {
+		merr := NewMultiError("signature was made after the signature policy signing period", ERR_POLICY_NOT_AFTER_DATE, nil)
+		merr.SetParam("signing-time", signing_time)
+		merr.SetParam("policy.NotAfter", policy.NotAfter)
+		errs = append(errs, merr)
+	}
+	if !policy.RevocationDate.IsZero() && !signing_time.Before(policy.RevocationDate) {
+		merr := NewMultiError("signature was made after the signature policy was revoked", ERR_POLICY_REVOKED, nil)
+		merr.SetParam("signing-time", signing_time)
+		merr.SetParam("policy.RevocationDate", policy.RevocationDate)
+		errs = append(errs, merr)
+	}
+
+	// Attributes
+	for _, attr_type := range policy.MandatedSignedAttrs {
+		if _, ok := sig.base.get_signed_attr(str2oid_key(attr_type)); !ok {
+			merr := NewMultiError("mandatory signed attribute is missing", ERR_POLICY_MISSING_ATTR, nil)
+			merr.SetParam("attr", attr_type)
+			merr.SetParam("policy.OID", policy.OID)
+			errs = append(errs, merr)
+		}
+	}
+	for _, attr_type := range policy.MandatedUnsignedAttrs {
+		if _, ok := sig.get_unsigned_attr(str2oid_key(attr_type)); !ok {
+			merr := NewMultiError("mandatory unsigned attribute is missing", ERR_POLICY_MISSING_ATTR, nil)
+			merr.SetParam("attr", attr_type)
+			merr.SetParam("policy.OID", policy.OID)
+			errs = append(errs, merr)
+		}
+	}
+
+	// Algorithms
+	if len(policy.DigestAlgorithms) > 0 && !has_oid(policy.DigestAlgorithms, sig.base.DigestAlgorithm.Algorithm) {
+		merr := NewMultiError("digest algorithm not allowed by the signature policy", ERR_POLICY_ALGORITHM, nil)
+		merr.SetParam("alg", sig.base.DigestAlgorithm.Algorithm.String())
+		merr.SetParam("policy.OID", policy.OID)
+		errs = append(errs, merr)
+	}
+	if len(policy.SignatureAlgorithms) > 0 && !has_oid(policy.SignatureAlgorithms, sig.base.SignatureAlgorithm.Algorithm) {
+		merr := NewMultiError("signature algorithm not allowed by the signature policy", ERR_POLICY_ALGORITHM, nil)
+		merr.SetParam("alg", sig.base.SignatureAlgorithm.Algorithm.String())
+		merr.SetParam("policy.OID", policy.OID)
+		errs = append(errs, merr)
+	}
+
+	// The rest depends on the signer certificate
+	if sig.Signer.base.TBSCertificate.SerialNumber == nil {
+		return errs
+	}
+	pubkey, err := sig.Signer.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+	min_size := policy.min_key_size(sig.base.SignatureAlgorithm.Algorithm, sig.base.DigestAlgorithm.Algorithm)
+	if err == nil && pubkey.N.BitLen() < min_size {
+		merr := NewMultiError("signer key is too small for the signature policy", ERR_POLICY_KEY_SIZE, nil)
+		merr.SetParam("key-size", pubkey.N.BitLen())
+		merr.SetParam("policy.MinKeySize", min_size)
+		errs = append(errs, merr)
+	}
+	if cerr := sig.check_signing_cert(); cerr != nil {
+		errs = append(errs, cerr)
+	}
+	return errs
+}
+
+// Checks whether the signing certificate V2 attribute (if present) refers to the signer certificate.
+func (sig Signature) check_signing_cert() CodedError {
+	attr, ok := sig.base.get_signed_attr(idAaSigningCertificateV2)
+	if !ok {
+		return nil
+	}
+	signing_cert := signing_certificate_v2{}
+	if err := attr.first_value(&signing_cert); err != nil || len(signing_cert.Certs) == 0 {
+		merr := NewMultiError("failed to parse signing certificate V2 attribute", ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("attr", attr)
+		return merr
+	}
+	cert_id := signing_cert.Certs[0]
+	alg_id := cert_id.HashAlgorithm
+	if len(alg_id.Algorithm) == 0 {
+		alg_id.Algorithm = idSha256
+	}
+	hasher, _, cerr := get_hasher(alg_id)
+	if cerr != nil {
+		return cerr
+	}
+	if !bytes.Equal(cert_id.CertHash, run_hash(hasher, sig.Signer.base.RawContent)) {
+		merr := NewMultiError("signing certificate V2 attribute does not match the signer certificate", ERR_SIGNING_CERT_MISMATCH, nil)
+		merr.SetParam("Signer.Subject", sig.Signer.Subject)
+		return merr
+	}
+	return nil
+}
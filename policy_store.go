@@ -0,0 +1,437 @@
+package libICP
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// Maps signature policy OIDs to their rules. The rules may come from the built-in ICP-Brasil policies, from a "Lista de Políticas de Assinatura" (LPA) and from the policy documents themselves, so policy revisions can be followed without code changes.
+//
+// The LPA and the policy documents are NOT verified against their signatures, so they MUST come from a trusted source.
+type PolicyStore struct {
+	next_update time.Time
+	lock        sync.RWMutex
+	policies    map[string]SignaturePolicy
+}
+
+// Returns a store with the rules of the built-in ICP-Brasil policies.
+func NewPolicyStore() *PolicyStore {
+	store := &PolicyStore{policies: make(map[string]SignaturePolicy)}
+	for oid, policy := range known_policies {
+		store.policies[oid] = policy
+	}
+	return store
+}
+
+// Returns a copy of the rules of the given policy.
+//
+// Possible errors are: ERR_POLICY_UNKNOWN
+func (store *PolicyStore) Get(oid string) (*SignaturePolicy, CodedError) {
+	store.lock.RLock()
+	policy, ok := store.policies[oid]
+	store.lock.RUnlock()
+	if !ok {
+		merr := NewMultiError("unknown signature policy", ERR_POLICY_UNKNOWN, nil)
+		merr.SetParam("oid", oid)
+		return nil, merr
+	}
+	return &policy, nil
+}
+
+// Returns when a new LPA should be loaded. It is zero if no LPA was loaded.
+func (store *PolicyStore) NextUpdate() time.Time {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.next_update
+}
+
+// Adds (or replaces) the rules of a policy.
+func (store *PolicyStore) Add(policy SignaturePolicy) {
+	store.lock.Lock()
+	store.policies[policy.OID] = policy
+	store.lock.Unlock()
+}
+
+// Same as LoadLPA but reads it from a file.
+func (store *PolicyStore) LoadLPAFromFile(path string) CodedError {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read LPA file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return merr
+	}
+	return store.LoadLPA(dat)
+}
+
+// Loads a "Lista de Políticas de Assinatura" (see DOC-ICP-15.03), either DER (versions 1 and 2) or XML encoded. For each listed policy, the URI, the document hash, the signing period and the revocation date are updated. Policies unknown to this store are added without any other rules until their documents are loaded with LoadPolicyDocument.
+//
+// Possible errors are: ERR_PARSE_POLICY
+func (store *PolicyStore) LoadLPA(raw []byte) CodedError {
+	var entries []SignaturePolicy
+	var next_update time.Time
+	var cerr CodedError
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '<' {
+		entries, next_update, cerr = parse_lpa_xml(raw)
+	} else {
+		entries, next_update, cerr = parse_lpa_der(raw)
+	}
+	if cerr != nil {
+		return cerr
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, entry := range entries {
+		policy, ok := store.policies[entry.OID]
+		if !ok {
+			policy = SignaturePolicy{OID: entry.OID, Name: entry.Name}
+		}
+		if entry.URI != "" {
+			policy.URI = entry.URI
+		}
+		policy.HashAlgorithm = entry.HashAlgorithm
+		policy.Hash = entry.Hash
+		policy.NotBefore = entry.NotBefore
+		policy.NotAfter = entry.NotAfter
+		policy.RevocationDate = entry.RevocationDate
+		store.policies[entry.OID] = policy
+	}
+	store.next_update = next_update
+	return nil
+}
+
+type lpa_signing_period struct {
+	RawContent asn1.RawContent
+	NotBefore  time.Time `asn1:"generalized"`
+	NotAfter   time.Time `asn1:"generalized,optional"`
+}
+
+// The version is only present on LPA v2.
+type lpa_raw struct {
+	RawContent  asn1.RawContent
+	Version     int `asn1:"optional"`
+	PolicyInfos []asn1.RawValue
+	NextUpdate  time.Time `asn1:"generalized"`
+}
+
+type lpa_policy_info_v1 struct {
+	RawContent     asn1.RawContent
+	SigningPeriod  lpa_signing_period
+	RevocationDate time.Time `asn1:"generalized,optional"`
+	PolicyOID      asn1.ObjectIdentifier
+	PolicyURI      string `asn1:"ia5"`
+	PolicyDigest   other_hash_alg_and_value
+}
+
+type lpa_policy_info_v2 struct {
+	RawContent         asn1.RawContent
+	PolicyName         string
+	FieldOfApplication string
+	SigningPeriod      lpa_signing_period
+	RevocationDate     time.Time `asn1:"generalized,optional"`
+	PolicyOID          asn1.ObjectIdentifier
+	PolicyURI          string `asn1:"ia5"`
+	PolicyDigest       other_hash_alg_and_value
+}
+
+func new_lpa_entry(name string, period lpa_signing_period, revocation_date time.Time, oid asn1.ObjectIdentifier, uri string, digest other_hash_alg_and_value) SignaturePolicy {
+	if name == "" {
+		name = oid.String()
+	}
+	return SignaturePolicy{
+		Name:           name,
+		OID:            oid.String(),
+		URI:            uri,
+		HashAlgorithm:  digest.HashAlgorithm.Algorithm.String(),
+		Hash:           digest.HashValue,
+		NotBefore:      period.NotBefore,
+		NotAfter:       period.NotAfter,
+		RevocationDate: revocation_date,
+	}
+}
+
+func parse_lpa_der(raw []byte) ([]SignaturePolicy, time.Time, CodedError) {
+	lpa := lpa_raw{}
+	if _, err := asn1.Unmarshal(raw, &lpa); err != nil {
+		return nil, time.Time{}, NewMultiError("failed to parse LPA", ERR_PARSE_POLICY, nil, err)
+	}
+	ans := make([]SignaturePolicy, len(lpa.PolicyInfos))
+	for i, info_raw := range lpa.PolicyInfos {
+		var err error
+		if lpa.Version == 0 {
+			info := lpa_policy_info_v1{}
+			_, err = asn1.Unmarshal(info_raw.FullBytes, &info)
+			ans[i] = new_lpa_entry("", info.SigningPeriod, info.RevocationDate, info.PolicyOID, info.PolicyURI, info.PolicyDigest)
+		} else {
+			info := lpa_policy_info_v2{}
+			_, err = asn1.Unmarshal(info_raw.FullBytes, &info)
+			ans[i] = new_lpa_entry(info.PolicyName, info.SigningPeriod, info.RevocationDate, info.PolicyOID, info.PolicyURI, info.PolicyDigest)
+		}
+		if err != nil {
+			merr := NewMultiError("failed to parse LPA policy info", ERR_PARSE_POLICY, nil, err)
+			merr.SetParam("index", i)
+			return nil, time.Time{}, merr
+		}
+	}
+	return ans, lpa.NextUpdate, nil
+}
+
+var xml_digest_algs = map[string]asn1.ObjectIdentifier{
+	"http://www.w3.org/2000/09/xmldsig#sha1":        idSha1,
+	"http://www.w3.org/2001/04/xmlenc#sha256":       idSha256,
+	"http://www.w3.org/2001/04/xmldsig-more#sha384": idSha384,
+	"http://www.w3.org/2001/04/xmlenc#sha512":       idSha512,
+}
+
+// Elements are matched by their local names, so namespace prefixes do not matter.
+type lpa_xml struct {
+	Version     int `xml:"Version"`
+	PolicyInfos []struct {
+		PolicyName string `xml:"PolicyName"`
+		NotBefore  string `xml:"SigningPeriod>NotBefore"`
+		NotAfter   string `xml:"SigningPeriod>NotAfter"`
+		// Either a dotted OID or an "urn:oid:" URN
+		Identifier     string `xml:"Policy>Identifier"`
+		RevocationDate string `xml:"RevocationDate"`
+		PolicyURI      string `xml:"PolicyURI"`
+		DigestMethod   struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"PolicyDigest>DigestMethod"`
+		DigestValue string `xml:"PolicyDigest>DigestValue"`
+	} `xml:"PolicyInfo"`
+	NextUpdate string `xml:"NextUpdate"`
+}
+
+func parse_xml_time(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parse_lpa_xml(raw []byte) ([]SignaturePolicy, time.Time, CodedError) {
+	lpa := lpa_xml{}
+	if err := xml.Unmarshal(raw, &lpa); err != nil {
+		return nil, time.Time{}, NewMultiError("failed to parse LPA", ERR_PARSE_POLICY, nil, err)
+	}
+	next_update, err := parse_xml_time(lpa.NextUpdate)
+	if err != nil {
+		return nil, time.Time{}, NewMultiError("failed to parse LPA next update", ERR_PARSE_POLICY, nil, err)
+	}
+
+	ans := make([]SignaturePolicy, len(lpa.PolicyInfos))
+	for i, info := range lpa.PolicyInfos {
+		fail := func(msg string, errs ...interface{}) CodedError {
+			merr := NewMultiError(msg, ERR_PARSE_POLICY, nil, errs...)
+			merr.SetParam("index", i)
+			return merr
+		}
+		policy := SignaturePolicy{Name: info.PolicyName, URI: strings.TrimSpace(info.PolicyURI)}
+		oid := str2oid_key(strings.TrimPrefix(strings.TrimSpace(info.Identifier), "urn:oid:"))
+		if len(oid) == 0 {
+			return nil, time.Time{}, fail("invalid LPA policy identifier")
+		}
+		policy.OID = oid.String()
+		if policy.Name == "" {
+			policy.Name = policy.OID
+		}
+		alg, ok := xml_digest_algs[info.DigestMethod.Algorithm]
+		if !ok {
+			return nil, time.Time{}, fail("unknown LPA policy digest algorithm")
+		}
+		policy.HashAlgorithm = alg.String()
+		if policy.Hash, err = base64.StdEncoding.DecodeString(strings.TrimSpace(info.DigestValue)); err != nil {
+			return nil, time.Time{}, fail("failed to parse LPA policy digest", err)
+		}
+		if policy.NotBefore, err = parse_xml_time(info.NotBefore); err != nil {
+			return nil, time.Time{}, fail("failed to parse LPA signing period", err)
+		}
+		if policy.NotAfter, err = parse_xml_time(info.NotAfter); err != nil {
+			return nil, time.Time{}, fail("failed to parse LPA signing period", err)
+		}
+		if policy.RevocationDate, err = parse_xml_time(info.RevocationDate); err != nil {
+			return nil, time.Time{}, fail("failed to parse LPA revocation date", err)
+		}
+		ans[i] = policy
+	}
+	return ans, next_update, nil
+}
+
+// Signature policy document (see RFC 3125 and ETSI TR 102 272). Only the fields used as rules are decoded.
+type signature_policy_doc struct {
+	RawContent        asn1.RawContent
+	SignPolicyHashAlg algorithm_identifier
+	SignPolicyInfo    sign_policy_info
+	SignPolicyHash    []byte `asn1:"optional"`
+}
+
+type sign_policy_info struct {
+	RawContent                asn1.RawContent
+	SignPolicyIdentifier      asn1.ObjectIdentifier
+	DateOfIssue               time.Time `asn1:"generalized"`
+	PolicyIssuerName          asn1.RawValue
+	FieldOfApplication        asn1.RawValue
+	SignatureValidationPolicy signature_validation_policy
+}
+
+type signature_validation_policy struct {
+	RawContent    asn1.RawContent
+	SigningPeriod lpa_signing_period
+	CommonRules   common_rules
+}
+
+type common_rules struct {
+	RawContent                asn1.RawContent
+	SignerAndVeriferRules     signer_and_verifier_rules `asn1:"explicit,tag:0,optional"`
+	SigningCertTrustCondition asn1.RawValue             `asn1:"explicit,tag:1,optional"`
+	TimeStampTrustCondition   asn1.RawValue             `asn1:"explicit,tag:2,optional"`
+	AttributeTrustCondition   asn1.RawValue             `asn1:"explicit,tag:3,optional"`
+	AlgorithmConstraintSet    algorithm_constraint_set  `asn1:"explicit,tag:4,optional"`
+}
+
+type signer_and_verifier_rules struct {
+	RawContent  asn1.RawContent
+	SignerRules signer_rules
+}
+
+type signer_rules struct {
+	RawContent           asn1.RawContent
+	ExternalSignedData   bool `asn1:"optional"`
+	MandatedSignedAttr   []asn1.ObjectIdentifier
+	MandatedUnsignedAttr []asn1.ObjectIdentifier
+}
+
+type algorithm_constraint_set struct {
+	RawContent                 asn1.RawContent
+	SignerAlgorithmConstraints []alg_and_length `asn1:"explicit,tag:0,optional"`
+}
+
+type alg_and_length struct {
+	RawContent   asn1.RawContent
+	AlgID        asn1.ObjectIdentifier
+	MinKeyLength int `asn1:"optional"`
+}
+
+// Digest algorithm of each known signature algorithm.
+var signature_alg_digests = map[string]asn1.ObjectIdentifier{
+	idSha1WithRSAEncryption.String():   idSha1,
+	idSha256WithRSAEncryption.String(): idSha256,
+	idSha384WithRSAEncryption.String(): idSha384,
+	idSha512WithRSAEncryption.String(): idSha512,
+}
+
+func append_oid(list []string, oid asn1.ObjectIdentifier) []string {
+	if has_oid(list, oid) {
+		return list
+	}
+	return append(list, oid.String())
+}
+
+// Sets the algorithm rules from the signer algorithm constraints. Since CMS signatures are often made with rsaEncryption as the signature algorithm (see RFC 3370 Section 3.2), it is allowed whenever a RSA signature algorithm is. Each minimum key size only applies to its own algorithm, and the largest one is used for algorithms without a constraint.
+func (policy *SignaturePolicy) set_algorithm_constraints(constraints []alg_and_length) {
+	policy.DigestAlgorithms = nil
+	policy.SignatureAlgorithms = nil
+	policy.MinKeySize = 0
+	policy.MinKeySizes = nil
+	for _, constraint := range constraints {
+		if digest, ok := signature_alg_digests[constraint.AlgID.String()]; ok {
+			policy.SignatureAlgorithms = append_oid(policy.SignatureAlgorithms, constraint.AlgID)
+			policy.SignatureAlgorithms = append_oid(policy.SignatureAlgorithms, idRSAEncryption)
+			policy.DigestAlgorithms = append_oid(policy.DigestAlgorithms, digest)
+		} else if _, _, cerr := get_hasher(algorithm_identifier{Algorithm: constraint.AlgID}); cerr == nil {
+			policy.DigestAlgorithms = append_oid(policy.DigestAlgorithms, constraint.AlgID)
+		} else {
+			policy.SignatureAlgorithms = append_oid(policy.SignatureAlgorithms, constraint.AlgID)
+		}
+		if constraint.MinKeyLength > 0 {
+			if policy.MinKeySizes == nil {
+				policy.MinKeySizes = make(map[string]int)
+			}
+			policy.MinKeySizes[constraint.AlgID.String()] = constraint.MinKeyLength
+		}
+		if constraint.MinKeyLength > policy.MinKeySize {
+			policy.MinKeySize = constraint.MinKeyLength
+		}
+	}
+}
+
+// Same as LoadPolicyDocument but reads it from a file.
+func (store *PolicyStore) LoadPolicyDocumentFromFile(path string) (*SignaturePolicy, CodedError) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		merr := NewMultiError("failed to read signature policy file", ERR_READ_FILE, nil, err)
+		merr.SetParam("path", path)
+		return nil, merr
+	}
+	return store.LoadPolicyDocument(dat)
+}
+
+// Loads the rules from a DER encoded signature policy document (see RFC 3125), such as the ones linked by the LPA, replacing the previous rules of that policy. If the document hash is already known (from the LPA, for example) it MUST match. The signing period of the LPA, if any, takes precedence over the one of the document.
+//
+// Possible errors are: ERR_PARSE_POLICY, ERR_POLICY_HASH_MISMATCH, ERR_UNKOWN_ALGORITHM
+func (store *PolicyStore) LoadPolicyDocument(raw []byte) (*SignaturePolicy, CodedError) {
+	doc := signature_policy_doc{}
+	if _, err := asn1.Unmarshal(raw, &doc); err != nil {
+		return nil, NewMultiError("failed to parse signature policy", ERR_PARSE_POLICY, nil, err)
+	}
+	info := doc.SignPolicyInfo
+	oid := info.SignPolicyIdentifier.String()
+
+	// The optional hash over the policy info
+	if len(doc.SignPolicyHash) > 0 {
+		hasher, _, cerr := get_hasher(doc.SignPolicyHashAlg)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if !bytes.Equal(doc.SignPolicyHash, run_hash(hasher, info.RawContent)) {
+			merr := NewMultiError("signature policy hash does not match its contents", ERR_POLICY_HASH_MISMATCH, nil)
+			merr.SetParam("policy.OID", oid)
+			return nil, merr
+		}
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	policy, ok := store.policies[oid]
+	if !ok {
+		policy = SignaturePolicy{Name: oid, OID: oid}
+	}
+	if policy.HashAlgorithm == "" {
+		policy.HashAlgorithm = idSha256.String()
+	}
+	expected := policy.Hash
+	if cerr := policy.SetDocument(raw); cerr != nil {
+		return nil, cerr
+	}
+	if expected != nil && !bytes.Equal(expected, policy.Hash) {
+		merr := NewMultiError("signature policy document does not match its known hash", ERR_POLICY_HASH_MISMATCH, nil)
+		merr.SetParam("policy.OID", oid)
+		return nil, merr
+	}
+
+	// Rules
+	signer_rules := info.SignatureValidationPolicy.CommonRules.SignerAndVeriferRules.SignerRules
+	policy.MandatedSignedAttrs = make([]string, len(signer_rules.MandatedSignedAttr))
+	for i, attr_type := range signer_rules.MandatedSignedAttr {
+		policy.MandatedSignedAttrs[i] = attr_type.String()
+	}
+	policy.MandatedUnsignedAttrs = make([]string, len(signer_rules.MandatedUnsignedAttr))
+	for i, attr_type := range signer_rules.MandatedUnsignedAttr {
+		policy.MandatedUnsignedAttrs[i] = attr_type.String()
+	}
+	policy.set_algorithm_constraints(info.SignatureValidationPolicy.CommonRules.AlgorithmConstraintSet.SignerAlgorithmConstraints)
+	if policy.NotBefore.IsZero() && policy.NotAfter.IsZero() {
+		policy.NotBefore = info.SignatureValidationPolicy.SigningPeriod.NotBefore
+		policy.NotAfter = info.SignatureValidationPolicy.SigningPeriod.NotAfter
+	}
+	store.policies[oid] = policy
+	return &policy, nil
+}
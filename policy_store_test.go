@@ -0,0 +1,187 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const POLICY_AD_RT_V2_3 = "2.16.76.1.7.1.2.2.3"
+
+func get_test_policy_store(t *testing.T) *PolicyStore {
+	store := NewPolicyStore()
+	require.Nil(t, store.LoadLPAFromFile("data/test-policies/LPA_CAdES.der"))
+	_, cerr := store.LoadPolicyDocumentFromFile("data/test-policies/PA_AD_RT_v2_3.der")
+	require.Nil(t, cerr)
+	return store
+}
+
+func Test_PolicyStore_LoadLPA_1(t *testing.T) {
+	store := NewPolicyStore()
+	require.Nil(t, store.LoadLPAFromFile("data/test-policies/LPA_CAdES.der"))
+	assert.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), store.NextUpdate())
+
+	// Known policies keep their rules
+	policy, cerr := store.Get(POLICY_AD_RB_V2_3)
+	require.Nil(t, cerr)
+	assert.Equal(t, "AD-RB v2.3", policy.Name)
+	assert.Equal(t, get_test_policy(t).Hash, policy.Hash)
+	assert.Equal(t, 2048, policy.MinKeySize)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), policy.NotAfter)
+	assert.True(t, policy.RevocationDate.IsZero())
+
+	policy, cerr = store.Get(POLICY_AD_RB_V2_1)
+	require.Nil(t, cerr)
+	assert.Equal(t, time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), policy.RevocationDate)
+
+	// New policies have no rules until their documents are loaded
+	policy, cerr = store.Get(POLICY_AD_RT_V2_3)
+	require.Nil(t, cerr)
+	assert.Equal(t, "PA_AD_RT_v2_3", policy.Name)
+	assert.Equal(t, "http://politicas.icpbrasil.gov.br/PA_AD_RT_v2_3.der", policy.URI)
+	assert.Equal(t, 0, len(policy.MandatedSignedAttrs))
+}
+
+func Test_PolicyStore_LoadLPA_2(t *testing.T) {
+	// The XML variant has the same contents
+	der_store := NewPolicyStore()
+	require.Nil(t, der_store.LoadLPAFromFile("data/test-policies/LPA_CAdES.der"))
+	xml_store := NewPolicyStore()
+	require.Nil(t, xml_store.LoadLPAFromFile("data/test-policies/LPA_CAdES.xml"))
+	assert.Equal(t, der_store.NextUpdate(), xml_store.NextUpdate())
+	for _, oid := range []string{POLICY_AD_RB_V2_1, POLICY_AD_RB_V2_3, POLICY_AD_RT_V2_3} {
+		der_policy, cerr := der_store.Get(oid)
+		require.Nil(t, cerr)
+		xml_policy, cerr := xml_store.Get(oid)
+		require.Nil(t, cerr)
+		assert.Equal(t, der_policy, xml_policy)
+	}
+}
+
+func Test_PolicyStore_LoadLPA_3(t *testing.T) {
+	store := NewPolicyStore()
+	cerr := store.LoadLPA([]byte{0x30, 0x03, 0x02, 0x01})
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_POLICY, cerr.Code())
+	cerr = store.LoadLPA([]byte("<LPA><PolicyInfo><Policy><Identifier>foo</Identifier></Policy></PolicyInfo></LPA>"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_POLICY, cerr.Code())
+}
+
+func Test_PolicyStore_LoadPolicyDocument_1(t *testing.T) {
+	store := get_test_policy_store(t)
+	policy, cerr := store.Get(POLICY_AD_RT_V2_3)
+	require.Nil(t, cerr)
+	assert.Equal(t, []string{idContentType.String(), idMessageDigest.String(), idAaEtsSigPolicyId.String(), idAaSigningCertificateV2.String()}, policy.MandatedSignedAttrs)
+	assert.Equal(t, []string{idAaSignatureTimeStampToken.String()}, policy.MandatedUnsignedAttrs)
+	assert.Equal(t, []string{idSha256WithRSAEncryption.String(), idRSAEncryption.String(), idSha512WithRSAEncryption.String()}, policy.SignatureAlgorithms)
+	assert.Equal(t, []string{idSha256.String(), idSha512.String()}, policy.DigestAlgorithms)
+	assert.Equal(t, 2048, policy.min_key_size(idSha256WithRSAEncryption, idSha256))
+	assert.Equal(t, 2048, policy.min_key_size(idRSAEncryption, idSha256))
+	assert.Equal(t, 4096, policy.min_key_size(idSha512WithRSAEncryption, idSha512))
+	assert.Equal(t, 4096, policy.min_key_size(idRSAEncryption, idSha512))
+	assert.Equal(t, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), policy.NotBefore)
+	assert.NotNil(t, policy.Hash)
+}
+
+func Test_PolicyStore_LoadPolicyDocument_2(t *testing.T) {
+	// The document does not match the hash on the LPA
+	store := NewPolicyStore()
+	store.Add(SignaturePolicy{OID: POLICY_AD_RT_V2_3, HashAlgorithm: idSha256.String(), Hash: make([]byte, 32)})
+	_, cerr := store.LoadPolicyDocumentFromFile("data/test-policies/PA_AD_RT_v2_3.der")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_POLICY_HASH_MISMATCH, cerr.Code())
+	policy, _ := store.Get(POLICY_AD_RT_V2_3)
+	assert.Equal(t, 0, len(policy.MandatedSignedAttrs))
+}
+
+func Test_SignaturePolicy_SetAlgorithmConstraints_1(t *testing.T) {
+	// Each key size constraint only applies to its own algorithm
+	policy := SignaturePolicy{}
+	policy.set_algorithm_constraints([]alg_and_length{
+		{AlgID: idSha256WithRSAEncryption, MinKeyLength: 2048},
+		{AlgID: idSha1WithRSAEncryption, MinKeyLength: 1024},
+		{AlgID: idSha512WithRSAEncryption, MinKeyLength: 4096},
+		{AlgID: idSha384WithRSAEncryption},
+	})
+	assert.Equal(t, []string{idSha256.String(), idSha1.String(), idSha512.String(), idSha384.String()}, policy.DigestAlgorithms)
+	assert.Equal(t, 2048, policy.min_key_size(idSha256WithRSAEncryption, idSha256))
+	assert.Equal(t, 1024, policy.min_key_size(idRSAEncryption, idSha1))
+	assert.Equal(t, 4096, policy.min_key_size(idRSAEncryption, idSha512))
+	// The weakest one is never used for algorithms without a constraint
+	assert.Equal(t, 4096, policy.min_key_size(idSha384WithRSAEncryption, idSha384))
+	assert.Equal(t, 4096, policy.min_key_size(idRSAEncryption, idSha384))
+}
+
+func Test_Signature_CheckPolicy_4(t *testing.T) {
+	// Revoked policy whose signing period is over
+	pfx := get_ciclano_pfx(t)
+	policy, cerr := NewSignaturePolicy(POLICY_AD_RB_V2_1)
+	require.Nil(t, cerr)
+	require.Nil(t, policy.SetDocument([]byte("fake policy document")))
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, policy)
+	require.Nil(t, cerr)
+
+	sig := msig.Signatures[0]
+	policies := NewPolicyStore()
+	policies.Add(*policy)
+	assert.Equal(t, 0, len(sig.check_policy(policies, time.Now())))
+	// Without the LPA, the policy document hash is unknown
+	errs := sig.check_policy(NewPolicyStore(), time.Now())
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_POLICY_NO_HASH, errs[0].Code())
+
+	errs = sig.check_policy(get_test_policy_store(t), time.Now())
+	codes := make([]ErrorCode, len(errs))
+	for i := range errs {
+		codes[i] = errs[i].Code()
+	}
+	assert.Equal(t, []ErrorCode{ERR_POLICY_NOT_AFTER_DATE, ERR_POLICY_REVOKED}, codes)
+}
+
+func Test_Signature_CheckPolicy_5(t *testing.T) {
+	// The AD-RT rules come from the policy document
+	policies := get_test_policy_store(t)
+	policy, cerr := policies.Get(POLICY_AD_RT_V2_3)
+	require.Nil(t, cerr)
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, policy)
+	require.Nil(t, cerr)
+
+	store := get_test_store(t, true)
+	store.Policies = policies
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.check_all_at(store, now))
+	errs := msig.Signatures[0].Status.PolicyErrors
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_POLICY_MISSING_ATTR, errs[0].Code())
+
+	tsa := new_test_tsa(t)
+	require.Nil(t, msig.AddTimeStamp(&msig.Signatures[0], tsa.client()))
+	require.Nil(t, msig.check_all_at(store, now))
+	assert.True(t, msig.Signatures[0].Status.IsPolicyCompliant(), "%v", msig.Signatures[0].Status.PolicyErrors)
+}
+
+func Test_Signature_CheckPolicy_6(t *testing.T) {
+	// Only a valid time stamp can prove that a signature was made before the policy was revoked
+	pfx := get_ciclano_pfx(t)
+	policy, cerr := NewSignaturePolicy(POLICY_AD_RB_V2_1)
+	require.Nil(t, cerr)
+	require.Nil(t, policy.SetDocument([]byte("fake policy document")))
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, policy)
+	require.Nil(t, cerr)
+	store := get_test_policy_store(t)
+	long_ago := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sig := msig.Signatures[0]
+	sig.SigningTime = long_ago
+	assert.Equal(t, 2, len(sig.check_policy(store, time.Now())))
+
+	sig.TimeStamps = []TimeStamp{{GenTime: long_ago}}
+	assert.Equal(t, 2, len(sig.check_policy(store, time.Now())))
+
+	sig.TimeStamps[0].Status = TimeStampCheck{Integrity: true, ImprintMatches: true}
+	assert.Equal(t, 0, len(sig.check_policy(store, time.Now())))
+}
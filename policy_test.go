@@ -0,0 +1,148 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get_test_policy(t *testing.T) *SignaturePolicy {
+	policy, cerr := NewSignaturePolicy(POLICY_AD_RB_V2_3)
+	require.Nil(t, cerr)
+	require.Nil(t, policy.SetDocument([]byte("fake policy document")))
+	return policy
+}
+
+// Returns the known policies with the hash of the test policy document, as an LPA would.
+func get_test_policies(t *testing.T) *PolicyStore {
+	store := NewPolicyStore()
+	store.Add(*get_test_policy(t))
+	return store
+}
+
+func Test_NewSignaturePolicy_1(t *testing.T) {
+	policy, cerr := NewSignaturePolicy(POLICY_AD_RB_V2_3)
+	require.Nil(t, cerr)
+	assert.Equal(t, "AD-RB v2.3", policy.Name)
+	assert.Equal(t, "http://politicas.icpbrasil.gov.br/PA_AD_RB_v2_3.der", policy.URI)
+	assert.Equal(t, 2048, policy.MinKeySize)
+	assert.Nil(t, policy.Hash)
+
+	// Changing the returned policy must not change the known ones
+	policy.MinKeySize = 1
+	policy, _ = NewSignaturePolicy(POLICY_AD_RB_V2_3)
+	assert.Equal(t, 2048, policy.MinKeySize)
+}
+
+func Test_NewSignaturePolicy_2(t *testing.T) {
+	_, cerr := NewSignaturePolicy("1.2.3.4")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_POLICY_UNKNOWN, cerr.Code())
+}
+
+func Test_SignaturePolicy_SetDocument_1(t *testing.T) {
+	policy := get_test_policy(t)
+	assert.Equal(t, "23890D922F88BD878C45E12EFB36BC218EC94C7CCAB7661A0472678263E8A2F0", to_hex(policy.Hash))
+}
+
+func Test_PFX_SignBytesWithPolicy_1(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	policy, cerr := NewSignaturePolicy(POLICY_AD_RB_V2_3)
+	require.Nil(t, cerr)
+	_, cerr = pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, policy)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_POLICY_NO_HASH, cerr.Code())
+}
+
+func Test_PFX_SignBytesWithPolicy_2(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	policy := get_test_policy(t)
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, policy)
+	require.Nil(t, cerr)
+	require.Equal(t, 1, len(msig.Signatures))
+	assert.Equal(t, POLICY_AD_RB_V2_3, msig.Signatures[0].PolicyID)
+	assert.Equal(t, 5, len(msig.Signatures[0].base.SignedAttrs))
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	assert.Equal(t, POLICY_AD_RB_V2_3, msig.Signatures[0].PolicyID)
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	assert.Nil(t, status.SignerCertError)
+	assert.True(t, status.IsPolicyCompliant(), "%v", status.PolicyErrors)
+}
+
+func Test_MultSignature_CoSignWithPolicy_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+	require.Nil(t, msig.CoSignWithPolicy(pfx, get_test_policy(t)))
+	require.Equal(t, 2, len(msig.Signatures))
+	assert.Equal(t, "", msig.Signatures[0].PolicyID)
+	assert.Equal(t, POLICY_AD_RB_V2_3, msig.Signatures[1].PolicyID)
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	// Signatures without a policy are not checked against one
+	assert.True(t, msig.Signatures[0].Status.IsPolicyCompliant())
+	assert.True(t, msig.Signatures[1].Status.IsPolicyCompliant(), "%v", msig.Signatures[1].Status.PolicyErrors)
+}
+
+func Test_Signature_CheckPolicy_1(t *testing.T) {
+	// Only the policy attribute and a weak digest algorithm
+	policy := get_test_policy(t)
+	attr, cerr := policy.to_attribute()
+	require.Nil(t, cerr)
+	sig := Signature{}
+	sig.base.DigestAlgorithm = algorithm_identifier{Algorithm: idSha1}
+	sig.base.SignatureAlgorithm = algorithm_identifier{Algorithm: idSha1WithRSAEncryption}
+	sig.base.set_signed_attr(attr)
+
+	errs := sig.check_policy(get_test_policies(t), time.Now())
+	codes := make([]ErrorCode, len(errs))
+	for i := range errs {
+		codes[i] = errs[i].Code()
+	}
+	assert.Equal(t, []ErrorCode{ERR_POLICY_MISSING_ATTR, ERR_POLICY_MISSING_ATTR, ERR_POLICY_MISSING_ATTR, ERR_POLICY_ALGORITHM, ERR_POLICY_ALGORITHM}, codes)
+}
+
+func Test_Signature_CheckPolicy_2(t *testing.T) {
+	// Signing certificate does not match
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, get_test_policy(t))
+	require.Nil(t, cerr)
+	other, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/deltrano.p12", "deltrano")
+	require.Nil(t, cerr)
+
+	sig := msig.Signatures[0]
+	assert.Equal(t, 0, len(sig.check_policy(get_test_policies(t), time.Now())))
+	sig.Signer = *other.Cert
+	errs := sig.check_policy(get_test_policies(t), time.Now())
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_SIGNING_CERT_MISMATCH, errs[0].Code())
+}
+
+func Test_Signature_CheckPolicy_3(t *testing.T) {
+	// Unknown policy
+	policy := get_test_policy(t)
+	policy.OID = "1.2.3.4"
+	attr, cerr := policy.to_attribute()
+	require.Nil(t, cerr)
+	sig := Signature{}
+	sig.base.set_signed_attr(attr)
+
+	errs := sig.check_policy(NewPolicyStore(), time.Now())
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_POLICY_UNKNOWN, errs[0].Code())
+}
@@ -0,0 +1,318 @@
+package libICP
+
+// Inputs of the certificate policy processing done during path validation. (see RFC 5280 Section 6.1.1) The zero value accepts certificates issued under any policy, or under none.
+type CertPolicyParams struct {
+	// OIDs of the acceptable policies (ex: "2.16.76.1.2.3.10"). If empty, any policy is acceptable. As in RFC 5280, this alone does NOT reject certificates issued under other policies: they are only pruned from the valid policy tree, which is accepted even if it ends up empty. Set RequireExplicitPolicy too so the path is rejected unless it is valid for one of these policies.
+	InitialPolicySet []string
+	// If true, the path MUST be valid for at least one of the acceptable policies (or for any policy, if InitialPolicySet is empty).
+	RequireExplicitPolicy bool
+	InhibitPolicyMapping  bool
+	InhibitAnyPolicy      bool
+}
+
+// A node of the valid policy tree. (see RFC 5280 Section 6.1.2) The root has depth 0 and each certificate in the path, excluding the trust anchor, adds a level to the tree.
+type PolicyNode struct {
+	Depth       int
+	ValidPolicy string
+	CPSURIs     []string
+	UserNotices []UserNotice
+	// Policies which will be accepted in the next certificate of the path (it differs from ValidPolicy only when policy mappings are used)
+	ExpectedPolicySet []string
+	Children          []*PolicyNode
+	parent            *PolicyNode
+}
+
+// Returns the valid policies of the deepest nodes of the tree, which are the policies the end certificate is valid for, without duplicates.
+func (node *PolicyNode) Policies() []string {
+	if node == nil {
+		return nil
+	}
+	nodes := []*PolicyNode{node}
+	for {
+		next := []*PolicyNode{}
+		for _, item := range nodes {
+			next = append(next, item.Children...)
+		}
+		if len(next) == 0 {
+			break
+		}
+		nodes = next
+	}
+	ans := []string{}
+	for _, item := range nodes {
+		if !str_in_list(item.ValidPolicy, ans) {
+			ans = append(ans, item.ValidPolicy)
+		}
+	}
+	return ans
+}
+
+func (node *PolicyNode) add_child(valid_policy string, qualifiers CertificatePolicy, expected []string) *PolicyNode {
+	child := &PolicyNode{
+		Depth:             node.Depth + 1,
+		ValidPolicy:       valid_policy,
+		CPSURIs:           qualifiers.CPSURIs,
+		UserNotices:       qualifiers.UserNotices,
+		ExpectedPolicySet: expected,
+		parent:            node,
+	}
+	node.Children = append(node.Children, child)
+	return child
+}
+
+func (node *PolicyNode) qualifiers() CertificatePolicy {
+	return CertificatePolicy{CPSURIs: node.CPSURIs, UserNotices: node.UserNotices}
+}
+
+// Removes the node (and its children) from the tree.
+func (node *PolicyNode) remove() {
+	if node.parent == nil {
+		return
+	}
+	siblings := node.parent.Children
+	for i, item := range siblings {
+		if item == node {
+			node.parent.Children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	node.parent = nil
+}
+
+func (node *PolicyNode) nodes_at_depth(depth int) []*PolicyNode {
+	if node == nil || node.Depth > depth {
+		return nil
+	}
+	if node.Depth == depth {
+		return []*PolicyNode{node}
+	}
+	ans := []*PolicyNode{}
+	for _, child := range node.Children {
+		ans = append(ans, child.nodes_at_depth(depth)...)
+	}
+	return ans
+}
+
+// Deletes the nodes of the given depth or less without children. Returns nil if the whole tree was deleted.
+func (node *PolicyNode) prune(max_depth int) *PolicyNode {
+	for depth := max_depth; depth >= 0; depth-- {
+		for _, item := range node.nodes_at_depth(depth) {
+			if len(item.Children) == 0 {
+				if item == node {
+					return nil
+				}
+				item.remove()
+			}
+		}
+	}
+	return node
+}
+
+func str_in_list(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs the certificate policy processing of RFC 5280 Section 6.1 on a path as returned by build_path (the end certificate first and the trust anchor last). The returned tree is nil if it is empty, which is accepted unless an explicit policy is required by the params or by the certificates.
+//
+// Possible errors are: ERR_NO_VALID_POLICY, ERR_INVALID_POLICY_MAPPING
+func process_cert_policies(path []*Certificate, params CertPolicyParams) (*PolicyNode, CodedError) {
+	any_policy := idAnyPolicy.String()
+	tree := &PolicyNode{ValidPolicy: any_policy, ExpectedPolicySet: []string{any_policy}}
+	// The trust anchor is an input, not part of the path
+	n := len(path) - 1
+	if n <= 0 {
+		return tree, nil
+	}
+
+	// Initialization (see RFC 5280 Section 6.1.2)
+	explicit_policy, inhibit_any_policy, policy_mapping := n+1, n+1, n+1
+	if params.RequireExplicitPolicy {
+		explicit_policy = 0
+	}
+	if params.InhibitAnyPolicy {
+		inhibit_any_policy = 0
+	}
+	if params.InhibitPolicyMapping {
+		policy_mapping = 0
+	}
+	no_valid_policy := func(cert *Certificate) CodedError {
+		merr := NewMultiError("no valid certificate policy for the certification path", ERR_NO_VALID_POLICY, nil)
+		merr.SetParam("cert.Subject", cert.Subject)
+		merr.SetParam("initial-policy-set", params.InitialPolicySet)
+		return merr
+	}
+
+	for i := 1; i <= n; i++ {
+		cert := path[n-i]
+		self_issued := cert.Subject == cert.Issuer
+
+		// Basic certificate processing (see RFC 5280 Section 6.1.3)
+		if tree != nil && cert.ext_certificate_policies.Exists {
+			var any_qualifiers *CertificatePolicy
+			parents := tree.nodes_at_depth(i - 1)
+			for _, policy := range cert.Policies {
+				if policy.ID == any_policy {
+					any_qualifiers = &CertificatePolicy{CPSURIs: policy.CPSURIs, UserNotices: policy.UserNotices}
+					continue
+				}
+				matched := false
+				for _, parent := range parents {
+					if str_in_list(policy.ID, parent.ExpectedPolicySet) {
+						parent.add_child(policy.ID, policy, []string{policy.ID})
+						matched = true
+					}
+				}
+				if !matched {
+					for _, parent := range parents {
+						if parent.ValidPolicy == any_policy {
+							parent.add_child(policy.ID, policy, []string{policy.ID})
+						}
+					}
+				}
+			}
+			if any_qualifiers != nil && (inhibit_any_policy > 0 || (i < n && self_issued)) {
+				for _, parent := range parents {
+					for _, expected := range parent.ExpectedPolicySet {
+						found := false
+						for _, child := range parent.Children {
+							if child.ValidPolicy == expected {
+								found = true
+								break
+							}
+						}
+						if !found {
+							parent.add_child(expected, *any_qualifiers, []string{expected})
+						}
+					}
+				}
+			}
+			tree = tree.prune(i - 1)
+		} else {
+			tree = nil
+		}
+		if explicit_policy <= 0 && tree == nil {
+			return nil, no_valid_policy(cert)
+		}
+		if i == n {
+			break
+		}
+
+		// Preparation for the next certificate (see RFC 5280 Section 6.1.4)
+		mappings := map[string][]string{}
+		issuer_policies := []string{}
+		for _, mapping := range cert.ext_policy_mappings.Mappings {
+			if mapping.IssuerDomainPolicy.Equal(idAnyPolicy) || mapping.SubjectDomainPolicy.Equal(idAnyPolicy) {
+				merr := NewMultiError("anyPolicy can not be mapped", ERR_INVALID_POLICY_MAPPING, nil)
+				merr.SetParam("cert.Subject", cert.Subject)
+				return nil, merr
+			}
+			issuer_policy := mapping.IssuerDomainPolicy.String()
+			if _, ok := mappings[issuer_policy]; !ok {
+				issuer_policies = append(issuer_policies, issuer_policy)
+			}
+			mappings[issuer_policy] = append(mappings[issuer_policy], mapping.SubjectDomainPolicy.String())
+		}
+		for _, issuer_policy := range issuer_policies {
+			if tree == nil {
+				break
+			}
+			nodes := tree.nodes_at_depth(i)
+			if policy_mapping > 0 {
+				found := false
+				for _, node := range nodes {
+					if node.ValidPolicy == issuer_policy {
+						node.ExpectedPolicySet = mappings[issuer_policy]
+						found = true
+					}
+				}
+				if !found {
+					for _, node := range nodes {
+						if node.ValidPolicy == any_policy {
+							node.parent.add_child(issuer_policy, node.qualifiers(), mappings[issuer_policy])
+							break
+						}
+					}
+				}
+			} else {
+				for _, node := range nodes {
+					if node.ValidPolicy == issuer_policy {
+						node.remove()
+					}
+				}
+				tree = tree.prune(i - 1)
+			}
+		}
+		if !self_issued {
+			if explicit_policy > 0 {
+				explicit_policy--
+			}
+			if policy_mapping > 0 {
+				policy_mapping--
+			}
+			if inhibit_any_policy > 0 {
+				inhibit_any_policy--
+			}
+		}
+		constraints := cert.ext_policy_constraints
+		if constraints.Exists {
+			if constraints.RequireExplicitPolicy >= 0 && constraints.RequireExplicitPolicy < explicit_policy {
+				explicit_policy = constraints.RequireExplicitPolicy
+			}
+			if constraints.InhibitPolicyMapping >= 0 && constraints.InhibitPolicyMapping < policy_mapping {
+				policy_mapping = constraints.InhibitPolicyMapping
+			}
+		}
+		if cert.ext_inhibit_any_policy.Exists && cert.ext_inhibit_any_policy.SkipCerts < inhibit_any_policy {
+			inhibit_any_policy = cert.ext_inhibit_any_policy.SkipCerts
+		}
+	}
+
+	// Wrap-up (see RFC 5280 Section 6.1.5)
+	end_cert := path[0]
+	if explicit_policy > 0 {
+		explicit_policy--
+	}
+	if end_cert.ext_policy_constraints.Exists && end_cert.ext_policy_constraints.RequireExplicitPolicy == 0 {
+		explicit_policy = 0
+	}
+	if tree != nil && len(params.InitialPolicySet) > 0 && !str_in_list(any_policy, params.InitialPolicySet) {
+		// Only the nodes right below anyPolicy nodes are checked, as their descendants were accepted under them
+		valid_policy_node_set := []*PolicyNode{}
+		for depth := 1; depth <= n; depth++ {
+			for _, node := range tree.nodes_at_depth(depth) {
+				if node.parent.ValidPolicy == any_policy {
+					valid_policy_node_set = append(valid_policy_node_set, node)
+				}
+			}
+		}
+		valid_policies := []string{}
+		for _, node := range valid_policy_node_set {
+			if node.ValidPolicy != any_policy && !str_in_list(node.ValidPolicy, params.InitialPolicySet) {
+				node.remove()
+			} else {
+				valid_policies = append(valid_policies, node.ValidPolicy)
+			}
+		}
+		for _, node := range tree.nodes_at_depth(n) {
+			if node.ValidPolicy != any_policy {
+				continue
+			}
+			for _, policy := range params.InitialPolicySet {
+				if !str_in_list(policy, valid_policies) {
+					node.parent.add_child(policy, node.qualifiers(), []string{policy})
+				}
+			}
+			node.remove()
+		}
+		tree = tree.prune(n - 1)
+	}
+	if explicit_policy <= 0 && tree == nil {
+		return nil, no_valid_policy(end_cert)
+	}
+	return tree, nil
+}
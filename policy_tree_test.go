@@ -0,0 +1,153 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const test_policy_a1 = "2.16.76.1.2.1.1"
+const test_policy_a3 = "2.16.76.1.2.3.1"
+
+// If no policies are given, the certificate policies extension is absent.
+func new_policy_test_cert(subject string, policies ...string) *Certificate {
+	cert := &Certificate{Subject: subject, Issuer: "issuer of " + subject}
+	if len(policies) > 0 {
+		cert.ext_certificate_policies.Exists = true
+	}
+	for _, policy := range policies {
+		cert.Policies = append(cert.Policies, CertificatePolicy{ID: policy})
+	}
+	return cert
+}
+
+func new_policy_test_path(ca, end_cert *Certificate) []*Certificate {
+	root := &Certificate{Subject: "root", Issuer: "root"}
+	return []*Certificate{end_cert, ca, root}
+}
+
+func Test_ProcessCertPolicies_1(t *testing.T) {
+	path := new_policy_test_path(new_policy_test_cert("ca"), new_policy_test_cert("end"))
+	tree, err := process_cert_policies(path, CertPolicyParams{})
+	assert.Nil(t, err)
+	assert.Nil(t, tree)
+
+	tree, err = process_cert_policies(path, CertPolicyParams{RequireExplicitPolicy: true})
+	assert.Nil(t, tree)
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+
+	// A path with only the trust anchor
+	tree, err = process_cert_policies(path[2:], CertPolicyParams{RequireExplicitPolicy: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{idAnyPolicy.String()}, tree.Policies())
+}
+
+func Test_ProcessCertPolicies_2(t *testing.T) {
+	path := new_policy_test_path(new_policy_test_cert("ca", idAnyPolicy.String()), new_policy_test_cert("end", test_policy_a3))
+	tree, err := process_cert_policies(path, CertPolicyParams{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a3}, tree.Policies())
+	require.Len(t, tree.Children, 1)
+	assert.Equal(t, idAnyPolicy.String(), tree.Children[0].ValidPolicy)
+	assert.Equal(t, 2, tree.Children[0].Children[0].Depth)
+
+	tree, err = process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a1, test_policy_a3}, RequireExplicitPolicy: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a3}, tree.Policies())
+
+	// Without RequireExplicitPolicy, an empty tree is accepted
+	tree, err = process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a1}})
+	assert.Nil(t, err)
+	assert.Nil(t, tree)
+
+	_, err = process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a1}, RequireExplicitPolicy: true})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+
+	// anyPolicy is ignored when inhibited
+	_, err = process_cert_policies(path, CertPolicyParams{InhibitAnyPolicy: true, RequireExplicitPolicy: true})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+}
+
+func Test_ProcessCertPolicies_3(t *testing.T) {
+	// The end certificate accepts any policy, so the path is valid for the policies of the CA
+	path := new_policy_test_path(new_policy_test_cert("ca", test_policy_a1, test_policy_a3), new_policy_test_cert("end", idAnyPolicy.String()))
+	tree, err := process_cert_policies(path, CertPolicyParams{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a1, test_policy_a3}, tree.Policies())
+
+	tree, err = process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a3}, RequireExplicitPolicy: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a3}, tree.Policies())
+
+	// Unless the CA forbids it
+	path[1].ext_inhibit_any_policy = ext_inhibit_any_policy{Exists: true, SkipCerts: 0}
+	tree, err = process_cert_policies(path, CertPolicyParams{})
+	assert.Nil(t, err)
+	assert.Nil(t, tree)
+}
+
+func Test_ProcessCertPolicies_4(t *testing.T) {
+	ca := new_policy_test_cert("ca", test_policy_a1)
+	ca.ext_policy_mappings.Exists = true
+	ca.ext_policy_mappings.Mappings = []policy_mapping{{IssuerDomainPolicy: str2oid_key(test_policy_a1), SubjectDomainPolicy: str2oid_key(test_policy_a3)}}
+	path := new_policy_test_path(ca, new_policy_test_cert("end", test_policy_a3))
+
+	tree, err := process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a1}, RequireExplicitPolicy: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a3}, tree.Policies())
+	assert.Equal(t, []string{test_policy_a3}, tree.Children[0].ExpectedPolicySet)
+
+	_, err = process_cert_policies(path, CertPolicyParams{InitialPolicySet: []string{test_policy_a3}, RequireExplicitPolicy: true})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+
+	_, err = process_cert_policies(path, CertPolicyParams{InhibitPolicyMapping: true, RequireExplicitPolicy: true})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+
+	ca.ext_policy_mappings.Mappings[0].SubjectDomainPolicy = idAnyPolicy
+	_, err = process_cert_policies(path, CertPolicyParams{})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_INVALID_POLICY_MAPPING, err.Code())
+}
+
+func Test_ProcessCertPolicies_5(t *testing.T) {
+	ca := new_policy_test_cert("ca", test_policy_a1)
+	ca.ext_policy_constraints = ext_policy_constraints{Exists: true, RequireExplicitPolicy: 0, InhibitPolicyMapping: -1}
+	path := new_policy_test_path(ca, new_policy_test_cert("end"))
+
+	_, err := process_cert_policies(path, CertPolicyParams{})
+	require.NotNil(t, err)
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, err.Code())
+
+	path[0] = new_policy_test_cert("end", test_policy_a1)
+	tree, err := process_cert_policies(path, CertPolicyParams{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{test_policy_a1}, tree.Policies())
+}
+
+func Test_CAStore_VerifyCertAt_Policies_1(t *testing.T) {
+	store := CAStore{}
+	store.Init()
+	certs, err := NewCertificateFromBytes([]byte(pem_ac_soluti))
+	require.Nil(t, err)
+	some_time := time.Unix(1528997864, 0)
+
+	store.CertPolicies = CertPolicyParams{InitialPolicySet: []string{"2.16.76.1.1.46"}, RequireExplicitPolicy: true}
+	path, tree, errs, _ := store.verify_cert_at(certs[0], some_time)
+	assert.Nil(t, errs)
+	assert.Equal(t, 2, len(path))
+	assert.Equal(t, []string{"2.16.76.1.1.46"}, tree.Policies())
+
+	store.CertPolicies.InitialPolicySet = []string{test_policy_a3}
+	path, tree, errs, _ = store.verify_cert_at(certs[0], some_time)
+	assert.Equal(t, 2, len(path))
+	assert.Nil(t, tree)
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_NO_VALID_POLICY, errs[0].Code())
+}
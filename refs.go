@@ -0,0 +1,345 @@
+package libICP
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// The OtherCertHash is a CHOICE between a SHA1 hash (OCTET STRING) and an other_hash_alg_and_value. (see RFC 5126 Section 5.8.2)
+type other_cert_id struct {
+	RawContent    asn1.RawContent
+	OtherCertHash asn1.RawValue
+	IssuerSerial  issuer_serial `asn1:"optional"`
+}
+
+type crl_identifier struct {
+	RawContent    asn1.RawContent
+	CRLIssuer     asn1.RawValue
+	CRLIssuedTime time.Time `asn1:"utc"`
+	CRLNumber     *big.Int  `asn1:"optional"`
+}
+
+type crl_validated_id struct {
+	RawContent    asn1.RawContent
+	CRLHash       asn1.RawValue
+	CRLIdentifier crl_identifier `asn1:"optional"`
+}
+
+type crl_list_id struct {
+	RawContent asn1.RawContent
+	CRLs       []crl_validated_id
+}
+
+// OCSP and other revocation references are not supported. (they are ignored when parsing)
+type crl_ocsp_ref struct {
+	RawContent asn1.RawContent
+	CRLIDs     crl_list_id `asn1:"explicit,tag:0,optional"`
+}
+
+// Builds an OtherHash (SHA256) of data.
+func new_other_hash(data []byte) (asn1.RawValue, CodedError) {
+	hasher, _, _ := get_hasher(algorithm_identifier{Algorithm: idSha256})
+	val := other_hash_alg_and_value{}
+	val.HashAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	val.HashValue = run_hash(hasher, data)
+	raw, err := asn1.Marshal(val)
+	if err != nil {
+		return asn1.RawValue{}, NewMultiError("failed to encode other hash", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return asn1.RawValue{FullBytes: raw}, nil
+}
+
+// Returns true if the OtherHash refers to data.
+func other_hash_matches(other_hash asn1.RawValue, data []byte) bool {
+	alg_id := algorithm_identifier{Algorithm: idSha1}
+	hash_value := other_hash.Bytes
+	if other_hash.Class != asn1.ClassUniversal || other_hash.Tag != asn1.TagOctetString {
+		val := other_hash_alg_and_value{}
+		if _, err := asn1.Unmarshal(other_hash.FullBytes, &val); err != nil {
+			return false
+		}
+		alg_id = val.HashAlgorithm
+		hash_value = val.HashValue
+	}
+	hasher, _, cerr := get_hasher(alg_id)
+	if cerr != nil {
+		return false
+	}
+	return bytes.Equal(run_hash(hasher, data), hash_value)
+}
+
+func new_other_cert_id(cert *Certificate) (other_cert_id, CodedError) {
+	cert_id := other_cert_id{}
+	hash, cerr := new_other_hash(cert.base.RawContent)
+	if cerr != nil {
+		return cert_id, cerr
+	}
+	cert_id.OtherCertHash = hash
+	cert_id.IssuerSerial.SerialNumber = cert.base.TBSCertificate.SerialNumber
+	if ias, cerr := cert.issuer_and_serial(); cerr == nil {
+		// directoryName [4] Name
+		cert_id.IssuerSerial.Issuer = []asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: ias.Issuer.FullBytes}}
+	}
+	return cert_id, nil
+}
+
+func new_crl_validated_id(crl certificate_list) (crl_validated_id, CodedError) {
+	crl_id := crl_validated_id{}
+	hash, cerr := new_other_hash(crl.RawContent)
+	if cerr != nil {
+		return crl_id, cerr
+	}
+	crl_id.CRLHash = hash
+	names := tbs_cert_list_names_decode{}
+	if _, err := asn1.Unmarshal(crl.TBSCertList.RawContent, &names); err != nil {
+		return crl_id, NewMultiError("failed to parse CRL issuer", ERR_PARSE_CRL, nil, err)
+	}
+	crl_id.CRLIdentifier.CRLIssuer = asn1.RawValue{FullBytes: names.Issuer.FullBytes}
+	crl_id.CRLIdentifier.CRLIssuedTime = crl.TBSCertList.ThisUpdate
+	for _, ext := range crl.TBSCertList.CRLExtensions {
+		if ext.ExtnID.Equal(idCeCRLNumber) {
+			number := new(big.Int)
+			if _, err := asn1.Unmarshal(ext.ExtnValue, &number); err == nil {
+				crl_id.CRLIdentifier.CRLNumber = number
+			}
+		}
+	}
+	return crl_id, nil
+}
+
+// Builds the complete certificate references (all certificates in the path except the signer's one) and the complete revocation references (one for the signer's certificate followed by one for each referenced certificate). (see RFC 5126 Sections 6.2.1 and 6.2.2)
+//
+// The revocation reference of a certificate points to the CRL of its issuer, if the store had one. Trust anchors have empty revocation references.
+func new_complete_refs(path []*Certificate) ([]other_cert_id, []crl_ocsp_ref, CodedError) {
+	cert_refs := make([]other_cert_id, 0)
+	rev_refs := make([]crl_ocsp_ref, 0)
+	for i, cert := range path {
+		if i > 0 {
+			cert_id, cerr := new_other_cert_id(cert)
+			if cerr != nil {
+				return nil, nil, cerr
+			}
+			cert_refs = append(cert_refs, cert_id)
+		}
+
+		ref := crl_ocsp_ref{}
+		if i < len(path)-1 && len(path[i+1].crl.RawContent) > 0 {
+			crl_id, cerr := new_crl_validated_id(path[i+1].crl)
+			if cerr != nil {
+				return nil, nil, cerr
+			}
+			ref.CRLIDs.CRLs = []crl_validated_id{crl_id}
+		}
+		rev_refs = append(rev_refs, ref)
+	}
+	return cert_refs, rev_refs, nil
+}
+
+// Adds the complete certificate and revocation references (CAdES-C) to sig, which MUST be one of this file signatures. They are computed from the certification path of the signer and the CRLs the store used to check it. A CAdES-C time stamp over them is also requested from client. Together with a signature time stamp (see AddTimeStamp) this turns the signature into an AD-RV one.
+//
+// Any previous references and CAdES-C time stamps of sig are replaced. This is refused if sig already has an archive time stamp, as it covers the previous references.
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND, ERR_NO_TSA_CLIENT, ERR_HAS_ARCHIVE_TIMESTAMP, ERR_SIGNER_NOT_FOUND, ERR_FAILED_TO_ENCODE, ERR_PARSE_CRL, ERR_HTTP, ERR_TSA_REJECTED, ERR_PARSE_TIMESTAMP, ERR_SECURE_RANDOM and the ones from CAStore.VerifyCert
+func (msig *MultSignature) AddValidationRefs(sig *Signature, store *CAStore, client *TSAClient) CodedError {
+	return msig.add_validation_refs_at(sig, store, client, time.Now())
+}
+
+// Returns the certification path of the signer, which MUST be valid.
+func (sig *Signature) signer_path_at(store *CAStore, now time.Time) ([]*Certificate, CodedError) {
+	if sig.Signer.base.TBSCertificate.SerialNumber == nil {
+		merr := NewMultiError("signer certificate not found", ERR_SIGNER_NOT_FOUND, nil)
+		merr.SetParam("Sid_V1.SerialNumber", sig.base.Sid_V1.SerialNumber)
+		return nil, merr
+	}
+	path, _, errs, _ := store.verify_cert_at(&sig.Signer, now)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return path, nil
+}
+
+func (msig *MultSignature) add_validation_refs_at(sig *Signature, store *CAStore, client *TSAClient, now time.Time) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	if cerr := check_tsa_client(client); cerr != nil {
+		return cerr
+	}
+	if _, ok := sig.get_unsigned_attr(idAaEtsArchiveTimestampV2); ok {
+		return NewMultiError("references cannot be replaced after an archive time stamp was added", ERR_HAS_ARCHIVE_TIMESTAMP, nil)
+	}
+	path, cerr := sig.signer_path_at(store, now)
+	if cerr != nil {
+		return cerr
+	}
+	cert_refs, rev_refs, cerr := new_complete_refs(path)
+	if cerr != nil {
+		return cerr
+	}
+
+	attrs := remove_attrs_by_type(sig.base.UnsignedAttrs, idAaEtsCertificateRefs)
+	attrs = remove_attrs_by_type(attrs, idAaEtsRevocationRefs)
+	attrs = remove_attrs_by_type(attrs, idAaEtsEscTimeStamp)
+	cert_attr := attribute{}
+	cert_attr.Type = idAaEtsCertificateRefs
+	cert_attr.Values = []interface{}{cert_refs}
+	rev_attr := attribute{}
+	rev_attr.Type = idAaEtsRevocationRefs
+	rev_attr.Values = []interface{}{rev_refs}
+	sig.base.UnsignedAttrs = append(attrs, cert_attr, rev_attr)
+	sig.RefsTimeStamps = make([]TimeStamp, 0)
+	// Ensure it will be marshaled again (see sync_signer_infos)
+	sig.base.RawContent = nil
+
+	data, cerr := sig.refs_time_stamp_data()
+	if cerr != nil {
+		return cerr
+	}
+	ts, cerr := client.RequestTimeStamp(data)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := sig.add_time_stamp_attr(idAaEtsEscTimeStamp, ts); cerr != nil {
+		return cerr
+	}
+	sig.RefsTimeStamps = append(sig.RefsTimeStamps, *ts)
+	return nil
+}
+
+// Returns the data covered by a CAdES-C time stamp: the signature value followed by the DER encoded signature time stamp, complete certificate references and complete revocation references attributes. (see RFC 5126 Section 6.3.5)
+func (sig Signature) refs_time_stamp_data() ([]byte, CodedError) {
+	buf := new(bytes.Buffer)
+	buf.Write(sig.base.Signature)
+	for _, attr_type := range []asn1.ObjectIdentifier{idAaSignatureTimeStampToken, idAaEtsCertificateRefs, idAaEtsRevocationRefs} {
+		for _, attr := range sig.base.UnsignedAttrs {
+			if !attr.Type.Equal(attr_type) {
+				continue
+			}
+			raw, err := asn1.Marshal(attr)
+			if err != nil {
+				merr := NewMultiError("failed to encode unsigned attribute", ERR_FAILED_TO_ENCODE, nil, err)
+				merr.SetParam("attr.Type", attr.Type)
+				return nil, merr
+			}
+			buf.Write(raw)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns the CRLs included in this file.
+func (msig *MultSignature) crl_list() []certificate_list {
+	ans := make([]certificate_list, 0)
+	for _, choice := range msig.base.CRLs {
+		if len(choice.CRL.RawContent) > 0 {
+			ans = append(ans, choice.CRL)
+		}
+	}
+	return ans
+}
+
+// Returns the first unsigned attribute of the given type.
+func (sig Signature) get_unsigned_attr(attr_type asn1.ObjectIdentifier) (attribute, bool) {
+	for _, attr := range sig.base.UnsignedAttrs {
+		if attr.Type.Equal(attr_type) {
+			return attr, true
+		}
+	}
+	return attribute{}, false
+}
+
+// Matches the validation references against the given certificates, the certificate and revocation values of sig, the CAs in the store and their CRLs. The results are saved on sig.Status.RefsErrors and on each CAdES-C time stamp Status field. Signatures without references are not checked.
+func (sig *Signature) check_refs_at(store *CAStore, certs []*Certificate, crls []certificate_list, now time.Time) {
+	sig.Status.RefsErrors = nil
+	cert_attr, has_certs := sig.get_unsigned_attr(idAaEtsCertificateRefs)
+	rev_attr, has_revs := sig.get_unsigned_attr(idAaEtsRevocationRefs)
+	if !has_certs && !has_revs {
+		return
+	}
+
+	if data, cerr := sig.refs_time_stamp_data(); cerr == nil {
+		for i := range sig.RefsTimeStamps {
+			sig.RefsTimeStamps[i].check_at(store, data, now)
+		}
+	}
+
+	if !has_certs || !has_revs {
+		merr := NewMultiError("complete certificate and revocation references must be used together", ERR_PARSE_REFS, nil)
+		merr.SetParam("has-certificate-refs", has_certs)
+		merr.SetParam("has-revocation-refs", has_revs)
+		sig.Status.RefsErrors = []CodedError{merr}
+		return
+	}
+	cert_refs := make([]other_cert_id, 0)
+	if err := cert_attr.first_value(&cert_refs); err != nil {
+		merr := NewMultiError("failed to parse complete certificate references", ERR_PARSE_REFS, nil, err)
+		merr.SetParam("attr", cert_attr)
+		sig.Status.RefsErrors = []CodedError{merr}
+		return
+	}
+	rev_refs := make([]crl_ocsp_ref, 0)
+	if err := rev_attr.first_value(&rev_refs); err != nil {
+		merr := NewMultiError("failed to parse complete revocation references", ERR_PARSE_REFS, nil, err)
+		merr.SetParam("attr", rev_attr)
+		sig.Status.RefsErrors = []CodedError{merr}
+		return
+	}
+	if len(rev_refs) != len(cert_refs)+1 {
+		merr := NewMultiError("the number of revocation references does not match the number of certificate references", ERR_PARSE_REFS, nil)
+		merr.SetParam("len(certificate-refs)", len(cert_refs))
+		merr.SetParam("len(revocation-refs)", len(rev_refs))
+		sig.Status.RefsErrors = append(sig.Status.RefsErrors, merr)
+	}
+
+	// Gather everything that was supplied
+	cas := store.list_CAs()
+	all_certs := append(append(append(make([]*Certificate, 0), certs...), sig.values_certs...), cas...)
+	all_crls := append(append(make([]certificate_list, 0), crls...), sig.values_crls...)
+	for _, ca := range cas {
+		if len(ca.crl.RawContent) > 0 {
+			all_crls = append(all_crls, ca.crl)
+		}
+	}
+
+	for i, ref := range cert_refs {
+		found := false
+		for _, cert := range all_certs {
+			if ref.IssuerSerial.SerialNumber != nil && ref.IssuerSerial.SerialNumber.Cmp(cert.base.TBSCertificate.SerialNumber) != 0 {
+				continue
+			}
+			if other_hash_matches(ref.OtherCertHash, cert.base.RawContent) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merr := NewMultiError("referenced certificate not found", ERR_CERT_REF_NOT_FOUND, nil)
+			merr.SetParam("index", i)
+			if ref.IssuerSerial.SerialNumber != nil {
+				merr.SetParam("serial", "0x"+ref.IssuerSerial.SerialNumber.Text(16))
+			}
+			sig.Status.RefsErrors = append(sig.Status.RefsErrors, merr)
+		}
+	}
+
+	for i, ref := range rev_refs {
+		for _, crl_id := range ref.CRLIDs.CRLs {
+			found := false
+			for _, crl := range all_crls {
+				if other_hash_matches(crl_id.CRLHash, crl.RawContent) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				merr := NewMultiError("referenced CRL not found", ERR_CRL_REF_NOT_FOUND, nil)
+				merr.SetParam("index", i)
+				merr.SetParam("crl.ThisUpdate", crl_id.CRLIdentifier.CRLIssuedTime)
+				sig.Status.RefsErrors = append(sig.Status.RefsErrors, merr)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package libICP
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Same as get_test_store(t, true), but the FakeBank CA also has a CRL.
+func get_test_store_with_crl(t *testing.T) *CAStore {
+	store := get_test_store(t, false)
+	certs, errs := NewCertificateFromFile("data/test-chain/intermediate/fakebank/certs/fakebank-ca.crt.pem")
+	require.Nil(t, errs)
+	crls, errs := new_CRL_from_file("data/test-chain/intermediate/fakebank/crl/fakebank-2.crl.pem")
+	require.Nil(t, errs)
+	require.Nil(t, certs[0].process_CRL(crls[0]))
+	store.direct_add_ca(certs[0])
+	return store
+}
+
+func get_test_adrt(t *testing.T, tsa *test_tsa) *MultSignature {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, get_test_policy(t))
+	require.Nil(t, cerr)
+	require.Nil(t, msig.AddTimeStamp(&msig.Signatures[0], tsa.client()))
+	return msig
+}
+
+func Test_MultSignature_AddValidationRefs_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.add_validation_refs_at(&msig.Signatures[0], get_test_store_with_crl(t), tsa.client(), now))
+	require.Equal(t, 1, len(msig.Signatures[0].RefsTimeStamps))
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	require.Equal(t, 1, len(sig.TimeStamps))
+	require.Equal(t, 1, len(sig.RefsTimeStamps))
+
+	cert_refs := make([]other_cert_id, 0)
+	attr, ok := sig.get_unsigned_attr(idAaEtsCertificateRefs)
+	require.True(t, ok)
+	require.Nil(t, attr.first_value(&cert_refs))
+	rev_refs := make([]crl_ocsp_ref, 0)
+	attr, ok = sig.get_unsigned_attr(idAaEtsRevocationRefs)
+	require.True(t, ok)
+	require.Nil(t, attr.first_value(&rev_refs))
+	// FakeBank and the root CA
+	require.Equal(t, 2, len(cert_refs))
+	require.Equal(t, 3, len(rev_refs))
+	require.Equal(t, 1, len(rev_refs[0].CRLIDs.CRLs))
+	assert.EqualValues(t, 4097, rev_refs[0].CRLIDs.CRLs[0].CRLIdentifier.CRLNumber.Int64())
+	assert.Equal(t, 0, len(rev_refs[1].CRLIDs.CRLs))
+	assert.Equal(t, 0, len(rev_refs[2].CRLIDs.CRLs))
+
+	cerr = msig.check_all_at(get_test_store_with_crl(t), now)
+	require.Nil(t, cerr)
+	sig = msig.Signatures[0]
+	assert.True(t, sig.Status.Integrity)
+	assert.True(t, sig.Status.AreRefsValid(), "%v", sig.Status.RefsErrors)
+	assert.True(t, sig.TimeStamps[0].Status.IsValid())
+	assert.True(t, sig.RefsTimeStamps[0].Status.IsValid())
+}
+
+func Test_MultSignature_AddValidationRefs_2(t *testing.T) {
+	// Signer certificate path cannot be built
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	cerr := msig.add_validation_refs_at(&msig.Signatures[0], get_test_store(t, false), tsa.client(), now)
+	require.NotNil(t, cerr)
+	assert.Equal(t, 0, len(msig.Signatures[0].RefsTimeStamps))
+}
+
+func Test_MultSignature_AddValidationRefs_3(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A copy of the signature
+	sig := msig.Signatures[0]
+	cerr := msig.add_validation_refs_at(&sig, get_test_store_with_crl(t), tsa.client(), now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+
+	// No client
+	cerr = msig.add_validation_refs_at(&msig.Signatures[0], get_test_store_with_crl(t), nil, now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_TSA_CLIENT, cerr.Code())
+	assert.Equal(t, AD_RT, msig.Signatures[0].Level())
+}
+
+func Test_MultSignature_AddValidationRefs_4(t *testing.T) {
+	// The archive time stamp covers the references
+	tsa := new_test_tsa(t)
+	msig := get_test_adra(t, tsa, []byte("The quick fox jumps over the lazy dog."), true)
+	before, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	cerr = msig.add_validation_refs_at(&msig.Signatures[0], get_test_store_with_crl(t), tsa.client(), tsa.now)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_HAS_ARCHIVE_TIMESTAMP, cerr.Code())
+	after, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.Equal(t, before, after)
+}
+
+func Test_Signature_CheckRefsAt_1(t *testing.T) {
+	// The referenced CRL was not supplied
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.add_validation_refs_at(&msig.Signatures[0], get_test_store_with_crl(t), tsa.client(), now))
+
+	cerr := msig.check_all_at(get_test_store(t, true), now)
+	require.Nil(t, cerr)
+	errs := msig.Signatures[0].Status.RefsErrors
+	require.Equal(t, 1, len(errs))
+	assert.EqualValues(t, ERR_CRL_REF_NOT_FOUND, errs[0].Code())
+}
+
+func Test_Signature_CheckRefsAt_2(t *testing.T) {
+	// The referenced intermediate CA was not supplied
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.add_validation_refs_at(&msig.Signatures[0], get_test_store(t, true), tsa.client(), now))
+
+	cerr := msig.check_all_at(get_test_store(t, false), now)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	require.Equal(t, 1, len(sig.Status.RefsErrors))
+	assert.EqualValues(t, ERR_CERT_REF_NOT_FOUND, sig.Status.RefsErrors[0].Code())
+	// The time stamp itself is fine
+	assert.True(t, sig.RefsTimeStamps[0].Status.Integrity)
+	assert.True(t, sig.RefsTimeStamps[0].Status.ImprintMatches)
+}
+
+func Test_Signature_CheckRefsAt_3(t *testing.T) {
+	// Changing the references invalidates the CAdES-C time stamp
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	sig := &msig.Signatures[0]
+	require.Nil(t, msig.add_validation_refs_at(sig, get_test_store(t, true), tsa.client(), now))
+	ts := sig.RefsTimeStamps[0]
+	require.Nil(t, msig.add_validation_refs_at(sig, get_test_store_with_crl(t), tsa.client(), now))
+	sig.base.UnsignedAttrs = remove_attrs_by_type(sig.base.UnsignedAttrs, idAaEtsEscTimeStamp)
+	require.Nil(t, sig.add_time_stamp_attr(idAaEtsEscTimeStamp, &ts))
+	sig.RefsTimeStamps = []TimeStamp{ts}
+
+	sig.check_refs_at(get_test_store_with_crl(t), msig.certs, nil, now)
+	assert.True(t, sig.Status.AreRefsValid(), "%v", sig.Status.RefsErrors)
+	assert.True(t, sig.RefsTimeStamps[0].Status.Integrity)
+	assert.False(t, sig.RefsTimeStamps[0].Status.ImprintMatches)
+}
@@ -1,6 +1,8 @@
 package libICP
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/rsa"
 	"time"
 
@@ -17,6 +19,17 @@ type signed_data_raw struct {
 	SignerInfos      []signer_info_raw        `asn1:"set"`
 }
 
+// Used only for decoding, as certificate_choice and revocation_info_choice can't be directly unmarshaled.
+type signed_data_decode struct {
+	RawContent       asn1.RawContent
+	Version          int
+	DigestAlgorithms []algorithm_identifier `asn1:"set"`
+	EncapContentInfo encapsulated_content_info
+	Certificates     []asn1.RawValue      `asn1:"tag:0,optional,set,omitempty"`
+	CRLs             []asn1.RawValue      `asn1:"tag:1,optional,set,omitempty"`
+	SignerInfos      []signer_info_decode `asn1:"set"`
+}
+
 // Apply algorithm described on RFC5625 Section 5.1 Page 9. This function MUST be called before marshaling.
 func (sd *signed_data_raw) set_appropriate_version() {
 	if sd.has_other_type_cert() || sd.has_other_type_crl() {
@@ -79,32 +92,116 @@ func (sd *signed_data_raw) has_v3_SignerInfo() bool {
 	return false
 }
 
+// Sets DigestAlgorithms to the list of digest algorithms used by the signers.
 func (sd *signed_data_raw) update_algs() {
 	used := make(map[string]bool)
-	algs := make(map[string]algorithm_identifier)
-
+	sd.DigestAlgorithms = make([]algorithm_identifier, 0)
 	for _, info := range sd.SignerInfos {
-		used[info.DigestAlgorithm.ToHex()] = true
-	}
-	sd.DigestAlgorithms = make([]algorithm_identifier, len(used))
-	i := 0
-	for k, _ := range used {
-		sd.DigestAlgorithms[i] = algs[k]
-		i++
+		key := info.DigestAlgorithm.Algorithm.String()
+		if !used[key] {
+			used[key] = true
+			sd.DigestAlgorithms = append(sd.DigestAlgorithms, info.DigestAlgorithm)
+		}
 	}
 }
 
 type signer_info_raw struct {
 	RawContent         asn1.RawContent
 	Version            int
-	Sid_V1             issuer_and_serial `asn1:"optional,omitempty"`
-	Sid_V3             []byte            `asn1:"tag:0,optional,omitempty"`
+	Sid_V1             issuer_and_serial_number `asn1:"optional,omitempty"`
+	Sid_V3             []byte                   `asn1:"tag:0,optional,omitempty"`
 	DigestAlgorithm    algorithm_identifier
 	SignedAttrs        []attribute `asn1:"tag:0,set,optional,omitempty"`
 	SignedRaw          []byte      `asn1:"-"`
 	SignatureAlgorithm algorithm_identifier
 	Signature          []byte
-	UnsignedAttrs      []attribute `asn1:"tag:1,set,optional,omitempty"`
+	// It is encoded as a SEQUENCE (which has the same encoding once tagged) so the attributes are kept in the order they were added, which archive time stamps depend on.
+	UnsignedAttrs []attribute `asn1:"tag:1,optional,omitempty"`
+}
+
+// Used only for decoding. The signed attributes are kept as a raw value because their exact encoding is needed in order to verify the signature.
+type signer_info_decode struct {
+	RawContent         asn1.RawContent
+	Version            int
+	Sid_V1             issuer_and_serial_number `asn1:"optional,omitempty"`
+	Sid_V3             []byte                   `asn1:"tag:0,optional,omitempty"`
+	DigestAlgorithm    algorithm_identifier
+	SignedAttrs        asn1.RawValue `asn1:"tag:0,optional,omitempty"`
+	SignatureAlgorithm algorithm_identifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"tag:1,optional,omitempty"`
+}
+
+// Converts the decoded structure into a signer_info_raw. The signed attributes bytes (with the SET tag, as defined on RFC 5652 Section 5.4) are stored on SignedRaw.
+func (dec signer_info_decode) to_signer_info() (signer_info_raw, CodedError) {
+	var cerr CodedError
+	si := signer_info_raw{}
+	si.RawContent = dec.RawContent
+	si.Version = dec.Version
+	si.Sid_V1 = dec.Sid_V1
+	si.Sid_V3 = dec.Sid_V3
+	si.DigestAlgorithm = dec.DigestAlgorithm
+	si.SignatureAlgorithm = dec.SignatureAlgorithm
+	si.Signature = dec.Signature
+
+	if len(dec.SignedAttrs.FullBytes) > 0 {
+		si.SignedRaw, si.SignedAttrs, cerr = decode_attributes(dec.SignedAttrs)
+		if cerr != nil {
+			return si, cerr
+		}
+	}
+	if len(dec.UnsignedAttrs.FullBytes) > 0 {
+		_, si.UnsignedAttrs, cerr = decode_attributes(dec.UnsignedAttrs)
+		if cerr != nil {
+			return si, cerr
+		}
+	}
+	return si, nil
+}
+
+// Decodes an implicitly tagged SET OF Attribute. Returns the raw bytes with the universal SET tag and the attributes themselves.
+func decode_attributes(raw asn1.RawValue) ([]byte, []attribute, CodedError) {
+	set_raw := make([]byte, len(raw.FullBytes))
+	copy(set_raw, raw.FullBytes)
+	set_raw[0] = 0x31
+
+	attrs_decode := make([]attribute_decode, 0)
+	_, err := asn1.UnmarshalWithParams(set_raw, &attrs_decode, "set")
+	if err != nil {
+		merr := NewMultiError("failed to parse attributes", ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("raw-data", raw.FullBytes)
+		return nil, nil, merr
+	}
+	attrs := make([]attribute, len(attrs_decode))
+	for i := range attrs_decode {
+		attrs[i] = attrs_decode[i].to_attribute()
+	}
+	return set_raw, attrs, nil
+}
+
+// Returns true if the certificate matches this signer identifier (either the issuer and serial number or the subject key identifier).
+func (si signer_info_raw) is_signed_by(cert Certificate) bool {
+	if len(si.Sid_V3) > 0 {
+		return cert.SubjectKeyId == nice_hex(si.Sid_V3)
+	}
+	if si.Sid_V1.SerialNumber == nil {
+		return false
+	}
+	ias, cerr := cert.issuer_and_serial()
+	if cerr != nil {
+		return false
+	}
+	return ias.SerialNumber.Cmp(si.Sid_V1.SerialNumber) == 0 && bytes.Equal(ias.Issuer.FullBytes, si.Sid_V1.Issuer.FullBytes)
+}
+
+// Returns the first signed attribute of the given type.
+func (si signer_info_raw) get_signed_attr(attr_type asn1.ObjectIdentifier) (attribute, bool) {
+	for _, attr := range si.SignedAttrs {
+		if attr.Type.Equal(attr_type) {
+			return attr, true
+		}
+	}
+	return attribute{}, false
 }
 
 // Apply rule described on RFC5625 Section 5.3 Page 13. This function MUST be called before marshaling.
@@ -118,9 +215,9 @@ func (si *signer_info_raw) SetAppropriateVersion() {
 	}
 }
 
+// Returns the DER encoded signed attributes (as calculated by GetFinalMessageDigest) with the universal SET tag, as required by RFC 5652 Section 5.4.
 func (si signer_info_raw) GetBytesToSign() []byte {
-	// fmt.Println(ToHex(si.SignedRaw))
-	return si.SignedRaw[2:]
+	return si.SignedRaw
 }
 
 func (si signer_info_raw) GetSignatureAlgorithm() algorithm_identifier {
@@ -146,15 +243,34 @@ func (si *signer_info_raw) RemoveSignedAttrByType(attr_type asn1.ObjectIdentifie
 	}
 }
 
+// Ensures we will have exactly one signed attribute of this type. An existing attribute is replaced in place, so the order of the others (and therefore their encoding) is kept.
+func (si *signer_info_raw) set_signed_attr(new_attr attribute) {
+	for i, attr := range si.SignedAttrs {
+		if attr.Type.Equal(new_attr.Type) {
+			si.SignedAttrs[i] = new_attr
+			si.SignedAttrs = append(si.SignedAttrs[:i+1], remove_attrs_by_type(si.SignedAttrs[i+1:], new_attr.Type)...)
+			return
+		}
+	}
+	si.SignedAttrs = append(si.SignedAttrs, new_attr)
+}
+
+func remove_attrs_by_type(attrs []attribute, attr_type asn1.ObjectIdentifier) []attribute {
+	ans := make([]attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		if !attr.Type.Equal(attr_type) {
+			ans = append(ans, attr)
+		}
+	}
+	return ans
+}
+
 func (si *signer_info_raw) SetContentTypeAttr(content_type asn1.ObjectIdentifier) {
-	// Ensure we will have exactly one content type signed attribute
-	si.RemoveSignedAttrByType(idContentType)
-	// Add content type
 	attr := attribute{}
 	attr.Type = idContentType
 	attr.Values = make([]interface{}, 1)
 	attr.Values[0] = content_type
-	si.SignedAttrs = append(si.SignedAttrs, attr)
+	si.set_signed_attr(attr)
 }
 
 func (si signer_info_raw) DigestEncapContent(encap *encapsulated_content_info) ([]byte, CodedError) {
@@ -162,22 +278,16 @@ func (si signer_info_raw) DigestEncapContent(encap *encapsulated_content_info) (
 }
 
 func (si *signer_info_raw) SetSigningTime(sig_time time.Time) {
-	// Ensure we will have exactly one singing time signed attribute
-	si.RemoveSignedAttrByType(idSigningTime)
-	// Add singing time
 	attr := attribute{}
 	attr.Type = idSigningTime
 	attr.Values = make([]interface{}, 1)
 	attr.Values[0] = sig_time.UTC()
-	si.SignedAttrs = append(si.SignedAttrs, attr)
+	si.set_signed_attr(attr)
 }
 
 func (si *signer_info_raw) SetMessageDigestAttr(encap *encapsulated_content_info) CodedError {
 	var err CodedError
 
-	// Ensure we will have exactly one message digest signed attribute
-	si.RemoveSignedAttrByType(idMessageDigest)
-	// Add message digest
 	attr := attribute{}
 	attr.Type = idMessageDigest
 	attr.Values = make([]interface{}, 1)
@@ -185,7 +295,7 @@ func (si *signer_info_raw) SetMessageDigestAttr(encap *encapsulated_content_info
 	if err != nil {
 		return err
 	}
-	si.SignedAttrs = append(si.SignedAttrs, attr)
+	si.set_signed_attr(attr)
 	return nil
 }
 
@@ -206,7 +316,7 @@ func (si *signer_info_raw) GetFinalMessageDigest(encap *encapsulated_content_inf
 		return nil, cerr
 	}
 
-	si.SignedRaw, err = asn1.MarshalWithParams(si.SignedAttrs, "set,explicit")
+	si.SignedRaw, err = asn1.MarshalWithParams(si.SignedAttrs, "set")
 	if err != nil {
 		merr := NewMultiError("failed to mashal signed attributes", ERR_FAILED_TO_ENCODE, nil, err)
 		merr.SetParam("signer_info", si)
@@ -215,6 +325,95 @@ func (si *signer_info_raw) GetFinalMessageDigest(encap *encapsulated_content_inf
 	return get_hasher_and_run(si.DigestAlgorithm, si.SignedRaw)
 }
 
+// Checks the content type and message digest attributes (if any) and the signature itself. The signature is checked against the signed attributes exactly as they were received (SignedRaw), so attributes not encoded in DER are accepted. The encap is used as read only.
+//
+// The content type attribute is required whenever there are signed attributes, unless this is a counter signature (is_counter), on which it is absent. Only RSA PKCS #1 v1.5 signatures are supported, and the digest algorithm named by the signature algorithm (if any) MUST be the same as DigestAlgorithm.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_BAD_SIGNATURE, ERR_BAD_DIGEST, ERR_NO_CONTENT, ERR_FAILED_TO_OPEN_FILE, ERR_FAILED_TO_ENCODE
+func (si signer_info_raw) verify(encap *encapsulated_content_info, pubkey rsa.PublicKey, is_counter bool) CodedError {
+	_, hash_alg, cerr := get_hasher(si.DigestAlgorithm)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := check_signature_alg(si.SignatureAlgorithm, hash_alg); cerr != nil {
+		return cerr
+	}
+	content_digest, cerr := encap.HashAs(si.DigestAlgorithm)
+	if cerr != nil {
+		return cerr
+	}
+
+	// Calculate what should have been signed
+	digest := content_digest
+	if len(si.SignedAttrs) > 0 {
+		// The content type attribute is absent on counter signatures
+		attr, ok := si.get_signed_attr(idContentType)
+		if !ok && !is_counter {
+			return NewMultiError("content type attribute is missing", ERR_BAD_SIGNATURE, nil)
+		}
+		if ok {
+			content_type := asn1.ObjectIdentifier{}
+			err := attr.first_value(&content_type)
+			if err != nil || !content_type.Equal(encap.EContentType) {
+				merr := NewMultiError("content type attribute does not match the encapsulated content type", ERR_BAD_SIGNATURE, nil, err)
+				merr.SetParam("attr", attr)
+				merr.SetParam("encap.EContentType", encap.EContentType)
+				return merr
+			}
+		}
+		attr, ok = si.get_signed_attr(idMessageDigest)
+		if !ok {
+			return NewMultiError("message digest attribute is missing", ERR_BAD_SIGNATURE, nil)
+		}
+		message_digest := make([]byte, 0)
+		if err := attr.first_value(&message_digest); err != nil || !bytes.Equal(message_digest, content_digest) {
+			merr := NewMultiError("message digest attribute does not match the content", ERR_BAD_DIGEST, nil, err)
+			merr.SetParam("attr", attr)
+			return merr
+		}
+
+		signed_raw := si.SignedRaw
+		if len(signed_raw) == 0 {
+			var err error
+			if signed_raw, err = asn1.MarshalWithParams(si.SignedAttrs, "set"); err != nil {
+				return NewMultiError("failed to mashal signed attributes", ERR_FAILED_TO_ENCODE, nil, err)
+			}
+		}
+		if digest, cerr = get_hasher_and_run(si.DigestAlgorithm, signed_raw); cerr != nil {
+			return cerr
+		}
+	}
+
+	// Verify signature
+	err := rsa.VerifyPKCS1v15(&pubkey, hash_alg, digest, si.Signature)
+	if err != nil {
+		return NewMultiError("failed to verify signature", ERR_BAD_SIGNATURE, nil, err)
+	}
+	return nil
+}
+
+// Checks that sig_alg is a supported signature algorithm: either rsaEncryption or an RSA PKCS #1 v1.5 one whose digest algorithm is hash_alg.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM
+func check_signature_alg(sig_alg algorithm_identifier, hash_alg crypto.Hash) CodedError {
+	if sig_alg.Algorithm.Equal(idRSAEncryption) {
+		return nil
+	}
+	digest_alg, ok := signature_alg_digests[sig_alg.Algorithm.String()]
+	if !ok {
+		merr := NewMultiError("unsupported signature algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("SignatureAlgorithm", sig_alg.Algorithm)
+		return merr
+	}
+	if _, sig_hash_alg, _ := get_hasher(algorithm_identifier{Algorithm: digest_alg}); sig_hash_alg != hash_alg {
+		merr := NewMultiError("signature algorithm does not match the digest algorithm", ERR_UNKOWN_ALGORITHM, nil)
+		merr.SetParam("SignatureAlgorithm", sig_alg.Algorithm)
+		merr.SetParam("DigestAlgorithm", hash2name(hash_alg))
+		return merr
+	}
+	return nil
+}
+
 func (si *signer_info_raw) Sign(privkey *rsa.PrivateKey) CodedError {
 	return Sign(si, privkey)
 }
@@ -101,7 +101,7 @@ func Test_SignedData_GetFinalMessageDigest_4(t *testing.T) {
 	e := encapsulated_content_info{}
 	e.EContent = []byte{}
 	right_ans := from_hex("da39a3ee5e6b4b0d3255bfef95601890afd80709")
-	right_ans2 := from_hex("27062FF2EB5D9D81B8B050D3CF4D1323A717611B")
+	right_ans2 := from_hex("D50984A4C11EFE13198900CDDAEF8DBECAF1F3B0")
 	si.SetContentTypeAttr(idData)
 	ans, cerr := si.GetFinalMessageDigest(&e)
 	require.Nil(t, cerr)
@@ -176,3 +176,66 @@ func Test_SignerInfo_Sign(t *testing.T) {
 	right_ans := from_hex("36A1E19C 87C38ECF A2E6B376 F3BE9927 F236123C 097A322F F5DC4CCD 1B4459A3 F15C7DF3 65135043 4714B998 47BD8E6D 0C7EEF59 F567F6B3 BE54AB32 DCB36EBA AD312B86 A51DC9CA 9E2F31C0 EC389233 79B94B1C A20D2013 1ED38EA8 C64A79A9 8A4BA28E D01F4125 3979E3AE E731AB40 43AF14ED 6F6865E5 A6A71D31 A9358B8F 0E981BAE 41939A87 E3A78AF7 37A63386 BC562F0C A37B29B8 9FC413A6 2458291A 1D91CE91 199F608D 3D65FF56 C75138D1 9052E5EF CC9FE77F 5FD063E8 C138134F 19F88677 8C5CE006 BEF45BD9 00FD8FE6 8848A4D9 2F544327 69E30A13 4E9A2A3B 767B5B23 4FB06663 B6B51BA4 0CDDE211 EC724145 8022C763 45F4B4D6 73085146")
 	assert.Equal(t, right_ans, si.Signature)
 }
+
+func Test_SignerInfo_Verify_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	si := msig.Signatures[0].base
+	pubkey, err := msig.Signatures[0].Signer.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+	require.Nil(t, err)
+	encap := &msig.base.EncapContentInfo
+	require.Nil(t, si.verify(encap, pubkey, false))
+
+	// The received bytes are checked, not a new encoding of the attributes
+	reversed := make([]attribute, len(si.SignedAttrs))
+	for i, attr := range si.SignedAttrs {
+		reversed[len(reversed)-1-i] = attr
+	}
+	si.SignedAttrs = reversed
+	assert.Nil(t, si.verify(encap, pubkey, false))
+
+	si.SignedRaw = append([]byte(nil), si.SignedRaw...)
+	si.SignedRaw[len(si.SignedRaw)-1] ^= 1
+	cerr = si.verify(encap, pubkey, false)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_SIGNATURE, cerr.Code())
+}
+
+func Test_SignerInfo_Verify_2(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	pubkey, err := msig.Signatures[0].Signer.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+	require.Nil(t, err)
+	encap := &msig.base.EncapContentInfo
+
+	// Unsupported signature algorithm (RSASSA-PSS)
+	si := msig.Signatures[0].base
+	si.SignatureAlgorithm = algorithm_identifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}}
+	cerr = si.verify(encap, pubkey, false)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+
+	// Signature algorithm does not match the digest algorithm
+	si = msig.Signatures[0].base
+	si.SignatureAlgorithm = algorithm_identifier{Algorithm: idSha1WithRSAEncryption}
+	cerr = si.verify(encap, pubkey, false)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKOWN_ALGORITHM, cerr.Code())
+}
+
+func Test_SignerInfo_Verify_3(t *testing.T) {
+	// The content type attribute is only optional on counter signatures
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	pubkey, err := msig.Signatures[0].Signer.base.TBSCertificate.SubjectPublicKeyInfo.RSAPubKey()
+	require.Nil(t, err)
+	encap := &msig.base.EncapContentInfo
+	si := msig.Signatures[0].base
+	si.SignedAttrs = remove_attrs_by_type(si.SignedAttrs, idContentType)
+
+	cerr = si.verify(encap, pubkey, false)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_SIGNATURE, cerr.Code())
+	// SignedRaw was not changed
+	assert.Nil(t, si.verify(encap, pubkey, true))
+}
@@ -6,81 +6,179 @@ type ErrorCode int
 
 const (
 	ERR_OK = iota
+	ERR_BAD_DIGEST
+	ERR_BAD_MAC
 	ERR_BAD_SIGNATURE
 	ERR_BASIC_CONSTRAINTS_MAX_PATH_EXCEDED
+	ERR_CERT_REF_NOT_FOUND
+	ERR_CERT_TYPE_NOT_ALLOWED
+	ERR_CERT_USAGE_NOT_ALLOWED
+	ERR_CONTENT_MISMATCH
+	ERR_CRL_REF_NOT_FOUND
 	ERR_FAILED_ABS_PATH
 	ERR_FAILED_HASH
 	ERR_FAILED_TO_DECODE
+	ERR_FAILED_TO_DECRYPT
 	ERR_FAILED_TO_ENCODE
+	ERR_FAILED_TO_ENCRYPT
 	ERR_FAILED_TO_OPEN_FILE
 	ERR_FAILED_TO_SIGN
 	ERR_FAILED_TO_WRITE_FILE
+	ERR_FILE_ALREADY_EXISTS
 	ERR_FILE_NOT_EXISTS
 	ERR_GEN_KEYS
+	ERR_HAS_ARCHIVE_TIMESTAMP
 	ERR_HTTP
+	ERR_INVALID_OID
+	ERR_INVALID_POLICY_MAPPING
+	ERR_INVALID_SERIAL
 	ERR_ISSUER_NOT_FOUND
 	ERR_LOCKED_MULTI_ERROR
 	ERR_MAX_DEPTH_REACHED
+	ERR_NAME_EXCLUDED
+	ERR_NAME_NOT_PERMITTED
 	ERR_NETWORK_ERROR
+	ERR_NO_ARCHIVE_TIMESTAMP
 	ERR_NO_CERT_PATH
 	ERR_NO_CONTENT
+	ERR_NO_PRIVATE_KEY
+	ERR_NO_RECIPIENTS
+	ERR_NO_SIGNATURES
+	ERR_NO_TSA_CLIENT
+	ERR_NO_VALID_POLICY
 	ERR_NOT_AFTER_DATE
 	ERR_NOT_BEFORE_DATE
 	ERR_NOT_CA
 	ERR_NOT_IMPLEMENTED
+	ERR_NOT_RECIPIENT
+	ERR_PARSE_AUTHENTICATED_DATA
 	ERR_PARSE_CERT
+	ERR_PARSE_CONTENT_INFO
 	ERR_PARSE_CRL
+	ERR_PARSE_DIGESTED_DATA
+	ERR_PARSE_ENCRYPTED_DATA
+	ERR_PARSE_ENVELOPED_DATA
 	ERR_PARSE_EXTENSION
 	ERR_PARSE_PFX
+	ERR_PARSE_POLICY
+	ERR_PARSE_REFS
 	ERR_PARSE_RSA_PRIVKEY
 	ERR_PARSE_RSA_PUBKEY
+	ERR_PARSE_SIGNATURE
+	ERR_PARSE_TIMESTAMP
+	ERR_POLICY_ALGORITHM
+	ERR_POLICY_HASH_MISMATCH
+	ERR_POLICY_KEY_SIZE
+	ERR_POLICY_MISSING_ATTR
+	ERR_POLICY_NO_HASH
+	ERR_POLICY_NOT_AFTER_DATE
+	ERR_POLICY_NOT_BEFORE_DATE
+	ERR_POLICY_REVOKED
+	ERR_POLICY_UNKNOWN
 	ERR_READ_FILE
 	ERR_REVOKED
 	ERR_SECURE_RANDOM
+	ERR_SIGNATURE_NOT_FOUND
+	ERR_SIGNER_NOT_FOUND
+	ERR_SIGNING_CERT_MISMATCH
 	ERR_TEST_CA_IMPROPPER_NAME
+	ERR_TSA_REJECTED
+	ERR_UNKNOWN_CONTENT_TYPE
+	ERR_UNKNOWN_SIGNATURE_LEVEL
 	ERR_UNKOWN_ALGORITHM
 	ERR_UNKOWN_REVOCATION_STATUS
 	ERR_UNSUPORTED_CRITICAL_EXTENSION
+	ERR_UNSUPPORTED_CONTENT_TYPE
+	ERR_UNSUPPORTED_NAME_CONSTRAINT
 	ERR_UNZIP_ERROR
 )
 
 var errors_map_string = map[ErrorCode]string{
+	ERR_BAD_DIGEST:                         "ERR_BAD_DIGEST",
+	ERR_BAD_MAC:                            "ERR_BAD_MAC",
 	ERR_BAD_SIGNATURE:                      "ERR_BAD_SIGNATURE",
 	ERR_BASIC_CONSTRAINTS_MAX_PATH_EXCEDED: "ERR_BASIC_CONSTRAINTS_MAX_PATH_EXCEDED",
+	ERR_CERT_REF_NOT_FOUND:                 "ERR_CERT_REF_NOT_FOUND",
+	ERR_CERT_TYPE_NOT_ALLOWED:              "ERR_CERT_TYPE_NOT_ALLOWED",
+	ERR_CERT_USAGE_NOT_ALLOWED:             "ERR_CERT_USAGE_NOT_ALLOWED",
+	ERR_CONTENT_MISMATCH:                   "ERR_CONTENT_MISMATCH",
+	ERR_CRL_REF_NOT_FOUND:                  "ERR_CRL_REF_NOT_FOUND",
 	ERR_FAILED_ABS_PATH:                    "ERR_FAILED_ABS_PATH",
 	ERR_FAILED_HASH:                        "ERR_FAILED_HASH",
 	ERR_FAILED_TO_DECODE:                   "ERR_FAILED_TO_DECODE",
+	ERR_FAILED_TO_DECRYPT:                  "ERR_FAILED_TO_DECRYPT",
 	ERR_FAILED_TO_ENCODE:                   "ERR_FAILED_TO_ENCODE",
+	ERR_FAILED_TO_ENCRYPT:                  "ERR_FAILED_TO_ENCRYPT",
 	ERR_FAILED_TO_OPEN_FILE:                "ERR_FAILED_TO_OPEN_FILE",
 	ERR_FAILED_TO_SIGN:                     "ERR_FAILED_TO_SIGN",
 	ERR_FAILED_TO_WRITE_FILE:               "ERR_FAILED_TO_WRITE_FILE",
+	ERR_FILE_ALREADY_EXISTS:                "ERR_FILE_ALREADY_EXISTS",
 	ERR_FILE_NOT_EXISTS:                    "ERR_FILE_NOT_EXISTS",
 	ERR_GEN_KEYS:                           "ERR_GEN_KEYS",
+	ERR_HAS_ARCHIVE_TIMESTAMP:              "ERR_HAS_ARCHIVE_TIMESTAMP",
 	ERR_HTTP:                               "ERR_HTTP",
+	ERR_INVALID_OID:                        "ERR_INVALID_OID",
+	ERR_INVALID_POLICY_MAPPING:             "ERR_INVALID_POLICY_MAPPING",
+	ERR_INVALID_SERIAL:                     "ERR_INVALID_SERIAL",
 	ERR_ISSUER_NOT_FOUND:                   "ERR_ISSUER_NOT_FOUND",
 	ERR_LOCKED_MULTI_ERROR:                 "ERR_LOCKED_MULTI_ERROR",
 	ERR_MAX_DEPTH_REACHED:                  "ERR_MAX_DEPTH_REACHED",
+	ERR_NAME_EXCLUDED:                      "ERR_NAME_EXCLUDED",
+	ERR_NAME_NOT_PERMITTED:                 "ERR_NAME_NOT_PERMITTED",
 	ERR_NETWORK_ERROR:                      "ERR_NETWORK_ERROR",
+	ERR_NO_ARCHIVE_TIMESTAMP:               "ERR_NO_ARCHIVE_TIMESTAMP",
 	ERR_NO_CERT_PATH:                       "ERR_NO_CERT_PATH",
 	ERR_NO_CONTENT:                         "ERR_NO_CONTENT",
+	ERR_NO_PRIVATE_KEY:                     "ERR_NO_PRIVATE_KEY",
+	ERR_NO_RECIPIENTS:                      "ERR_NO_RECIPIENTS",
+	ERR_NO_SIGNATURES:                      "ERR_NO_SIGNATURES",
+	ERR_NO_TSA_CLIENT:                      "ERR_NO_TSA_CLIENT",
+	ERR_NO_VALID_POLICY:                    "ERR_NO_VALID_POLICY",
 	ERR_NOT_AFTER_DATE:                     "ERR_NOT_AFTER_DATE",
 	ERR_NOT_BEFORE_DATE:                    "ERR_NOT_BEFORE_DATE",
 	ERR_NOT_CA:                             "ERR_NOT_CA",
 	ERR_NOT_IMPLEMENTED:                    "ERR_NOT_IMPLEMENTED",
+	ERR_NOT_RECIPIENT:                      "ERR_NOT_RECIPIENT",
 	ERR_OK:                                 "ERR_OK",
+	ERR_PARSE_AUTHENTICATED_DATA:           "ERR_PARSE_AUTHENTICATED_DATA",
 	ERR_PARSE_CERT:                         "ERR_PARSE_CERT",
+	ERR_PARSE_CONTENT_INFO:                 "ERR_PARSE_CONTENT_INFO",
 	ERR_PARSE_CRL:                          "ERR_PARSE_CRL",
+	ERR_PARSE_DIGESTED_DATA:                "ERR_PARSE_DIGESTED_DATA",
+	ERR_PARSE_ENCRYPTED_DATA:               "ERR_PARSE_ENCRYPTED_DATA",
+	ERR_PARSE_ENVELOPED_DATA:               "ERR_PARSE_ENVELOPED_DATA",
 	ERR_PARSE_EXTENSION:                    "ERR_PARSE_EXTENSION",
 	ERR_PARSE_PFX:                          "ERR_PARSE_PFX",
+	ERR_PARSE_POLICY:                       "ERR_PARSE_POLICY",
+	ERR_PARSE_REFS:                         "ERR_PARSE_REFS",
 	ERR_PARSE_RSA_PRIVKEY:                  "ERR_PARSE_RSA_PRIVKEY",
 	ERR_PARSE_RSA_PUBKEY:                   "ERR_PARSE_RSA_PUBKEY",
+	ERR_PARSE_SIGNATURE:                    "ERR_PARSE_SIGNATURE",
+	ERR_PARSE_TIMESTAMP:                    "ERR_PARSE_TIMESTAMP",
+	ERR_POLICY_ALGORITHM:                   "ERR_POLICY_ALGORITHM",
+	ERR_POLICY_HASH_MISMATCH:               "ERR_POLICY_HASH_MISMATCH",
+	ERR_POLICY_KEY_SIZE:                    "ERR_POLICY_KEY_SIZE",
+	ERR_POLICY_MISSING_ATTR:                "ERR_POLICY_MISSING_ATTR",
+	ERR_POLICY_NO_HASH:                     "ERR_POLICY_NO_HASH",
+	ERR_POLICY_NOT_AFTER_DATE:              "ERR_POLICY_NOT_AFTER_DATE",
+	ERR_POLICY_NOT_BEFORE_DATE:             "ERR_POLICY_NOT_BEFORE_DATE",
+	ERR_POLICY_REVOKED:                     "ERR_POLICY_REVOKED",
+	ERR_POLICY_UNKNOWN:                     "ERR_POLICY_UNKNOWN",
 	ERR_READ_FILE:                          "ERR_READ_CERT_FILE",
 	ERR_REVOKED:                            "ERR_REVOKED",
 	ERR_SECURE_RANDOM:                      "ERR_SECURE_RANDOM",
+	ERR_SIGNATURE_NOT_FOUND:                "ERR_SIGNATURE_NOT_FOUND",
+	ERR_SIGNER_NOT_FOUND:                   "ERR_SIGNER_NOT_FOUND",
+	ERR_SIGNING_CERT_MISMATCH:              "ERR_SIGNING_CERT_MISMATCH",
 	ERR_TEST_CA_IMPROPPER_NAME:             "ERR_TEST_CA_IMPROPPER_NAME",
+	ERR_TSA_REJECTED:                       "ERR_TSA_REJECTED",
+	ERR_UNKNOWN_CONTENT_TYPE:               "ERR_UNKNOWN_CONTENT_TYPE",
+	ERR_UNKNOWN_SIGNATURE_LEVEL:            "ERR_UNKNOWN_SIGNATURE_LEVEL",
 	ERR_UNKOWN_ALGORITHM:                   "ERR_UNKOWN_ALGORITHM",
 	ERR_UNKOWN_REVOCATION_STATUS:           "ERR_UNKOWN_REVOCATION_STATUS",
 	ERR_UNSUPORTED_CRITICAL_EXTENSION:      "ERR_UNSUPORTED_CRITICAL_EXTENSION",
+	ERR_UNSUPPORTED_CONTENT_TYPE:           "ERR_UNSUPPORTED_CONTENT_TYPE",
+	ERR_UNSUPPORTED_NAME_CONSTRAINT:        "ERR_UNSUPPORTED_NAME_CONSTRAINT",
 	ERR_UNZIP_ERROR:                        "ERR_UNZIP_ERROR",
 }
 
@@ -0,0 +1,584 @@
+package libICP
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// Size of each OCTET STRING chunk used when writing indefinite length (BER) content.
+const _STREAM_CHUNK_SIZE = 32 * 1024
+
+// Header of a BER encoded element. Length is -1 for indefinite length elements.
+type ber_header struct {
+	Class      int
+	Tag        int
+	IsCompound bool
+	Length     int64
+	Raw        []byte
+}
+
+func (h ber_header) is_eoc() bool {
+	return h.Class == 0 && h.Tag == 0 && !h.IsCompound && h.Length == 0
+}
+
+func (h ber_header) is(class, tag int) bool {
+	return h.Class == class && h.Tag == tag
+}
+
+// Maximum nesting of constructed elements read by ber_reader.
+const _BER_MAX_DEPTH = 64
+
+// Reads BER elements from a stream while keeping track of the position, so definite length containers can be handled. Size is the total size of the input, or -1 if it is unknown.
+type ber_reader struct {
+	r     *bufio.Reader
+	pos   int64
+	size  int64
+	depth int
+}
+
+// If r has a Len method (like bytes.Reader), lengths larger than the remaining input are rejected right away.
+func new_ber_reader(r io.Reader) *ber_reader {
+	br := &ber_reader{r: bufio.NewReader(r), size: -1}
+	if sized, ok := r.(interface{ Len() int }); ok {
+		br.size = int64(sized.Len())
+	}
+	return br
+}
+
+func (br *ber_reader) read_byte() (byte, error) {
+	b, err := br.r.ReadByte()
+	if err == nil {
+		br.pos++
+	}
+	return b, err
+}
+
+// The data is read in pieces, so a forged length can not make it allocate more memory than the input has.
+func (br *ber_reader) read_full(n int64) ([]byte, error) {
+	if br.size >= 0 && n > br.size-br.pos {
+		return nil, errors.New("element longer than the input")
+	}
+	buf := new(bytes.Buffer)
+	copied, err := io.CopyN(buf, br.r, n)
+	br.pos += copied
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+// Must be called before reading the children of a constructed element. Every call must be matched by a call to leave.
+func (br *ber_reader) enter() error {
+	br.depth++
+	if br.depth > _BER_MAX_DEPTH {
+		return errors.New("elements nested too deeply")
+	}
+	return nil
+}
+
+func (br *ber_reader) leave() {
+	br.depth--
+}
+
+func (br *ber_reader) copy_n(w io.Writer, n int64) error {
+	if br.size >= 0 && n > br.size-br.pos {
+		return errors.New("element longer than the input")
+	}
+	copied, err := io.CopyN(w, br.r, n)
+	br.pos += copied
+	return err
+}
+
+// Returns true if the next two bytes are an end-of-contents marker. Nothing is consumed.
+func (br *ber_reader) peek_eoc() bool {
+	buf, err := br.r.Peek(2)
+	return err == nil && buf[0] == 0 && buf[1] == 0
+}
+
+func (br *ber_reader) read_header() (ber_header, error) {
+	h := ber_header{}
+	b, err := br.read_byte()
+	if err != nil {
+		return h, err
+	}
+	h.Raw = append(h.Raw, b)
+	h.Class = int(b >> 6)
+	h.IsCompound = b&0x20 != 0
+	h.Tag = int(b & 0x1f)
+	if h.Tag == 0x1f {
+		// High tag number form
+		h.Tag = 0
+		for {
+			b, err = br.read_byte()
+			if err != nil {
+				return h, err
+			}
+			h.Raw = append(h.Raw, b)
+			h.Tag = h.Tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	b, err = br.read_byte()
+	if err != nil {
+		return h, err
+	}
+	h.Raw = append(h.Raw, b)
+	switch {
+	case b&0x80 == 0:
+		h.Length = int64(b)
+	case b == 0x80:
+		if !h.IsCompound {
+			return h, errors.New("indefinite length on primitive element")
+		}
+		h.Length = -1
+	default:
+		n := int(b & 0x7f)
+		if n > 8 {
+			return h, errors.New("element too long")
+		}
+		for i := 0; i < n; i++ {
+			b, err = br.read_byte()
+			if err != nil {
+				return h, err
+			}
+			h.Raw = append(h.Raw, b)
+			h.Length = h.Length<<8 | int64(b)
+		}
+		if h.Length < 0 {
+			return h, errors.New("element too long")
+		}
+	}
+	return h, nil
+}
+
+// Reads a whole element and returns it using only definite lengths, so it can be parsed by the asn1 package.
+func (br *ber_reader) read_element_der() ([]byte, ber_header, error) {
+	h, err := br.read_header()
+	if err != nil {
+		return nil, h, err
+	}
+	ans, err := br.read_body_der(h)
+	return ans, h, err
+}
+
+func (br *ber_reader) read_body_der(h ber_header) ([]byte, error) {
+	// DER forbids constructed OCTET STRINGs
+	if h.is(asn1.ClassUniversal, asn1.TagOctetString) && h.IsCompound {
+		if h.Length >= 0 && br.size >= 0 && h.Length > br.size-br.pos {
+			return nil, errors.New("element longer than the input")
+		}
+		octets := new(bytes.Buffer)
+		if err := br.stream_octets(h, octets); err != nil {
+			return nil, err
+		}
+		return asn1.Marshal(octets.Bytes())
+	}
+	if h.Length >= 0 {
+		body, err := br.read_full(h.Length)
+		if err != nil {
+			return nil, err
+		}
+		return append(h.Raw, body...), nil
+	}
+
+	// Indefinite length: read children until end-of-contents
+	if err := br.enter(); err != nil {
+		return nil, err
+	}
+	defer br.leave()
+	body := make([]byte, 0)
+	for {
+		child, child_h, err := br.read_element_der()
+		if err != nil {
+			return nil, err
+		}
+		if child_h.is_eoc() {
+			break
+		}
+		body = append(body, child...)
+	}
+	raw := asn1.RawValue{Class: h.Class, Tag: h.Tag, IsCompound: h.IsCompound, Bytes: body}
+	return asn1.Marshal(raw)
+}
+
+// Writes the content of an OCTET STRING (either primitive or constructed) to w.
+func (br *ber_reader) stream_octets(h ber_header, w io.Writer) error {
+	if !h.is(asn1.ClassUniversal, asn1.TagOctetString) {
+		return errors.New("expected OCTET STRING")
+	}
+	if !h.IsCompound {
+		return br.copy_n(w, h.Length)
+	}
+	if err := br.enter(); err != nil {
+		return err
+	}
+	defer br.leave()
+	end := br.pos + h.Length
+	for h.Length < 0 || br.pos < end {
+		child, err := br.read_header()
+		if err != nil {
+			return err
+		}
+		if child.is_eoc() {
+			if h.Length < 0 {
+				return nil
+			}
+			return errors.New("unexpected end-of-contents")
+		}
+		if err := br.stream_octets(child, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns true if there are more elements inside a container that started at start with the given header.
+func (br *ber_reader) has_more(h ber_header, start int64) bool {
+	if h.Length < 0 {
+		return !br.peek_eoc()
+	}
+	return br.pos < start+h.Length
+}
+
+// Consumes the end-of-contents marker of indefinite length containers.
+func (br *ber_reader) finish(h ber_header) error {
+	if h.Length >= 0 {
+		return nil
+	}
+	eoc, err := br.read_header()
+	if err != nil {
+		return err
+	}
+	if !eoc.is_eoc() {
+		return errors.New("expected end-of-contents")
+	}
+	return nil
+}
+
+// Only used for decoding the elements that come after the encapsulated content.
+type signed_data_tail_decode struct {
+	RawContent   asn1.RawContent
+	Certificates []asn1.RawValue      `asn1:"tag:0,optional,set,omitempty"`
+	CRLs         []asn1.RawValue      `asn1:"tag:1,optional,set,omitempty"`
+	SignerInfos  []signer_info_decode `asn1:"set"`
+}
+
+// Parses a (possibly BER encoded) signed data without keeping the encapsulated content in memory. The content is written to content_out (if not nil) and is hashed with all the algorithms listed on DigestAlgorithms in a single pass.
+func parse_signed_data_stream(r io.Reader, content_out io.Writer) (sd signed_data_decode, hashes map[string][]byte, has_content bool, cerr CodedError) {
+	br := new_ber_reader(r)
+	hashes = make(map[string][]byte)
+	fail := func(msg string, err error) CodedError {
+		merr := NewMultiError(msg, ERR_PARSE_SIGNATURE, nil, err)
+		merr.SetParam("position", br.pos)
+		return merr
+	}
+
+	// Content info
+	ci_h, err := br.read_header()
+	if err != nil || !ci_h.is(asn1.ClassUniversal, asn1.TagSequence) {
+		return sd, nil, false, fail("failed to parse CMS content info", err)
+	}
+	raw, _, err := br.read_element_der()
+	content_type := asn1.ObjectIdentifier{}
+	if err == nil {
+		_, err = asn1.Unmarshal(raw, &content_type)
+	}
+	if err != nil {
+		return sd, nil, false, fail("failed to parse CMS content type", err)
+	}
+	if !content_type.Equal(idSignedData) {
+		merr := NewMultiError("CMS content is not signed data", ERR_PARSE_SIGNATURE, nil)
+		merr.SetParam("content-type", content_type.String())
+		return sd, nil, false, merr
+	}
+	explicit_h, err := br.read_header()
+	if err != nil || !explicit_h.is(asn1.ClassContextSpecific, 0) {
+		return sd, nil, false, fail("failed to parse CMS content info", err)
+	}
+
+	// Signed data
+	sd_h, err := br.read_header()
+	if err != nil || !sd_h.is(asn1.ClassUniversal, asn1.TagSequence) {
+		return sd, nil, false, fail("failed to parse CMS signed data", err)
+	}
+	sd_start := br.pos
+	raw, _, err = br.read_element_der()
+	if err == nil {
+		_, err = asn1.Unmarshal(raw, &sd.Version)
+	}
+	if err != nil {
+		return sd, nil, false, fail("failed to parse signed data version", err)
+	}
+	raw, _, err = br.read_element_der()
+	if err == nil {
+		_, err = asn1.UnmarshalWithParams(raw, &sd.DigestAlgorithms, "set")
+	}
+	if err != nil {
+		return sd, nil, false, fail("failed to parse signed data digest algorithms", err)
+	}
+
+	// Prepare hashers
+	writers := make([]io.Writer, 0)
+	hashers := make(map[string]hash.Hash)
+	for _, alg := range sd.DigestAlgorithms {
+		hasher, _, cerr := get_hasher(alg)
+		if cerr == nil {
+			hashers[alg.Algorithm.String()] = hasher
+			writers = append(writers, hasher)
+		}
+	}
+	if content_out != nil {
+		writers = append(writers, content_out)
+	}
+
+	// Encapsulated content info
+	encap_h, err := br.read_header()
+	if err != nil || !encap_h.is(asn1.ClassUniversal, asn1.TagSequence) {
+		return sd, nil, false, fail("failed to parse encapsulated content info", err)
+	}
+	encap_start := br.pos
+	raw, _, err = br.read_element_der()
+	if err == nil {
+		_, err = asn1.Unmarshal(raw, &sd.EncapContentInfo.EContentType)
+	}
+	if err != nil {
+		return sd, nil, false, fail("failed to parse encapsulated content type", err)
+	}
+	if br.has_more(encap_h, encap_start) {
+		econtent_h, err := br.read_header()
+		if err != nil || !econtent_h.is(asn1.ClassContextSpecific, 0) {
+			return sd, nil, false, fail("failed to parse encapsulated content", err)
+		}
+		octets_h, err := br.read_header()
+		if err == nil {
+			err = br.stream_octets(octets_h, io.MultiWriter(writers...))
+		}
+		if err == nil {
+			err = br.finish(econtent_h)
+		}
+		if err != nil {
+			return sd, nil, false, fail("failed to read encapsulated content", err)
+		}
+		has_content = true
+		for k, hasher := range hashers {
+			hashes[k] = hasher.Sum(nil)
+		}
+	}
+	if err := br.finish(encap_h); err != nil {
+		return sd, nil, false, fail("failed to parse encapsulated content info", err)
+	}
+
+	// Certificates, CRLs and signer infos
+	tail := make([]byte, 0)
+	for br.has_more(sd_h, sd_start) {
+		raw, _, err = br.read_element_der()
+		if err != nil {
+			return sd, nil, false, fail("failed to parse signed data", err)
+		}
+		tail = append(tail, raw...)
+	}
+	raw, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: tail})
+	tail_decode := signed_data_tail_decode{}
+	if err == nil {
+		_, err = asn1.Unmarshal(raw, &tail_decode)
+	}
+	if err != nil {
+		return sd, nil, false, fail("failed to parse signed data", err)
+	}
+	sd.Certificates = tail_decode.Certificates
+	sd.CRLs = tail_decode.CRLs
+	sd.SignerInfos = tail_decode.SignerInfos
+
+	return sd, hashes, has_content, nil
+}
+
+// Parses a signature (DER or BER) from a stream without keeping the attached content in memory. The content (if any) is written to content_out, which may be nil.
+//
+// The content digests are calculated while reading, so CheckAll works without the content. Attached content is not kept, so the signature will be marshaled as detached.
+func NewMultSignatureFromReader(r io.Reader, content_out io.Writer) (*MultSignature, CodedError) {
+	sd, hashes, _, cerr := parse_signed_data_stream(r, content_out)
+	if cerr != nil {
+		return nil, cerr
+	}
+	msig := new(MultSignature)
+	if cerr := msig.load_from_decode(sd); cerr != nil {
+		return nil, cerr
+	}
+	msig.base.EncapContentInfo.hashes = hashes
+	return msig, nil
+}
+
+// Returns the content type of a (possibly BER encoded) content info without parsing its content.
+func peek_content_type(raw []byte) (asn1.ObjectIdentifier, error) {
+	br := new_ber_reader(bytes.NewReader(raw))
+	h, err := br.read_header()
+	if err != nil {
+		return nil, err
+	}
+	if !h.is(asn1.ClassUniversal, asn1.TagSequence) {
+		return nil, errors.New("content info is not a SEQUENCE")
+	}
+	oid_raw, _, err := br.read_element_der()
+	if err != nil {
+		return nil, err
+	}
+	content_type := asn1.ObjectIdentifier{}
+	_, err = asn1.Unmarshal(oid_raw, &content_type)
+	return content_type, err
+}
+
+// Parses a BER encoded signature which is entirely in memory.
+func new_mult_signature_from_ber(raw []byte) (*MultSignature, CodedError) {
+	content := new(bytes.Buffer)
+	sd, hashes, has_content, cerr := parse_signed_data_stream(bytes.NewReader(raw), content)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if has_content {
+		sd.EncapContentInfo.EContent = content.Bytes()
+	}
+	msig := new(MultSignature)
+	if cerr := msig.load_from_decode(sd); cerr != nil {
+		return nil, cerr
+	}
+	msig.base.EncapContentInfo.hashes = hashes
+	return msig, nil
+}
+
+// Signs (SHA256 with RSA) the content read from r in a single pass. The result is a detached signature. (see MultSignature.WriteAttachedTo to include the content)
+func (pfx PFX) SignReader(r io.Reader) (*MultSignature, CodedError) {
+	msig := new_mult_signature(nil, false)
+	alg := algorithm_identifier{Algorithm: idSha256}
+	if cerr := msig.base.EncapContentInfo.hash_reader(r, []algorithm_identifier{alg}); cerr != nil {
+		return nil, cerr
+	}
+	if cerr := msig.add_signature_at(pfx, nil, time.Now()); cerr != nil {
+		return nil, cerr
+	}
+	return msig, nil
+}
+
+// Writes the signature with the content read from r attached, using indefinite length (BER) encoding so the content never needs to be entirely in memory.
+//
+// The content is hashed while it is written and compared with the message digest of each signer. On a mismatch, nothing after the content is written and ERR_CONTENT_MISMATCH is returned, so whatever was already written to w MUST be discarded.
+//
+// Possible errors are: ERR_NO_CONTENT, ERR_UNKOWN_ALGORITHM, ERR_CONTENT_MISMATCH, ERR_FAILED_TO_ENCODE, ERR_READ_FILE, ERR_FAILED_TO_WRITE_FILE
+func (msig *MultSignature) WriteAttachedTo(w io.Writer, content io.Reader) CodedError {
+	msig.sync_signer_infos()
+
+	// Find out which digest each signer expects
+	expected := make([][]byte, len(msig.base.SignerInfos))
+	hashers := make(map[string]hash.Hash)
+	hash_writers := make([]io.Writer, 0)
+	for i, si := range msig.base.SignerInfos {
+		ok := false
+		if attr, found := si.get_signed_attr(idMessageDigest); found {
+			ok = attr.first_value(&expected[i]) == nil
+		} else {
+			expected[i], ok = msig.content_digest(si.DigestAlgorithm)
+		}
+		if !ok {
+			merr := NewMultiError("unable to tell which content was signed", ERR_NO_CONTENT, nil)
+			merr.SetParam("signer", i)
+			return merr
+		}
+		alg := si.DigestAlgorithm.Algorithm.String()
+		if _, ok := hashers[alg]; ok {
+			continue
+		}
+		hasher, _, cerr := get_hasher(si.DigestAlgorithm)
+		if cerr != nil {
+			return cerr
+		}
+		hashers[alg] = hasher
+		hash_writers = append(hash_writers, hasher)
+	}
+	hash_writer := io.MultiWriter(hash_writers...)
+
+	// Marshal everything as if it were detached
+	sd := msig.base
+	sd.RawContent = nil
+	sd.EncapContentInfo.EContent = nil
+	sd.EncapContentInfo.RawContent = nil
+	sd_raw, err := asn1.Marshal(sd)
+	if err != nil {
+		return NewMultiError("failed to marshal signed data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	sd_body := asn1.RawValue{}
+	if _, err = asn1.Unmarshal(sd_raw, &sd_body); err != nil {
+		return NewMultiError("failed to marshal signed data", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	// Split it: version, digest algorithms, encapsulated content info and the rest
+	elems := make([][]byte, 0)
+	rest := sd_body.Bytes
+	for len(rest) > 0 {
+		elem := asn1.RawValue{}
+		rest, err = asn1.Unmarshal(rest, &elem)
+		if err != nil {
+			return NewMultiError("failed to marshal signed data", ERR_FAILED_TO_ENCODE, nil, err)
+		}
+		elems = append(elems, elem.FullBytes)
+	}
+	if len(elems) < 4 {
+		return NewMultiError("signed data is incomplete", ERR_FAILED_TO_ENCODE, nil)
+	}
+	oid_signed_data, _ := asn1.Marshal(idSignedData)
+	oid_content_type, err := asn1.Marshal(msig.base.EncapContentInfo.EContentType)
+	if err != nil {
+		return NewMultiError("failed to marshal content type", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+
+	bw := bufio.NewWriter(w)
+	write := func(parts ...[]byte) {
+		for _, part := range parts {
+			bw.Write(part)
+		}
+	}
+	ber_seq := []byte{0x30, 0x80}
+	ber_explicit_0 := []byte{0xa0, 0x80}
+	ber_octets := []byte{0x24, 0x80}
+	eoc := []byte{0, 0}
+
+	write(ber_seq, oid_signed_data, ber_explicit_0, ber_seq, elems[0], elems[1])
+	write(ber_seq, oid_content_type, ber_explicit_0, ber_octets)
+	buf := make([]byte, _STREAM_CHUNK_SIZE)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			hash_writer.Write(buf[:n])
+			chunk, _ := asn1.Marshal(buf[:n])
+			write(chunk)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return NewMultiError("failed to read content", ERR_READ_FILE, nil, err)
+		}
+	}
+	for i, si := range msig.base.SignerInfos {
+		digest := hashers[si.DigestAlgorithm.Algorithm.String()].Sum(nil)
+		if !bytes.Equal(digest, expected[i]) {
+			merr := NewMultiError("content does not match the signed message digest", ERR_CONTENT_MISMATCH, nil)
+			merr.SetParam("signer", i)
+			return merr
+		}
+	}
+	write(eoc, eoc, eoc)
+	write(elems[3:]...)
+	write(eoc, eoc, eoc)
+
+	if err := bw.Flush(); err != nil {
+		return NewMultiError("failed to write signature", ERR_FAILED_TO_WRITE_FILE, nil, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package libICP
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewMultSignatureFromReader_1(t *testing.T) {
+	// BER file generated with: openssl cms -sign -stream -nodetach -binary -outform DER
+	f, err := os.Open("data/test-sigs/ciclano_attached_ber.p7s")
+	require.Nil(t, err)
+	defer f.Close()
+
+	content := new(bytes.Buffer)
+	msig, cerr := NewMultSignatureFromReader(f, content)
+	require.Nil(t, cerr)
+	assert.Equal(t, "The quick fox jumps over the lazy dog.", content.String())
+	assert.Nil(t, msig.ContentAttached)
+	require.Equal(t, 1, len(msig.Signatures))
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=FakeBank Certificados Digitais/CN=Ciclano de Souza:98765432100", msig.Signatures[0].Signer.Subject)
+
+	store := get_test_store(t, false)
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	status := msig.Signatures[0].Status
+	assert.True(t, status.Integrity)
+	assert.Nil(t, status.SignerCertError)
+}
+
+func Test_NewMultSignatureFromReader_2(t *testing.T) {
+	_, cerr := NewMultSignatureFromReader(strings.NewReader("not a signature"), nil)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_SIGNATURE, cerr.Code())
+}
+
+func Test_NewMultSignatureFromBytes_BER_1(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached_ber.p7s")
+	require.Nil(t, cerr)
+	assert.Equal(t, []byte("The quick fox jumps over the lazy dog."), msig.ContentAttached)
+
+	store := get_test_store(t, false)
+	cerr = msig.check_all_at(store, time.Date(2018, 9, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig.Signatures[0].Status.Integrity)
+}
+
+func Test_PFX_SignReader_1(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	content := "The quick fox jumps over the lazy dog."
+	msig, cerr := pfx.SignReader(strings.NewReader(content))
+	require.Nil(t, cerr)
+	assert.True(t, msig.base.EncapContentInfo.IsDetached())
+
+	// Write it with the content attached and read it back
+	buf := new(bytes.Buffer)
+	require.Nil(t, msig.WriteAttachedTo(buf, strings.NewReader(content)))
+
+	read_content := new(bytes.Buffer)
+	msig2, cerr := NewMultSignatureFromReader(bytes.NewReader(buf.Bytes()), read_content)
+	require.Nil(t, cerr)
+	assert.Equal(t, content, read_content.String())
+
+	store := get_test_store(t, true)
+	cerr = msig2.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig2.Signatures[0].Status.Integrity)
+	assert.Nil(t, msig2.Signatures[0].Status.SignerCertError)
+
+	// The same file should also be readable when entirely in memory
+	msig3, cerr := NewMultSignatureFromBytes(buf.Bytes())
+	require.Nil(t, cerr)
+	assert.Equal(t, []byte(content), msig3.ContentAttached)
+}
+
+func Test_MultSignature_WriteAttachedTo_1(t *testing.T) {
+	// Content larger than a single chunk
+	pfx := get_ciclano_pfx(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), _STREAM_CHUNK_SIZE/8+3)
+	msig, cerr := pfx.SignReader(bytes.NewReader(content))
+	require.Nil(t, cerr)
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, msig.WriteAttachedTo(buf, bytes.NewReader(content)))
+	msig2, cerr := NewMultSignatureFromBytes(buf.Bytes())
+	require.Nil(t, cerr)
+	assert.Equal(t, content, msig2.ContentAttached)
+
+	store := get_test_store(t, true)
+	cerr = msig2.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	assert.True(t, msig2.Signatures[0].Status.Integrity)
+}
+
+func Test_EncapsulatedContentInfo_HashAll_1(t *testing.T) {
+	ec := encapsulated_content_info{}
+	require.Nil(t, ec.SetFallbackFile("data/hash_test.txt"))
+	cerr := ec.hash_all([]algorithm_identifier{{Algorithm: idSha1}, {Algorithm: idSha256}})
+	require.Nil(t, cerr)
+	assert.Equal(t, 2, len(ec.hashes))
+	assert.Equal(t, "82D510A7AA6703659FB9243E3E6B1CB9A4F51C04", to_hex(ec.hashes[idSha1.String()]))
+	assert.Equal(t, 32, len(ec.hashes[idSha256.String()]))
+}
+
+// The OID has a forged length, which must not be allocated
+var test_ber_huge_length = []byte{0x30, 0x80, 0x06, 0x88, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+func Test_NewMultSignatureFromBytes_BER_2(t *testing.T) {
+	_, cerr := NewMultSignatureFromBytes(test_ber_huge_length)
+	require.NotNil(t, cerr)
+	_, cerr = ParseCMS(test_ber_huge_length)
+	require.NotNil(t, cerr)
+	_, cerr = ParseContentInfo(test_ber_huge_length)
+	require.NotNil(t, cerr)
+	_, cerr = NewEnvelopeFromBytes(test_ber_huge_length)
+	require.NotNil(t, cerr)
+	// The size of the input is unknown
+	_, cerr = NewMultSignatureFromReader(io.MultiReader(bytes.NewReader(test_ber_huge_length)), nil)
+	require.NotNil(t, cerr)
+}
+
+func Test_BerReader_ReadElementDER_1(t *testing.T) {
+	raw := bytes.Repeat([]byte{0x30, 0x80}, 2*_BER_MAX_DEPTH)
+	_, _, err := new_ber_reader(bytes.NewReader(raw)).read_element_der()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "nested")
+	_, err = ParseContentInfo(raw)
+	require.NotNil(t, err)
+}
+
+func Test_MultSignature_WriteAttachedTo_2(t *testing.T) {
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignReader(strings.NewReader("The quick fox jumps over the lazy dog."))
+	require.Nil(t, cerr)
+
+	buf := new(bytes.Buffer)
+	cerr = msig.WriteAttachedTo(buf, strings.NewReader("The quick fox jumps over the lazy cat."))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_CONTENT_MISMATCH, cerr.Code())
+}
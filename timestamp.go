@@ -0,0 +1,519 @@
+package libICP
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+type message_imprint struct {
+	RawContent    asn1.RawContent
+	HashAlgorithm algorithm_identifier
+	HashedMessage []byte
+}
+
+type time_stamp_req struct {
+	RawContent     asn1.RawContent
+	Version        int
+	MessageImprint message_imprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional,omitempty"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []extension           `asn1:"tag:0,optional,omitempty"`
+}
+
+const (
+	PKI_STATUS_GRANTED = iota
+	PKI_STATUS_GRANTED_WITH_MODS
+	PKI_STATUS_REJECTION
+	PKI_STATUS_WAITING
+	PKI_STATUS_REVOCATION_WARNING
+	PKI_STATUS_REVOCATION_NOTIFICATION
+)
+
+type pki_status_info struct {
+	RawContent   asn1.RawContent
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional,omitempty"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// Returns the status text (PKIFreeText) as a single string.
+func (info pki_status_info) text() string {
+	ans := ""
+	for _, item := range info.StatusString {
+		if ans != "" {
+			ans += "; "
+		}
+		ans += string(item.Bytes)
+	}
+	return ans
+}
+
+type time_stamp_resp struct {
+	RawContent     asn1.RawContent
+	Status         pki_status_info
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	RawContent asn1.RawContent
+	Seconds    int `asn1:"optional"`
+	Millis     int `asn1:"tag:0,optional"`
+	Micros     int `asn1:"tag:1,optional"`
+}
+
+// Extensions are ignored.
+type tst_info struct {
+	RawContent     asn1.RawContent
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint message_imprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       accuracy      `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"tag:0,optional"`
+}
+
+// Represents a RFC 3161 time stamp token, which proves that some data (usually a signature value) existed at GenTime.
+type TimeStamp struct {
+	token *MultSignature
+	info  tst_info
+	// DER encoded token as it was read (or received)
+	raw []byte
+
+	// When the time stamp was generated according to the TSA (Time Stamp Authority).
+	GenTime time.Time
+	// Hexadecimal serial number. Ex: "0x3f2a"
+	Serial string
+	// OID of the TSA policy
+	Policy string
+	// Certificate of the TSA (if it was included in the token)
+	TSA    Certificate
+	Status TimeStampCheck
+}
+
+type TimeStampCheck struct {
+	// True if the time stamp signature is valid
+	Integrity bool
+	// True if the time stamp refers to the data it is attached to (ex: the signature value)
+	ImprintMatches bool
+	// Why the data the time stamp refers to could not be hashed, if that is the case (ex: the detached content is missing)
+	ImprintError CodedError
+	RootCA       string
+	TSACertError CodedError
+}
+
+func (check TimeStampCheck) IsValid() bool {
+	return check.Integrity && check.ImprintMatches && check.TSACertError == nil
+}
+
+// Parses a DER encoded time stamp token (a CMS ContentInfo with signed data about a TSTInfo).
+func NewTimeStampFromBytes(raw []byte) (*TimeStamp, CodedError) {
+	token, cerr := NewMultSignatureFromBytes(raw)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !token.base.EncapContentInfo.EContentType.Equal(idCtTSTInfo) {
+		merr := NewMultiError("time stamp token content is not TSTInfo", ERR_PARSE_TIMESTAMP, nil)
+		merr.SetParam("content-type", token.base.EncapContentInfo.EContentType.String())
+		return nil, merr
+	}
+	if len(token.Signatures) != 1 {
+		merr := NewMultiError("time stamp token MUST have exactly one signature", ERR_PARSE_TIMESTAMP, nil)
+		merr.SetParam("len(Signatures)", len(token.Signatures))
+		return nil, merr
+	}
+
+	ts := new(TimeStamp)
+	ts.token = token
+	ts.raw = raw
+	_, err := asn1.Unmarshal(token.base.EncapContentInfo.EContent, &ts.info)
+	if err != nil {
+		merr := NewMultiError("failed to parse TSTInfo", ERR_PARSE_TIMESTAMP, nil, err)
+		merr.SetParam("raw-data", token.base.EncapContentInfo.EContent)
+		return nil, merr
+	}
+	ts.GenTime = ts.info.GenTime
+	if ts.info.SerialNumber != nil {
+		ts.Serial = "0x" + ts.info.SerialNumber.Text(16)
+	}
+	ts.Policy = ts.info.Policy.String()
+	ts.TSA = token.Signatures[0].Signer
+	return ts, nil
+}
+
+// Returns the DER encoded time stamp token.
+func (ts TimeStamp) MarshalDER() ([]byte, CodedError) {
+	return ts.token.MarshalDER()
+}
+
+// Verifies the time stamp signature, its TSA certificate (see check_digest_at) and whether it refers to data.
+func (ts *TimeStamp) check_at(store *CAStore, data []byte, now time.Time) {
+	var digest []byte
+	hasher, _, cerr := get_hasher(ts.info.MessageImprint.HashAlgorithm)
+	if cerr == nil {
+		digest = run_hash(hasher, data)
+	}
+	ts.check_digest_at(store, digest, now)
+	ts.Status.ImprintError = cerr
+}
+
+// Same as check_at, but receives the digest of the data (with the message imprint hash algorithm) instead. A nil digest never matches.
+//
+// The TSA certificate is validated at the generation time, so time stamps stay valid after it expires. Revoked certificates are still rejected, whenever they were revoked. A generation time later than now is not trusted, so now is used instead.
+func (ts *TimeStamp) check_digest_at(store *CAStore, digest []byte, now time.Time) {
+	ts.Status = TimeStampCheck{}
+	ts.Status.ImprintMatches = digest != nil && bytes.Equal(digest, ts.info.MessageImprint.HashedMessage)
+	if !ts.GenTime.IsZero() && ts.GenTime.Before(now) {
+		now = ts.GenTime
+	}
+
+	if cerr := ts.token.check_all_for_at(store.without_cert_policies(), CERT_USAGE_TIMESTAMPING, now); cerr != nil {
+		ts.Status.TSACertError = cerr
+		return
+	}
+	status := ts.token.Signatures[0].Status
+	ts.Status.Integrity = status.Integrity
+	ts.Status.RootCA = status.RootCA
+	ts.Status.TSACertError = status.SignerCertError
+}
+
+// RFC 3161 Time Stamp Protocol client. Requests are sent via HTTP POST.
+type TSAClient struct {
+	URL string
+	// OID of the TSA policy to be requested (optional)
+	Policy string
+	// OID of the hash algorithm used on the message imprint. Defaults to SHA256.
+	HashAlgorithm string
+	// If nil, http.DefaultTransport is used. It may be replaced in order to use a local TSA. (ex: for tests)
+	Transport http.RoundTripper
+	Timeout   time.Duration
+}
+
+func NewTSAClient(url string) *TSAClient {
+	return &TSAClient{URL: url, Timeout: 10 * time.Second}
+}
+
+// Requests a time stamp token about data. The request always asks for the TSA certificate to be included.
+//
+// Possible errors are: ERR_UNKOWN_ALGORITHM, ERR_HTTP, ERR_TSA_REJECTED, ERR_PARSE_TIMESTAMP, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCODE
+func (client TSAClient) RequestTimeStamp(data []byte) (*TimeStamp, CodedError) {
+	alg_id := client.hash_alg()
+	hasher, _, cerr := get_hasher(alg_id)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return client.request(alg_id, run_hash(hasher, data))
+}
+
+func (client TSAClient) hash_alg() algorithm_identifier {
+	if client.HashAlgorithm == "" {
+		return algorithm_identifier{Algorithm: idSha256}
+	}
+	return algorithm_identifier{Algorithm: str2oid_key(client.HashAlgorithm)}
+}
+
+func (client TSAClient) request(alg_id algorithm_identifier, digest []byte) (*TimeStamp, CodedError) {
+	// Build request
+	req := time_stamp_req{Version: 1, CertReq: true}
+	req.MessageImprint.HashAlgorithm = alg_id
+	req.MessageImprint.HashedMessage = digest
+	if client.Policy != "" {
+		req.ReqPolicy = str2oid_key(client.Policy)
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, NewMultiError("failed to generate nonce", ERR_SECURE_RANDOM, nil, err)
+	}
+	req.Nonce = nonce
+	req_raw, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal time stamp request", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+
+	// Send it
+	resp_raw, cerr := client.post(req_raw)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	// Parse response
+	resp := time_stamp_resp{}
+	_, err = asn1.Unmarshal(resp_raw, &resp)
+	if err != nil {
+		merr := NewMultiError("failed to parse time stamp response", ERR_PARSE_TIMESTAMP, nil, err)
+		merr.SetParam("raw-data", resp_raw)
+		return nil, merr
+	}
+	if resp.Status.Status != PKI_STATUS_GRANTED && resp.Status.Status != PKI_STATUS_GRANTED_WITH_MODS {
+		merr := NewMultiError("time stamp request was rejected", ERR_TSA_REJECTED, nil)
+		merr.SetParam("status", resp.Status.Status)
+		merr.SetParam("status-string", resp.Status.text())
+		merr.SetParam("URL", client.URL)
+		return nil, merr
+	}
+	ts, cerr := NewTimeStampFromBytes(resp.TimeStampToken.FullBytes)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	// Ensure it is the response to our request
+	if ts.info.Nonce == nil || ts.info.Nonce.Cmp(nonce) != 0 {
+		merr := NewMultiError("time stamp nonce does not match the request", ERR_PARSE_TIMESTAMP, nil)
+		merr.SetParam("URL", client.URL)
+		return nil, merr
+	}
+	if !ts.info.MessageImprint.HashAlgorithm.Algorithm.Equal(alg_id.Algorithm) || !bytes.Equal(ts.info.MessageImprint.HashedMessage, digest) {
+		merr := NewMultiError("time stamp message imprint does not match the request", ERR_PARSE_TIMESTAMP, nil)
+		merr.SetParam("URL", client.URL)
+		return nil, merr
+	}
+	return ts, nil
+}
+
+func (client TSAClient) post(req_raw []byte) ([]byte, CodedError) {
+	http_client := &http.Client{
+		Transport: client.Transport,
+		Timeout:   client.Timeout,
+	}
+	resp, err := http_client.Post(client.URL, "application/timestamp-query", bytes.NewReader(req_raw))
+	if err != nil {
+		merr := NewMultiError("failed to use POST method", ERR_HTTP, nil, err)
+		merr.SetParam("URL", client.URL)
+		return nil, merr
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		merr := NewMultiError("failed to read http response", ERR_HTTP, nil, err)
+		merr.SetParam("URL", client.URL)
+		return nil, merr
+	}
+	if resp.StatusCode != http.StatusOK {
+		merr := NewMultiError("unexpected http status", ERR_HTTP, nil)
+		merr.SetParam("URL", client.URL)
+		merr.SetParam("status", resp.Status)
+		return nil, merr
+	}
+	return raw, nil
+}
+
+// Adds a signature time stamp token (RFC 3161) over the signature value of sig, which MUST be one of this file signatures. This turns an AD-RB signature into an AD-RT one.
+//
+// Possible errors are: ERR_SIGNATURE_NOT_FOUND, ERR_NO_TSA_CLIENT, ERR_HTTP, ERR_TSA_REJECTED, ERR_PARSE_TIMESTAMP, ERR_SECURE_RANDOM, ERR_FAILED_TO_ENCODE
+func (msig *MultSignature) AddTimeStamp(sig *Signature, client *TSAClient) CodedError {
+	if cerr := msig.check_has_signature(sig); cerr != nil {
+		return cerr
+	}
+	if cerr := check_tsa_client(client); cerr != nil {
+		return cerr
+	}
+	ts, cerr := client.RequestTimeStamp(sig.base.Signature)
+	if cerr != nil {
+		return cerr
+	}
+	if cerr := sig.add_time_stamp_attr(idAaSignatureTimeStampToken, ts); cerr != nil {
+		return cerr
+	}
+	sig.TimeStamps = append(sig.TimeStamps, *ts)
+	return nil
+}
+
+// Returns an error if there is no client to request time stamps from.
+//
+// Possible errors are: ERR_NO_TSA_CLIENT
+func check_tsa_client(client *TSAClient) CodedError {
+	if client == nil {
+		return NewMultiError("no TSA client to request the time stamp from", ERR_NO_TSA_CLIENT, nil)
+	}
+	return nil
+}
+
+// Appends ts as an unsigned attribute of the given type (ex: idAaSignatureTimeStampToken).
+func (sig *Signature) add_time_stamp_attr(attr_type asn1.ObjectIdentifier, ts *TimeStamp) CodedError {
+	raw, cerr := ts.MarshalDER()
+	if cerr != nil {
+		return cerr
+	}
+
+	attr := attribute{}
+	attr.Type = attr_type
+	attr.Values = []interface{}{asn1.RawValue{FullBytes: raw}}
+	sig.base.UnsignedAttrs = append(sig.base.UnsignedAttrs, attr)
+	// Ensure it will be marshaled again (see sync_signer_infos)
+	sig.base.RawContent = nil
+	return nil
+}
+
+// Parses the signature, CAdES-C and archive time stamp tokens from the unsigned attributes.
+func (sig *Signature) load_time_stamps() CodedError {
+	var cerr CodedError
+	sig.TimeStamps, cerr = sig.parse_time_stamp_attrs(idAaSignatureTimeStampToken)
+	if cerr != nil {
+		return cerr
+	}
+	sig.RefsTimeStamps, cerr = sig.parse_time_stamp_attrs(idAaEtsEscTimeStamp)
+	if cerr != nil {
+		return cerr
+	}
+	sig.ArchiveTimeStamps, cerr = sig.parse_time_stamp_attrs(idAaEtsArchiveTimestampV2)
+	return cerr
+}
+
+func (sig Signature) parse_time_stamp_attrs(attr_type asn1.ObjectIdentifier) ([]TimeStamp, CodedError) {
+	ans := make([]TimeStamp, 0)
+	for _, attr := range sig.base.UnsignedAttrs {
+		if !attr.Type.Equal(attr_type) {
+			continue
+		}
+		for _, val := range attr.Values {
+			raw, ok := val.(asn1.RawValue)
+			if !ok {
+				continue
+			}
+			ts, cerr := NewTimeStampFromBytes(raw.FullBytes)
+			if cerr != nil {
+				return nil, cerr
+			}
+			ans = append(ans, *ts)
+		}
+	}
+	return ans, nil
+}
+
+// Failure reasons of a time stamp request. (see RFC 3161 Section 2.4.2)
+const (
+	PKI_FAILURE_BAD_ALG                = 0
+	PKI_FAILURE_BAD_REQUEST            = 2
+	PKI_FAILURE_BAD_DATA_FORMAT        = 5
+	PKI_FAILURE_TIME_NOT_AVAILABLE     = 14
+	PKI_FAILURE_UNACCEPTED_POLICY      = 15
+	PKI_FAILURE_UNACCEPTED_EXTENSION   = 16
+	PKI_FAILURE_ADD_INFO_NOT_AVAILABLE = 17
+	PKI_FAILURE_SYSTEM_FAILURE         = 25
+)
+
+// Options used by a TSA when issuing a time stamp token. (see PFX.IssueTimeStamp)
+type TimeStampOptions struct {
+	// OID of the TSA policy. Requests asking for other policies are rejected.
+	Policy string
+	// Accuracy of the TSA clock. Zero means it is not informed.
+	Accuracy time.Duration
+	// Serial number of the time stamp token. It MUST be positive and unique for each token issued by the TSA. (see RFC 3161 Section 2.4.2)
+	SerialNumber *big.Int
+	GenTime      time.Time
+}
+
+func new_rejection_resp(fail_info int, msg string) ([]byte, CodedError) {
+	resp := time_stamp_resp{}
+	resp.Status.Status = PKI_STATUS_REJECTION
+	resp.Status.StatusString = []asn1.RawValue{{Class: asn1.ClassUniversal, Tag: asn1.TagUTF8String, Bytes: []byte(msg)}}
+	resp.Status.FailInfo.Bytes = make([]byte, fail_info/8+1)
+	resp.Status.FailInfo.Bytes[fail_info/8] = 0x80 >> uint(fail_info%8)
+	resp.Status.FailInfo.BitLength = fail_info + 1
+	raw, err := asn1.Marshal(resp)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal time stamp response", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return raw, nil
+}
+
+// Returns nil if IssueTimeStamp would grant the DER encoded time stamp request (RFC 3161) under the given TSA policy, otherwise the DER encoded response rejecting it. This allows a TSA to reject requests before allocating a serial number for them.
+//
+// Possible errors are: ERR_INVALID_OID, ERR_FAILED_TO_ENCODE
+func CheckTimeStampRequest(req_raw []byte, policy string) ([]byte, CodedError) {
+	_, rejection, cerr := check_time_stamp_req(req_raw, policy)
+	return rejection, cerr
+}
+
+func check_time_stamp_req(req_raw []byte, policy string) (time_stamp_req, []byte, CodedError) {
+	req := time_stamp_req{}
+	policy_oid := str2oid_key(policy)
+	if len(policy_oid) < 2 {
+		merr := NewMultiError("invalid TSA policy OID", ERR_INVALID_OID, nil)
+		merr.SetParam("policy", policy)
+		return req, nil, merr
+	}
+	rest, err := asn1.Unmarshal(req_raw, &req)
+	if err != nil || len(rest) != 0 || req.Version != 1 {
+		rejection, cerr := new_rejection_resp(PKI_FAILURE_BAD_DATA_FORMAT, "invalid time stamp request")
+		return req, rejection, cerr
+	}
+	hasher, _, cerr := get_hasher(req.MessageImprint.HashAlgorithm)
+	if cerr != nil || hasher.Size() != len(req.MessageImprint.HashedMessage) {
+		rejection, cerr := new_rejection_resp(PKI_FAILURE_BAD_ALG, "unsupported hash algorithm")
+		return req, rejection, cerr
+	}
+	if req.ReqPolicy != nil && !req.ReqPolicy.Equal(policy_oid) {
+		rejection, cerr := new_rejection_resp(PKI_FAILURE_UNACCEPTED_POLICY, "unsupported policy")
+		return req, rejection, cerr
+	}
+	// No extension is supported
+	if len(req.Extensions) > 0 {
+		rejection, cerr := new_rejection_resp(PKI_FAILURE_UNACCEPTED_EXTENSION, "unsupported extension")
+		return req, rejection, cerr
+	}
+	return req, nil, nil
+}
+
+// Answers a DER encoded time stamp request (RFC 3161) acting as a TSA (Time Stamp Authority). The returned DER encoded response either grants a time stamp token signed by this PFX or rejects the request (ex: unsupported hash algorithm or policy). The PFX certificate should have the id-kp-timeStamping extended key usage.
+//
+// Errors are only returned when it is not possible to build a response at all. Possible errors are: ERR_INVALID_SERIAL, ERR_INVALID_OID, ERR_NO_PRIVATE_KEY, ERR_FAILED_TO_SIGN, ERR_FAILED_TO_ENCODE
+func (pfx PFX) IssueTimeStamp(req_raw []byte, opts TimeStampOptions) ([]byte, CodedError) {
+	if opts.SerialNumber == nil || opts.SerialNumber.Sign() <= 0 {
+		merr := NewMultiError("time stamp serial number must be positive", ERR_INVALID_SERIAL, nil)
+		merr.SetParam("opts.SerialNumber", opts.SerialNumber)
+		return nil, merr
+	}
+	req, rejection, cerr := check_time_stamp_req(req_raw, opts.Policy)
+	if cerr != nil || rejection != nil {
+		return rejection, cerr
+	}
+
+	// Build TSTInfo
+	info := tst_info{Version: 1, Policy: str2oid_key(opts.Policy)}
+	info.MessageImprint.HashAlgorithm = algorithm_identifier{Algorithm: req.MessageImprint.HashAlgorithm.Algorithm}
+	info.MessageImprint.HashedMessage = req.MessageImprint.HashedMessage
+	info.SerialNumber = opts.SerialNumber
+	info.GenTime = opts.GenTime.UTC()
+	info.Accuracy.Seconds = int(opts.Accuracy / time.Second)
+	info.Accuracy.Millis = int(opts.Accuracy % time.Second / time.Millisecond)
+	info.Accuracy.Micros = int(opts.Accuracy % time.Millisecond / time.Microsecond)
+	info.Nonce = req.Nonce
+	info_raw, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal TSTInfo", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+
+	// Sign it
+	token := new_mult_signature(info_raw, true)
+	token.base.EncapContentInfo.EContentType = idCtTSTInfo
+	if cerr := token.add_signature_at(pfx, nil, opts.GenTime); cerr != nil {
+		return nil, cerr
+	}
+	if !req.CertReq {
+		token.certs = nil
+		token.base.Certificates = nil
+	}
+	token_raw, cerr := token.MarshalDER()
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	resp := time_stamp_resp{}
+	resp.Status.Status = PKI_STATUS_GRANTED
+	resp.TimeStampToken.FullBytes = token_raw
+	raw, err := asn1.Marshal(resp)
+	if err != nil {
+		return nil, NewMultiError("failed to marshal time stamp response", ERR_FAILED_TO_ENCODE, nil, err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,339 @@
+package libICP
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A local TSA used instead of sending requests over the network.
+type test_tsa struct {
+	pfx    PFX
+	now    time.Time
+	serial int64
+	status int
+	// If set, the response will be about another digest
+	wrong_imprint bool
+	// If positive, requests are rejected after this many time stamps were issued
+	max_serial int64
+}
+
+func new_test_tsa(t *testing.T) *test_tsa {
+	pfx, cerr := NewPFXFromFile("data/test-chain/intermediate/fakebank/private/tsa.p12", "tsa")
+	require.Nil(t, cerr)
+	return &test_tsa{pfx: pfx, now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (tsa *test_tsa) client() *TSAClient {
+	client := NewTSAClient("http://tsa.example.com/")
+	client.Transport = tsa
+	return client
+}
+
+func (tsa *test_tsa) RoundTrip(http_req *http.Request) (*http.Response, error) {
+	raw, err := ioutil.ReadAll(http_req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if tsa.wrong_imprint {
+		req := time_stamp_req{}
+		if _, err := asn1.Unmarshal(raw, &req); err != nil {
+			return nil, err
+		}
+		req.RawContent = nil
+		req.MessageImprint.RawContent = nil
+		req.MessageImprint.HashedMessage = make([]byte, len(req.MessageImprint.HashedMessage))
+		if raw, err = asn1.Marshal(req); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp_raw []byte
+	var cerr CodedError
+	if tsa.status == PKI_STATUS_GRANTED && (tsa.max_serial <= 0 || tsa.serial < tsa.max_serial) {
+		tsa.serial++
+		opts := TimeStampOptions{Policy: "1.2.3.4", SerialNumber: big.NewInt(tsa.serial), GenTime: tsa.now}
+		resp_raw, cerr = tsa.pfx.IssueTimeStamp(raw, opts)
+	} else {
+		resp_raw, cerr = new_rejection_resp(PKI_FAILURE_SYSTEM_FAILURE, "test")
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/timestamp-reply"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(resp_raw)),
+		Request:    http_req,
+	}, nil
+}
+
+func Test_TSAClient_RequestTimeStamp_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	ts, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+	assert.Equal(t, tsa.now, ts.GenTime)
+	assert.Equal(t, "0x1", ts.Serial)
+	assert.Equal(t, "1.2.3.4", ts.Policy)
+	assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=FakeBank Certificados Digitais/CN=FakeBank Carimbo do Tempo", ts.TSA.Subject)
+}
+
+func Test_TSAClient_RequestTimeStamp_2(t *testing.T) {
+	tsa := new_test_tsa(t)
+	tsa.status = PKI_STATUS_REJECTION
+	_, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_TSA_REJECTED, cerr.Code())
+}
+
+func Test_TSAClient_RequestTimeStamp_3(t *testing.T) {
+	tsa := new_test_tsa(t)
+	tsa.wrong_imprint = true
+	_, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_PARSE_TIMESTAMP, cerr.Code())
+}
+
+func Test_MultSignature_AddTimeStamp_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytesWithPolicy([]byte("The quick fox jumps over the lazy dog."), true, get_test_policy(t))
+	require.Nil(t, cerr)
+	require.Nil(t, msig.AddTimeStamp(&msig.Signatures[0], tsa.client()))
+	require.Equal(t, 1, len(msig.Signatures[0].TimeStamps))
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	require.Equal(t, 1, len(sig.TimeStamps))
+	assert.Equal(t, tsa.now, sig.TimeStamps[0].GenTime)
+
+	store := get_test_store(t, true)
+	cerr = msig.check_all_at(store, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Nil(t, cerr)
+	sig = msig.Signatures[0]
+	assert.True(t, sig.Status.Integrity)
+	assert.True(t, sig.Status.IsPolicyCompliant(), "%v", sig.Status.PolicyErrors)
+	ts_status := sig.TimeStamps[0].Status
+	assert.True(t, ts_status.Integrity)
+	assert.True(t, ts_status.ImprintMatches)
+	assert.Nil(t, ts_status.TSACertError)
+	assert.True(t, ts_status.IsValid())
+}
+
+func Test_MultSignature_AddTimeStamp_2(t *testing.T) {
+	tsa := new_test_tsa(t)
+	pfx := get_ciclano_pfx(t)
+	msig, cerr := pfx.SignBytes([]byte("The quick fox jumps over the lazy dog."), true)
+	require.Nil(t, cerr)
+
+	// A copy of the signature
+	sig := msig.Signatures[0]
+	cerr = msig.AddTimeStamp(&sig, tsa.client())
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_SIGNATURE_NOT_FOUND, cerr.Code())
+	assert.EqualValues(t, 0, tsa.serial)
+
+	// No client
+	cerr = msig.AddTimeStamp(&msig.Signatures[0], nil)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_TSA_CLIENT, cerr.Code())
+	assert.Equal(t, 0, len(msig.Signatures[0].TimeStamps))
+}
+
+func Test_TimeStamp_CheckAt_1(t *testing.T) {
+	// Time stamp about something else
+	tsa := new_test_tsa(t)
+	ts, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+
+	store := get_test_store(t, true)
+	ts.check_at(store, []byte("bye"), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ts.Status.Integrity)
+	assert.False(t, ts.Status.ImprintMatches)
+	assert.False(t, ts.Status.IsValid())
+
+	ts.check_at(store, []byte("hello"), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ts.Status.IsValid())
+}
+
+func Test_TimeStamp_CheckAt_4(t *testing.T) {
+	// The TSA certificate is validated at the generation time, not at now
+	tsa := new_test_tsa(t)
+	ts, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+	store := get_test_store(t, true)
+	after_expiry := time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.True(t, after_expiry.After(ts.TSA.NotAfter))
+	ts.check_at(store, []byte("hello"), after_expiry)
+	assert.True(t, ts.Status.IsValid(), "%v", ts.Status.TSACertError)
+
+	// A generation time in the future is not trusted
+	ts.check_at(store, []byte("hello"), time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ts.Status.IsValid())
+}
+
+func Test_TimeStamp_CheckAt_2(t *testing.T) {
+	// Untrusted TSA
+	tsa := new_test_tsa(t)
+	ts, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+
+	store := NewCAStore(false)
+	ts.check_at(store, []byte("hello"), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ts.Status.ImprintMatches)
+	assert.NotNil(t, ts.Status.TSACertError)
+	assert.False(t, ts.Status.IsValid())
+}
+
+func Test_TimeStamp_CheckAt_3(t *testing.T) {
+	// Trusted certificate without the time stamping extended key usage
+	tsa := new_test_tsa(t)
+	tsa.pfx = get_ciclano_pfx(t)
+	ts, cerr := tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+
+	store := get_test_store(t, true)
+	ts.check_at(store, []byte("hello"), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ts.Status.Integrity)
+	assert.True(t, ts.Status.ImprintMatches)
+	require.NotNil(t, ts.Status.TSACertError)
+	assert.EqualValues(t, ERR_CERT_USAGE_NOT_ALLOWED, ts.Status.TSACertError.Code())
+
+	// The certificate policies of the store only apply to signers
+	store.CertPolicies.RequireExplicitPolicy = true
+	tsa = new_test_tsa(t)
+	ts, cerr = tsa.client().RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+	ts.check_at(store, []byte("hello"), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.True(t, ts.Status.IsValid())
+}
+
+func Test_PFX_IssueTimeStamp_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	req := time_stamp_req{Version: 1, CertReq: true, Nonce: big.NewInt(42)}
+	req.MessageImprint.HashAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	req.MessageImprint.HashedMessage = make([]byte, 32)
+	req_raw, err := asn1.Marshal(req)
+	require.Nil(t, err)
+
+	opts := TimeStampOptions{Policy: "1.2.3.4", SerialNumber: big.NewInt(7), GenTime: tsa.now, Accuracy: 1500 * time.Millisecond}
+	resp_raw, cerr := tsa.pfx.IssueTimeStamp(req_raw, opts)
+	require.Nil(t, cerr)
+	resp := time_stamp_resp{}
+	_, err = asn1.Unmarshal(resp_raw, &resp)
+	require.Nil(t, err)
+	require.Equal(t, PKI_STATUS_GRANTED, resp.Status.Status)
+
+	ts, cerr := NewTimeStampFromBytes(resp.TimeStampToken.FullBytes)
+	require.Nil(t, cerr)
+	assert.Equal(t, "0x7", ts.Serial)
+	assert.Equal(t, tsa.now, ts.GenTime)
+	assert.Equal(t, 1, ts.info.Accuracy.Seconds)
+	assert.Equal(t, 500, ts.info.Accuracy.Millis)
+	assert.Equal(t, 0, ts.info.Accuracy.Micros)
+	assert.EqualValues(t, 42, ts.info.Nonce.Int64())
+	_, ok := ts.token.Signatures[0].base.get_signed_attr(idAaSigningCertificateV2)
+	assert.True(t, ok)
+}
+
+func Test_PFX_IssueTimeStamp_2(t *testing.T) {
+	tsa := new_test_tsa(t)
+	opts := TimeStampOptions{Policy: "1.2.3.4", SerialNumber: big.NewInt(1), GenTime: tsa.now}
+
+	// Invalid request
+	resp_raw, cerr := tsa.pfx.IssueTimeStamp([]byte("hello"), opts)
+	require.Nil(t, cerr)
+	resp := time_stamp_resp{}
+	_, err := asn1.Unmarshal(resp_raw, &resp)
+	require.Nil(t, err)
+	assert.Equal(t, PKI_STATUS_REJECTION, resp.Status.Status)
+	assert.Equal(t, 1, resp.Status.FailInfo.At(PKI_FAILURE_BAD_DATA_FORMAT))
+
+	// Unsupported policy
+	req := time_stamp_req{Version: 1, ReqPolicy: asn1.ObjectIdentifier{1, 2, 3, 5}}
+	req.MessageImprint.HashAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	req.MessageImprint.HashedMessage = make([]byte, 32)
+	req_raw, err := asn1.Marshal(req)
+	require.Nil(t, err)
+	resp_raw, cerr = tsa.pfx.IssueTimeStamp(req_raw, opts)
+	require.Nil(t, cerr)
+	resp = time_stamp_resp{}
+	_, err = asn1.Unmarshal(resp_raw, &resp)
+	require.Nil(t, err)
+	assert.Equal(t, PKI_STATUS_REJECTION, resp.Status.Status)
+	assert.Equal(t, 1, resp.Status.FailInfo.At(PKI_FAILURE_UNACCEPTED_POLICY))
+
+	// Wrong digest size
+	req.ReqPolicy = nil
+	req.MessageImprint.HashedMessage = make([]byte, 20)
+	req_raw, err = asn1.Marshal(req)
+	require.Nil(t, err)
+	resp_raw, cerr = tsa.pfx.IssueTimeStamp(req_raw, opts)
+	require.Nil(t, cerr)
+	resp = time_stamp_resp{}
+	_, err = asn1.Unmarshal(resp_raw, &resp)
+	require.Nil(t, err)
+	assert.Equal(t, 1, resp.Status.FailInfo.At(PKI_FAILURE_BAD_ALG))
+
+	// Unsupported extension
+	req.MessageImprint.HashedMessage = make([]byte, 32)
+	req.Extensions = []extension{{ExtnID: asn1.ObjectIdentifier{1, 2, 3, 6}, ExtnValue: []byte{5, 0}}}
+	req_raw, err = asn1.Marshal(req)
+	require.Nil(t, err)
+	resp_raw, cerr = tsa.pfx.IssueTimeStamp(req_raw, opts)
+	require.Nil(t, cerr)
+	resp = time_stamp_resp{}
+	_, err = asn1.Unmarshal(resp_raw, &resp)
+	require.Nil(t, err)
+	assert.Equal(t, PKI_STATUS_REJECTION, resp.Status.Status)
+	assert.Equal(t, 1, resp.Status.FailInfo.At(PKI_FAILURE_UNACCEPTED_EXTENSION))
+}
+
+func Test_PFX_IssueTimeStamp_3(t *testing.T) {
+	// Invalid serial numbers
+	tsa := new_test_tsa(t)
+	req := time_stamp_req{Version: 1}
+	req.MessageImprint.HashAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	req.MessageImprint.HashedMessage = make([]byte, 32)
+	req_raw, err := asn1.Marshal(req)
+	require.Nil(t, err)
+	for _, serial := range []*big.Int{nil, big.NewInt(0), big.NewInt(-1)} {
+		opts := TimeStampOptions{Policy: "1.2.3.4", SerialNumber: serial, GenTime: tsa.now}
+		_, cerr := tsa.pfx.IssueTimeStamp(req_raw, opts)
+		require.NotNil(t, cerr, "%v", serial)
+		assert.EqualValues(t, ERR_INVALID_SERIAL, cerr.Code())
+	}
+}
+
+func Test_CheckTimeStampRequest_1(t *testing.T) {
+	req := time_stamp_req{Version: 1}
+	req.MessageImprint.HashAlgorithm = algorithm_identifier{Algorithm: idSha256}
+	req.MessageImprint.HashedMessage = make([]byte, 32)
+	req_raw, err := asn1.Marshal(req)
+	require.Nil(t, err)
+	rejection, cerr := CheckTimeStampRequest(req_raw, "1.2.3.4")
+	require.Nil(t, cerr)
+	assert.Nil(t, rejection)
+
+	rejection, cerr = CheckTimeStampRequest([]byte("hello"), "1.2.3.4")
+	require.Nil(t, cerr)
+	assert.NotNil(t, rejection)
+
+	for _, policy := range []string{"", "1", "1.2.x"} {
+		_, cerr = CheckTimeStampRequest(req_raw, policy)
+		require.NotNil(t, cerr, policy)
+		assert.EqualValues(t, ERR_INVALID_OID, cerr.Code())
+	}
+}
@@ -0,0 +1,165 @@
+// Package tsa implements a RFC 3161 Time Stamp Authority which issues time stamp tokens signed by a libICP.PFX.
+//
+// It is intended for staging environments and tests, NOT as a replacement for an accredited ICP-Brasil TSA.
+package tsa
+
+import (
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenICP-BR/libICP"
+)
+
+// Maximum size of a time stamp request.
+const MAX_REQUEST_SIZE = 64 * 1024
+
+// A Time Stamp Authority. It implements http.Handler, so it can be mounted on any path of an existing HTTP server.
+type Server struct {
+	pfx libICP.PFX
+	// OID of the TSA policy included in every token. Requests asking for other policies are rejected.
+	Policy string
+	// Accuracy of the server clock. Zero means it is not informed.
+	Accuracy time.Duration
+
+	serial_file string
+	serial      *big.Int
+	lock        sync.Mutex
+	// Only replaced on tests
+	now func() time.Time
+}
+
+// Creates a TSA whose tokens are signed by pfx. The last issued serial number is kept (in decimal) at serial_file, which is created if it does not exist. If serial_file is empty, serial numbers are only kept in memory.
+//
+// Possible errors are: ERR_NO_PRIVATE_KEY, ERR_INVALID_OID, ERR_READ_FILE
+func NewServer(pfx libICP.PFX, policy string, serial_file string) (*Server, libICP.CodedError) {
+	if !pfx.HasKey() || pfx.Cert == nil {
+		return nil, libICP.NewMultiError("PFX has no private key or certificate", libICP.ERR_NO_PRIVATE_KEY, nil)
+	}
+	if !is_oid(policy) {
+		merr := libICP.NewMultiError("invalid TSA policy OID", libICP.ERR_INVALID_OID, nil)
+		merr.SetParam("policy", policy)
+		return nil, merr
+	}
+	srv := &Server{pfx: pfx, Policy: policy, serial_file: serial_file, serial: big.NewInt(0), now: time.Now}
+
+	if serial_file == "" {
+		return srv, nil
+	}
+	dat, err := ioutil.ReadFile(serial_file)
+	if os.IsNotExist(err) {
+		return srv, nil
+	}
+	if err != nil {
+		merr := libICP.NewMultiError("failed to read serial number file", libICP.ERR_READ_FILE, nil, err)
+		merr.SetParam("serial_file", serial_file)
+		return nil, merr
+	}
+	if _, ok := srv.serial.SetString(strings.TrimSpace(string(dat)), 10); !ok || srv.serial.Sign() < 0 {
+		merr := libICP.NewMultiError("invalid serial number file", libICP.ERR_READ_FILE, nil)
+		merr.SetParam("serial_file", serial_file)
+		return nil, merr
+	}
+	return srv, nil
+}
+
+// Accepts dotted decimal OIDs with at least two arcs. Ex: "2.16.76.1.3"
+func is_oid(s string) bool {
+	arcs := strings.Split(s, ".")
+	if len(arcs) < 2 {
+		return false
+	}
+	for _, arc := range arcs {
+		if _, err := strconv.ParseUint(arc, 10, 31); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the next serial number and persists it (if there is a serial file) before it is used.
+//
+// Possible errors are: ERR_FAILED_TO_WRITE_FILE
+func (srv *Server) next_serial() (*big.Int, libICP.CodedError) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	serial := new(big.Int).Add(srv.serial, big.NewInt(1))
+	if srv.serial_file != "" {
+		// Write to a temporary file first, so a crash never leaves a truncated file behind
+		tmp := srv.serial_file + ".tmp"
+		err := ioutil.WriteFile(tmp, []byte(serial.String()+"\n"), 0600)
+		if err == nil {
+			err = os.Rename(tmp, srv.serial_file)
+		}
+		if err != nil {
+			merr := libICP.NewMultiError("failed to save serial number", libICP.ERR_FAILED_TO_WRITE_FILE, nil, err)
+			merr.SetParam("serial_file", srv.serial_file)
+			return nil, merr
+		}
+	}
+	srv.serial = serial
+	return new(big.Int).Set(serial), nil
+}
+
+// Answers a DER encoded time stamp request with a DER encoded time stamp response. Rejected requests do not use up a serial number.
+//
+// Possible errors are: ERR_FAILED_TO_WRITE_FILE and the ones from libICP.PFX.IssueTimeStamp
+func (srv *Server) Respond(req []byte) ([]byte, libICP.CodedError) {
+	rejection, cerr := libICP.CheckTimeStampRequest(req, srv.Policy)
+	if cerr != nil || rejection != nil {
+		return rejection, cerr
+	}
+	serial, cerr := srv.next_serial()
+	if cerr != nil {
+		return nil, cerr
+	}
+	opts := libICP.TimeStampOptions{
+		Policy:       srv.Policy,
+		Accuracy:     srv.Accuracy,
+		SerialNumber: serial,
+		GenTime:      srv.now(),
+	}
+	return srv.pfx.IssueTimeStamp(req, opts)
+}
+
+// Implements the HTTP transport described on RFC 3161 Section 3.4
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/timestamp-query" {
+		http.Error(w, "content type must be application/timestamp-query", http.StatusUnsupportedMediaType)
+		return
+	}
+	req, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE+1))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	if len(req) > MAX_REQUEST_SIZE {
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp, cerr := srv.Respond(req)
+	if cerr != nil {
+		http.Error(w, "failed to issue time stamp", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp)
+}
+
+// Serves the TSA at the root path of addr. Ex: ":3161"
+func (srv *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, srv)
+}
@@ -0,0 +1,126 @@
+package tsa
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenICP-BR/libICP"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get_tsa_pfx(t *testing.T) libICP.PFX {
+	pfx, cerr := libICP.NewPFXFromFile("../data/test-chain/intermediate/fakebank/private/tsa.p12", "tsa")
+	require.Nil(t, cerr)
+	return pfx
+}
+
+func Test_NewServer_1(t *testing.T) {
+	_, cerr := NewServer(libICP.PFX{}, "1.2.3.4", "")
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, libICP.ERR_NO_PRIVATE_KEY, cerr.Code())
+	for _, policy := range []string{"", "1", "1.2.x", "1..2", "1.-2"} {
+		_, cerr = NewServer(get_tsa_pfx(t), policy, "")
+		require.NotNil(t, cerr, policy)
+		assert.EqualValues(t, libICP.ERR_INVALID_OID, cerr.Code())
+	}
+}
+
+func Test_Server_ServeHTTP_1(t *testing.T) {
+	srv, err := NewServer(get_tsa_pfx(t), "1.2.3.4", "")
+	require.Nil(t, err)
+	gen_time := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	srv.now = func() time.Time { return gen_time }
+	http_srv := httptest.NewServer(srv)
+	defer http_srv.Close()
+
+	client := libICP.NewTSAClient(http_srv.URL)
+	for i := 1; i <= 2; i++ {
+		ts, cerr := client.RequestTimeStamp([]byte("hello"))
+		require.Nil(t, cerr)
+		assert.Equal(t, gen_time, ts.GenTime)
+		assert.Equal(t, fmt.Sprintf("0x%d", i), ts.Serial)
+		assert.Equal(t, "1.2.3.4", ts.Policy)
+		assert.Equal(t, "C=BR/O=Fake-ICP-Brasil/OU=FakeBank Certificados Digitais/CN=FakeBank Carimbo do Tempo", ts.TSA.Subject)
+	}
+
+	// Unsupported policy
+	client.Policy = "1.2.3.5"
+	_, cerr := client.RequestTimeStamp([]byte("hello"))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, libICP.ERR_TSA_REJECTED, cerr.Code())
+
+	// Rejected requests do not use up serial numbers
+	client.Policy = ""
+	ts, cerr := client.RequestTimeStamp([]byte("hello"))
+	require.Nil(t, cerr)
+	assert.Equal(t, "0x3", ts.Serial)
+}
+
+func Test_Server_ServeHTTP_2(t *testing.T) {
+	srv, err := NewServer(get_tsa_pfx(t), "1.2.3.4", "")
+	require.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	// Invalid requests still get a (rejection) response
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	srv.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/timestamp-reply", rec.Header().Get("Content-Type"))
+}
+
+func Test_Server_NextSerial_1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libICP-tsa")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "serial")
+
+	srv, err := NewServer(get_tsa_pfx(t), "1.2.3.4", path)
+	require.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = srv.next_serial()
+		require.Nil(t, err)
+	}
+	dat, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	assert.Equal(t, "3\n", string(dat))
+
+	// The serial must survive restarts
+	srv, err = NewServer(get_tsa_pfx(t), "1.2.3.4", path)
+	require.Nil(t, err)
+	serial, err := srv.next_serial()
+	require.Nil(t, err)
+	assert.Equal(t, "4", serial.String())
+}
+
+func Test_Server_NextSerial_2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libICP-tsa")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "serial")
+	require.Nil(t, ioutil.WriteFile(path, []byte("not a number"), 0600))
+
+	_, err = NewServer(get_tsa_pfx(t), "1.2.3.4", path)
+	assert.NotNil(t, err)
+
+	// Serial numbers must be positive
+	require.Nil(t, ioutil.WriteFile(path, []byte("-5\n"), 0600))
+	_, err = NewServer(get_tsa_pfx(t), "1.2.3.4", path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,173 @@
+package libICP
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/OpenICP-BR/asn1"
+)
+
+// ICP-Brasil signature levels (see DOC-ICP-15). Each one includes everything the previous one has.
+type SignatureLevel int
+
+const (
+	// Basic reference
+	AD_RB SignatureLevel = iota
+	// Time reference (signature time stamp)
+	AD_RT
+	// References for validation (complete certificate and revocation references)
+	AD_RV
+	// Complete references (certificate and revocation values)
+	AD_RC
+	// References for archival (archive time stamp)
+	AD_RA
+)
+
+var level_map_string = map[SignatureLevel]string{
+	AD_RB: "AD-RB",
+	AD_RT: "AD-RT",
+	AD_RV: "AD-RV",
+	AD_RC: "AD-RC",
+	AD_RA: "AD-RA",
+}
+
+func (level SignatureLevel) String() string {
+	ans, ok := level_map_string[level]
+	if !ok {
+		ans = "AD_" + strconv.Itoa(int(level))
+	}
+	return ans
+}
+
+// Returns the highest level whose unsigned attributes (and the ones of all previous levels) are present.
+func (sig Signature) Level() SignatureLevel {
+	has := func(attr_types ...asn1.ObjectIdentifier) bool {
+		for _, attr_type := range attr_types {
+			if _, ok := sig.get_unsigned_attr(attr_type); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case !has(idAaSignatureTimeStampToken):
+		return AD_RB
+	case !has(idAaEtsCertificateRefs, idAaEtsRevocationRefs, idAaEtsEscTimeStamp):
+		return AD_RT
+	case !has(idAaEtsCertValues, idAaEtsRevocationValues):
+		return AD_RV
+	case !has(idAaEtsArchiveTimestampV2):
+		return AD_RC
+	}
+	return AD_RA
+}
+
+// Raises all signatures (but not counter signatures) to the target level by adding only the missing unsigned attributes. Signed attributes are never changed, so the original signatures remain valid. Signatures already at (or above) the target level are left untouched.
+//
+// The signatures are verified before anything is added and, if any of them is not valid, nothing is changed. The attributes are added to a copy of this file which replaces it only after all signatures were upgraded, so nothing is changed on failure either. The client is used to request the time stamps, so it may only be nil if no time stamp is needed.
+//
+// Possible errors are: ERR_UNKNOWN_SIGNATURE_LEVEL, ERR_NO_TSA_CLIENT, ERR_NO_CONTENT, ERR_BAD_SIGNATURE, the ones from CAStore.VerifyCert, AddTimeStamp, AddValidationRefs, AddValidationValues and AddArchiveTimeStamp
+func (msig *MultSignature) Upgrade(target SignatureLevel, store *CAStore, client *TSAClient) CodedError {
+	return msig.upgrade_at(target, store, client, time.Now())
+}
+
+func (msig *MultSignature) upgrade_at(target SignatureLevel, store *CAStore, client *TSAClient, now time.Time) CodedError {
+	if _, ok := level_map_string[target]; !ok {
+		merr := NewMultiError("unknown signature level", ERR_UNKNOWN_SIGNATURE_LEVEL, nil)
+		merr.SetParam("target", int(target))
+		return merr
+	}
+	if client == nil {
+		for _, sig := range msig.Signatures {
+			if needs_time_stamp(sig.Level(), target) {
+				merr := NewMultiError("a TSA client is needed to upgrade the signature", ERR_NO_TSA_CLIENT, nil)
+				merr.SetParam("target", target.String())
+				return merr
+			}
+		}
+	}
+
+	// Never upgrade invalid signatures
+	if cerr := msig.check_all_at(store, now); cerr != nil {
+		return cerr
+	}
+	for _, sig := range msig.Signatures {
+		if !sig.Status.Integrity {
+			merr := NewMultiError("signature is not valid", ERR_BAD_SIGNATURE, nil)
+			merr.SetParam("Signer.Subject", sig.Signer.Subject)
+			return merr
+		}
+		if sig.Status.SignerCertError != nil {
+			return sig.Status.SignerCertError
+		}
+	}
+
+	work := msig.clone()
+	for i := range work.Signatures {
+		sig := &work.Signatures[i]
+		level := sig.Level()
+		if level < AD_RT && target >= AD_RT {
+			if cerr := work.AddTimeStamp(sig, client); cerr != nil {
+				return cerr
+			}
+		}
+		if level < AD_RV && target >= AD_RV {
+			if cerr := work.add_validation_refs_at(sig, store, client, now); cerr != nil {
+				return cerr
+			}
+		}
+		if level < AD_RC && target >= AD_RC {
+			if cerr := work.add_validation_values_at(sig, store, now); cerr != nil {
+				return cerr
+			}
+		}
+		if level < AD_RA && target >= AD_RA {
+			if cerr := work.AddArchiveTimeStamp(sig, client); cerr != nil {
+				return cerr
+			}
+		}
+	}
+	*msig = *work
+	return nil
+}
+
+// Returns true if raising a signature from level to target requires requesting at least one time stamp. Only going from AD-RV to AD-RC does not.
+func needs_time_stamp(level, target SignatureLevel) bool {
+	return level < target && !(level >= AD_RV && target == AD_RC)
+}
+
+// Returns a copy of msig which may have signatures, certificates and CRLs added to it without changing msig.
+func (msig *MultSignature) clone() *MultSignature {
+	ans := *msig
+	ans.certs = append([]*Certificate(nil), msig.certs...)
+	ans.base.Certificates = append([]certificate_choice(nil), msig.base.Certificates...)
+	ans.base.CRLs = append([]revocation_info_choice(nil), msig.base.CRLs...)
+	ans.base.SignerInfos = append([]signer_info_raw(nil), msig.base.SignerInfos...)
+	ans.Signatures = clone_signatures(msig.Signatures)
+	return &ans
+}
+
+// Returns a copy of sigs whose unsigned attributes (and everything derived from them) may be changed without changing sigs.
+func clone_signatures(sigs []Signature) []Signature {
+	if sigs == nil {
+		return nil
+	}
+	ans := make([]Signature, len(sigs))
+	for i, sig := range sigs {
+		if sig.base.UnsignedAttrs != nil {
+			sig.base.UnsignedAttrs = make([]attribute, len(sigs[i].base.UnsignedAttrs))
+			for j, attr := range sigs[i].base.UnsignedAttrs {
+				attr.Values = append([]interface{}(nil), attr.Values...)
+				sig.base.UnsignedAttrs[j] = attr
+			}
+		}
+		sig.values_certs = append([]*Certificate(nil), sig.values_certs...)
+		sig.values_crls = append([]certificate_list(nil), sig.values_crls...)
+		sig.CounterSigns = clone_signatures(sig.CounterSigns)
+		sig.TimeStamps = append([]TimeStamp(nil), sig.TimeStamps...)
+		sig.RefsTimeStamps = append([]TimeStamp(nil), sig.RefsTimeStamps...)
+		sig.ArchiveTimeStamps = append([]TimeStamp(nil), sig.ArchiveTimeStamps...)
+		ans[i] = sig
+	}
+	return ans
+}
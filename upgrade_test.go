@@ -0,0 +1,126 @@
+package libICP
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SignatureLevel_String_1(t *testing.T) {
+	assert.Equal(t, "AD-RB", AD_RB.String())
+	assert.Equal(t, "AD-RA", AD_RA.String())
+	assert.Equal(t, "AD_42", SignatureLevel(42).String())
+}
+
+func Test_MultSignature_Upgrade_1(t *testing.T) {
+	tsa := new_test_tsa(t)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	assert.Equal(t, AD_RB, msig.Signatures[0].Level())
+	signed_raw := msig.Signatures[0].base.SignedRaw
+	signature := msig.Signatures[0].base.Signature
+
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.upgrade_at(AD_RA, get_test_store_with_crl(t), tsa.client(), now))
+	assert.Equal(t, AD_RA, msig.Signatures[0].Level())
+
+	// Marshal and parse it again
+	raw, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	msig, cerr = NewMultSignatureFromBytes(raw)
+	require.Nil(t, cerr)
+	sig := msig.Signatures[0]
+	assert.Equal(t, AD_RA, sig.Level())
+	assert.Equal(t, signed_raw, sig.base.SignedRaw)
+	assert.Equal(t, signature, sig.base.Signature)
+
+	cerr = msig.check_all_at(get_test_store_with_crl(t), now)
+	require.Nil(t, cerr)
+	sig = msig.Signatures[0]
+	assert.True(t, sig.Status.Integrity)
+	assert.True(t, sig.Status.AreRefsValid(), "%v", sig.Status.RefsErrors)
+	require.Equal(t, 1, len(sig.TimeStamps))
+	require.Equal(t, 1, len(sig.RefsTimeStamps))
+	require.Equal(t, 1, len(sig.ArchiveTimeStamps))
+	assert.True(t, sig.TimeStamps[0].Status.IsValid())
+	assert.True(t, sig.RefsTimeStamps[0].Status.IsValid())
+	assert.True(t, sig.ArchiveTimeStamps[0].Status.IsValid())
+}
+
+func Test_MultSignature_Upgrade_2(t *testing.T) {
+	// Only the missing attributes are added
+	tsa := new_test_tsa(t)
+	msig := get_test_adrt(t, tsa)
+	now := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Nil(t, msig.upgrade_at(AD_RV, get_test_store_with_crl(t), tsa.client(), now))
+	sig := msig.Signatures[0]
+	assert.Equal(t, AD_RV, sig.Level())
+	assert.Equal(t, 1, len(sig.TimeStamps))
+	assert.Equal(t, 1, len(sig.RefsTimeStamps))
+	assert.Equal(t, 0, len(sig.ArchiveTimeStamps))
+
+	// Nothing to do
+	before, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	require.Nil(t, msig.upgrade_at(AD_RT, get_test_store_with_crl(t), nil, now))
+	after, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.True(t, bytes.Equal(before, after))
+}
+
+func Test_MultSignature_Upgrade_3(t *testing.T) {
+	// Invalid signature
+	tsa := new_test_tsa(t)
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	msig.base.EncapContentInfo.EContent = []byte("The quick fox jumps over the lazy cat.")
+	cerr = msig.upgrade_at(AD_RT, get_test_store(t, true), tsa.client(), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_BAD_SIGNATURE, cerr.Code())
+	assert.Equal(t, AD_RB, msig.Signatures[0].Level())
+}
+
+func Test_MultSignature_Upgrade_4(t *testing.T) {
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	cerr = msig.Upgrade(SignatureLevel(42), get_test_store(t, true), nil)
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_UNKNOWN_SIGNATURE_LEVEL, cerr.Code())
+}
+
+func Test_MultSignature_Upgrade_5(t *testing.T) {
+	// No TSA client
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	before, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	cerr = msig.upgrade_at(AD_RT, get_test_store_with_crl(t), nil, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_NO_TSA_CLIENT, cerr.Code())
+	after, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.True(t, bytes.Equal(before, after))
+}
+
+func Test_MultSignature_Upgrade_6(t *testing.T) {
+	// The TSA fails after the signature time stamp was added
+	tsa := new_test_tsa(t)
+	tsa.max_serial = 1
+	msig, cerr := NewMultSignatureFromFile("data/test-sigs/ciclano_attached.p7s")
+	require.Nil(t, cerr)
+	before, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	cerr = msig.upgrade_at(AD_RA, get_test_store_with_crl(t), tsa.client(), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NotNil(t, cerr)
+	assert.EqualValues(t, ERR_TSA_REJECTED, cerr.Code())
+	assert.EqualValues(t, 1, tsa.serial)
+	sig := msig.Signatures[0]
+	assert.Equal(t, AD_RB, sig.Level())
+	assert.Equal(t, 0, len(sig.TimeStamps))
+	after, cerr := msig.MarshalDER()
+	require.Nil(t, cerr)
+	assert.True(t, bytes.Equal(before, after))
+}